@@ -1,10 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kennedymj97/todo-api"
 	"github.com/kennedymj97/todo-api/http"
+	"github.com/kennedymj97/todo-api/jwt"
+	"github.com/kennedymj97/todo-api/mail"
 	"github.com/kennedymj97/todo-api/postgres"
+	"github.com/kennedymj97/todo-api/reminder"
+	"github.com/kennedymj97/todo-api/webhook"
 )
 
 func main() {
@@ -17,13 +27,159 @@ func main() {
 	defer dbClient.Close()
 
 	// Create new handler
+	hub := http.NewHub()
+	webhooks := webhook.NewDispatcher()
+	webhooks.WebhookService = dbClient.WebhookService()
+
+	// Publish outbox events and deliver queued webhooks in the background.
+	// Every replica hears about every event for its own websocket clients.
+	stop := make(chan struct{})
+	defer close(stop)
+	events := dbClient.Dispatcher()
+	events.Subscribe("webhooks", webhooks.HandleEvent)
+	events.Subscribe("notifications", dbClient.NotificationService().Notify)
+	go events.Run(stop)
+	go webhooks.Run(stop)
+	listener := dbClient.Listener()
+	listener.Subscribe(hub.HandleEvent)
+	go listener.Run(stop)
+
+	// Email is queued and sent in the background
+	mailer := mail.NewQueue(mail.NewMailerFromEnv(), 1000)
+	templates := mail.NewTemplates()
+	go mailer.Run(stop)
+
+	// Send due-date reminders in the background
+	reminders := reminder.NewScheduler()
+	reminders.ReminderService = dbClient.ReminderService()
+	reminders.Notifiers[todo.ChannelInApp] = dbClient.InAppNotifier()
+	reminders.Notifiers[todo.ChannelEmail] = &mail.ReminderNotifier{Mailer: mailer, Templates: templates}
+	reminders.Notifiers[todo.ChannelWebhook] = webhooks
+	go reminders.Run(stop)
+
 	taskHandler := http.NewTaskHandler()
 	userHandler := http.NewUserHandler()
+	projectHandler := http.NewProjectHandler()
+	socketHandler := http.NewSocketHandler()
+	webhookHandler := http.NewWebhookHandler()
+	commentHandler := http.NewCommentHandler()
+	notificationHandler := http.NewNotificationHandler()
+	reminderHandler := http.NewReminderHandler()
+	adminHandler := http.NewAdminHandler()
+	oauthHandler := http.NewOAuthHandler()
 	taskHandler.TaskService = dbClient.TaskService()
+	if size, ok := os.LookupEnv("BATCHMAXSIZE"); ok {
+		taskHandler.MaxBatchSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	userHandler.UserService = dbClient.UserService()
+	userHandler.MFAService = dbClient.MFAService()
+	userHandler.LoginAttempts = dbClient.LoginAttemptService()
+	// TRUSTEDPROXIES is a comma separated list of the addresses or CIDR
+	// ranges of proxies in front of the api. Without it every client behind
+	// a proxy shares the proxy's address, and its failed logins
+	if proxies, ok := os.LookupEnv("TRUSTEDPROXIES"); ok {
+		userHandler.TrustedProxies, err = http.ParseTrustedProxies(proxies)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	userHandler.APITokenService = dbClient.APITokenService()
+	userHandler.Mailer = mailer
+	userHandler.Templates = templates
+	if url, ok := os.LookupEnv("APPURL"); ok {
+		userHandler.AppURL = url
+	}
+	userHandler.VerifyKey = []byte(os.Getenv("VERIFYKEY"))
+	if len(userHandler.VerifyKey) == 0 {
+		log.Println("VERIFYKEY not set, verification links will stop working on restart")
+		userHandler.VerifyKey = make([]byte, 32)
+		if _, err := rand.Read(userHandler.VerifyKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	userHandler.CSRFKey = []byte(os.Getenv("CSRFKEY"))
+	if len(userHandler.CSRFKey) == 0 {
+		log.Println("CSRFKEY not set, csrf tokens will stop working on restart")
+		userHandler.CSRFKey = make([]byte, 32)
+		if _, err := rand.Read(userHandler.CSRFKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// SECURECOOKIES should be set in production, where the api is served
+	// over https
+	userHandler.SecureCookies = os.Getenv("SECURECOOKIES") == "true"
+	if mode, ok := os.LookupEnv("COOKIESAMESITE"); ok {
+		userHandler.SameSite, err = http.ParseSameSite(mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Browsers only accept SameSite=None on secure cookies
+		if mode == "none" {
+			userHandler.SecureCookies = true
+		}
+	}
+	// JWTKEYS turns on token logins, the first key signs new tokens
+	if keys, ok := os.LookupEnv("JWTKEYS"); ok {
+		userHandler.JWTKeys, err = jwt.ParseKeys(keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		userHandler.RefreshTokenService = dbClient.RefreshTokenService()
+	}
+	// Unverified accounts are restricted unless REQUIREVERIFIED lists other
+	// routes, or is set to "none"
+	requireVerified := http.DefaultRequireVerified
+	if routes, ok := os.LookupEnv("REQUIREVERIFIED"); ok {
+		requireVerified = nil
+		if routes != "none" {
+			requireVerified = strings.Split(routes, ",")
+		}
+	}
+	// CORSORIGINS is a comma separated list of the origins browsers may call
+	// the api from, "*." allows subdomains e.g. https://*.mattkennedy.io
+	cors := *http.DefaultCORS
+	if origins, ok := os.LookupEnv("CORSORIGINS"); ok {
+		cors.AllowedOrigins = strings.Split(origins, ",")
+	}
+	if maxAge, ok := os.LookupEnv("CORSMAXAGE"); ok {
+		cors.MaxAge, err = time.ParseDuration(maxAge)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	projectHandler.ProjectService = dbClient.ProjectService()
+	socketHandler.TaskService = dbClient.TaskService()
+	socketHandler.ProjectService = dbClient.ProjectService()
+	socketHandler.Hub = hub
+	socketHandler.CORS = &cors
+	webhookHandler.WebhookService = dbClient.WebhookService()
+	commentHandler.CommentService = dbClient.CommentService()
+	notificationHandler.NotificationService = dbClient.NotificationService()
+	reminderHandler.ReminderService = dbClient.ReminderService()
+	adminHandler.UserService = dbClient.UserService()
+	adminHandler.LoginAttemptService = dbClient.LoginAttemptService()
+	adminHandler.AuditService = dbClient.AuditService()
+	oauthHandler.OAuthService = dbClient.OAuthService()
 
 	s := http.InitServer()
-	s.Handler = &http.Handler{TaskHandler: taskHandler, UserHandler: userHandler}
+	s.Handler = &http.Handler{
+		TaskHandler:         taskHandler,
+		UserHandler:         userHandler,
+		ProjectHandler:      projectHandler,
+		SocketHandler:       socketHandler,
+		WebhookHandler:      webhookHandler,
+		CommentHandler:      commentHandler,
+		NotificationHandler: notificationHandler,
+		ReminderHandler:     reminderHandler,
+		AdminHandler:        adminHandler,
+		OAuthHandler:        oauthHandler,
+		CORS:                &cors,
+		IdempotencyService:  dbClient.IdempotencyService(),
+		RequireVerified:     requireVerified,
+	}
 
 	log.Fatal(s.ListenAndServe())
 }
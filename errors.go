@@ -1,15 +1,59 @@
 package todo
 
+import "strings"
+
 // Error represents a todo error
 type Error string
 
 // Error returns the error message
 func (e Error) Error() string { return string(e) }
 
+// FieldError is what's wrong with one field of a request.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Field + ": " + e.Err.Error() }
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// ValidationError lists every field of a request that's wrong.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, field := range e {
+		msgs[i] = field.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Is matches the error of any of the fields, so errors.Is(err,
+// ErrTaskContentRequired) still works when other fields are wrong too.
+func (e ValidationError) Is(target error) bool {
+	for _, field := range e {
+		if field.Err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Validation returns errs as a ValidationError, or nil if there aren't any.
+func Validation(errs []*FieldError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return ValidationError(errs)
+}
+
 // General errors
 const (
-	ErrInternal     = Error("internal error")
-	ErrUnauthorized = Error("user is not authorized")
+	ErrInternal         = Error("internal error")
+	ErrUnauthorized     = Error("user is not authorized")
+	ErrPermissionDenied = Error("permission denied")
+	ErrNotFound         = Error("not found")
 )
 
 // Database errors
@@ -23,7 +67,24 @@ const (
 
 // http errors
 const (
-	ErrInvalidJSON = Error("invalid json")
+	ErrInvalidJSON      = Error("invalid json")
+	ErrMethodNotAllowed = Error("method not allowed")
+	ErrMediaType        = Error("unsupported media type")
+	ErrJSONPatchInvalid = Error("invalid json patch")
+	ErrJSONPatchTest    = Error("json patch test failed")
+	ErrRequestTooLarge  = Error("request body too large")
+)
+
+// Idempotency errors
+const (
+	ErrIdempotencyKeyInvalid    = Error("idempotency key must be 1 to 255 characters")
+	ErrIdempotencyKeyReused     = Error("idempotency key was used for a different request")
+	ErrIdempotencyKeyInProgress = Error("a request with this idempotency key is still in progress")
+)
+
+// Socket errors
+const (
+	ErrUnknownMessageType = Error("unknown message type")
 )
 
 // Task errors
@@ -32,15 +93,154 @@ const (
 	ErrTaskIDRequired        = Error("task id required")
 	ErrTaskNotFound          = Error("task not found")
 	ErrCompletedBoolRequired = Error("completed bool requried")
+	ErrAssigneeNotMember     = Error("assignee is not a member of the project")
+	ErrDueDateInvalid        = Error("due date must be an RFC 3339 timestamp")
+	ErrDueDateRequired       = Error("recurring tasks need a due date")
+	ErrRecurrenceInvalid     = Error("invalid recurrence")
+	ErrTaskFieldUnknown      = Error("unknown task field")
+	ErrTaskFieldReadOnly     = Error("task field can't be changed")
+	ErrBatchEmpty            = Error("batch has no operations")
+	ErrBatchTooLarge         = Error("batch has too many operations")
+	ErrBatchOpInvalid        = Error("invalid batch operation")
+	ErrBatchModeUnknown      = Error("unknown batch mode")
+	ErrBatchRolledBack       = Error("rolled back because another operation failed")
+)
+
+// Reminder errors
+const (
+	ErrReminderIDRequired     = Error("reminder id required")
+	ErrReminderNotFound       = Error("reminder not found")
+	ErrReminderInvalid        = Error("reminder needs either minutesBefore or at")
+	ErrReminderTimeInvalid    = Error("reminder time must be formatted as HH:MM")
+	ErrReminderChannelUnknown = Error("unknown reminder channel")
+	ErrTimezoneInvalid        = Error("unknown timezone")
+)
+
+// Comment errors
+const (
+	ErrCommentIDRequired   = Error("comment id required")
+	ErrCommentBodyRequired = Error("comment body required")
+	ErrCommentTooLong      = Error("comment is too long")
+	ErrCommentNotFound     = Error("comment not found")
+)
+
+// Project errors
+const (
+	ErrProjectIDRequired    = Error("project id required")
+	ErrProjectNameRequired  = Error("project name required")
+	ErrProjectNotFound      = Error("project not found")
+	ErrRoleInvalid          = Error("invalid role")
+	ErrMemberNotFound       = Error("member not found")
+	ErrAlreadyMember        = Error("user is already a member")
+	ErrInvitationIDRequired = Error("invitation id required")
+	ErrInvitationNotFound   = Error("invitation not found")
+	ErrInvitationExists     = Error("user has already been invited")
+	ErrOwnerCannotLeave     = Error("owner must transfer ownership before leaving")
+	ErrCannotChangeOwnRole  = Error("cannot change your own role")
 )
 
 // User errors
 const (
-	ErrEmailRequired      = Error("email required")
-	ErrPasswordRequired   = Error("password required")
-	ErrSessionRequired    = Error("session requried")
-	ErrExpiryTimeRequired = Error("expiry time required")
-	ErrUserIDRequired     = Error("user id requried")
-	ErrUsernameExists     = Error("username is taken")
-	ErrEmailExists        = Error("email already exists")
+	ErrEmailRequired       = Error("email required")
+	ErrPasswordRequired    = Error("password required")
+	ErrSessionRequired     = Error("session requried")
+	ErrSessionIDRequired   = Error("session id required")
+	ErrSessionNotFound     = Error("session not found")
+	ErrCSRFTokenInvalid    = Error("missing or invalid csrf token")
+	ErrExpiryTimeRequired  = Error("expiry time required")
+	ErrUserIDRequired      = Error("user id requried")
+	ErrUsernameExists      = Error("username is taken")
+	ErrEmailExists         = Error("email already exists")
+	ErrUserNotFound        = Error("user not found")
+	ErrResetTokenRequired  = Error("reset token required")
+	ErrResetTokenInvalid   = Error("reset token is invalid or has expired")
+	ErrEmailInvalid        = Error("invalid email address")
+	ErrEmailNotVerified    = Error("email address has not been verified")
+	ErrAlreadyVerified     = Error("email address is already verified")
+	ErrVerificationInvalid = Error("verification link is invalid or has expired")
+	ErrUserUpdateRequired  = Error("new email or password required")
+	ErrPasswordIncorrect   = Error("current password is incorrect")
+)
+
+// API token errors
+const (
+	ErrAPITokenIDRequired   = Error("api token id required")
+	ErrAPITokenNotFound     = Error("api token not found")
+	ErrAPITokenNameRequired = Error("api token name required")
+	ErrAPITokenExpiry       = Error("api token expiry must be a future RFC 3339 time")
+	ErrScopesRequired       = Error("at least one scope required")
+	ErrScopeUnknown         = Error("unknown scope")
+	ErrScopeDenied          = Error("api token does not have the scope for this request")
+)
+
+// JWT errors
+const (
+	ErrJWTDisabled          = Error("token login is not enabled")
+	ErrLoginModeUnknown     = Error("login mode must be empty or jwt")
+	ErrRefreshTokenRequired = Error("refresh token required")
+	ErrRefreshTokenInvalid  = Error("refresh token is invalid or has expired")
+	ErrRefreshTokenReused   = Error("refresh token has already been used, every token from the login has been revoked")
+)
+
+// OAuth errors
+const (
+	ErrOAuthClientIDRequired   = Error("client id required")
+	ErrOAuthClientNotFound     = Error("oauth client not found")
+	ErrOAuthClientNameRequired = Error("client name required")
+	ErrOAuthClientAuthFailed   = Error("client authentication failed")
+	ErrRedirectURIInvalid      = Error("redirect uri is not registered for the client")
+	ErrRedirectURIsRequired    = Error("at least one absolute redirect uri required")
+	ErrPKCERequired            = Error("code_challenge with code_challenge_method S256 required")
+	ErrOAuthGrantInvalid       = Error("authorization grant is invalid, has expired or was already used")
+)
+
+// Login errors
+const (
+	ErrLoginFailed     = Error("incorrect email or password")
+	ErrLoginLocked     = Error("too many failed login attempts, try again later")
+	ErrLockoutKind     = Error("lockout kind must be account or ip")
+	ErrLockoutNotFound = Error("no lockout found")
+	ErrAdminRequired   = Error("admin access required")
+)
+
+// Audit errors
+const (
+	ErrAuditCursorInvalid = Error("before must be an audit entry id")
+)
+
+// MFA errors
+const (
+	ErrMFACodeRequired      = Error("mfa code required")
+	ErrMFACodeInvalid       = Error("invalid mfa code")
+	ErrMFANotEnrolled       = Error("mfa enrolment has not been started")
+	ErrMFAAlreadyEnabled    = Error("mfa is already enabled")
+	ErrMFANotEnabled        = Error("mfa is not enabled")
+	ErrMFAChallengeRequired = Error("mfa challenge required")
+	ErrMFAChallengeInvalid  = Error("mfa challenge is invalid or has expired")
+)
+
+// Notification errors
+const (
+	ErrNotificationIDRequired  = Error("notification id required")
+	ErrNotificationNotFound    = Error("notification not found")
+	ErrNotificationTypeUnknown = Error("unknown notification type")
+)
+
+// Mail errors
+const (
+	ErrMailRecipientRequired = Error("mail recipient required")
+	ErrMailTemplateNotFound  = Error("mail template not found")
+	ErrMailQueueFull         = Error("mail queue is full")
+)
+
+// Webhook errors
+const (
+	ErrWebhookURLInvalid     = Error("webhook url must be an absolute http or https url")
+	ErrWebhookEventsRequired = Error("webhook events required")
+	ErrWebhookEventUnknown   = Error("unknown webhook event")
+	ErrWebhookSecretRequired = Error("webhook secret required")
+	ErrWebhookIDRequired     = Error("webhook id required")
+	ErrWebhookNotFound       = Error("webhook not found")
+	ErrWebhookAddressBlocked = Error("webhook url must not be an internal address")
+	ErrWebhookRedirect       = Error("webhook redirects are not followed")
 )
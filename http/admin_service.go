@@ -0,0 +1,114 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// auditPageSize is how many audit entries are returned at once.
+const auditPageSize = 100
+
+// AdminHandler serves the admin API. Every route needs the user to be an
+// admin.
+type AdminHandler struct {
+	*router
+	UserService         todo.UserService
+	LoginAttemptService todo.LoginAttemptService
+	AuditService        todo.AuditService
+	Logger              *log.Logger
+}
+
+func NewAdminHandler() *AdminHandler {
+	h := &AdminHandler{
+		router: newRouter(),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/admin/lockouts", h.requireAdmin(h.handleLockouts))
+	h.POST("/api/admin/unlock", h.requireAdmin(h.handleUnlock))
+	h.GET("/api/admin/audit", h.requireAdmin(h.handleAuditLog))
+	return h
+}
+
+// requireAdmin only lets admins through to handle.
+func (h *AdminHandler) requireAdmin(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		admin, err := h.UserService.IsAdmin(todo.UserID(r.Header.Get("userID")))
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		} else if !admin {
+			Problem(w, todo.ErrAdminRequired, h.Logger)
+			return
+		}
+		handle(w, r, p)
+	}
+}
+
+type getLockoutsResponse struct {
+	Lockouts *todo.Lockouts `json:"lockouts"`
+}
+
+// handleLockouts returns the accounts and IP addresses currently locked out.
+func (h *AdminHandler) handleLockouts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	lockouts, err := h.LoginAttemptService.Lockouts()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getLockoutsResponse{Lockouts: lockouts}, h.Logger)
+}
+
+type unlockRequest struct {
+	Kind    todo.LockoutKind `json:"kind"`
+	Subject string           `json:"subject"`
+}
+
+// handleUnlock clears the failed logins of an account, by email, or of an
+// IP address.
+func (h *AdminHandler) handleUnlock(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req unlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if req.Kind == todo.LockoutAccount {
+		if email, err := todo.NormalizeEmail(todo.Email(req.Subject)); err == nil {
+			req.Subject = string(email)
+		}
+	}
+	switch err := h.LoginAttemptService.Unlock(req.Kind, req.Subject, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Unlocked " + string(req.Kind) + " " + req.Subject}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type getAuditLogResponse struct {
+	Entries *todo.AuditLog `json:"entries"`
+}
+
+// handleAuditLog returns the newest audit entries. The before query
+// parameter takes the ID of the last entry seen to page back through them.
+func (h *AdminHandler) handleAuditLog(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var before int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		var err error
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			Problem(w, todo.ErrAuditCursorInvalid, h.Logger)
+			return
+		}
+	}
+	entries, err := h.AuditService.AuditLog(before, auditPageSize)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getAuditLogResponse{Entries: entries}, h.Logger)
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// apiTokenPrefix marks personal access tokens so they are easy to spot, for
+// example by secret scanners.
+const apiTokenPrefix = "tdo_"
+
+// checkScope returns ErrScopeDenied unless scopes allow a request to the
+// route. Only routes that declare a resource can be used with tokens,
+// everything else, such as managing the account and its tokens, needs a
+// session.
+func checkScope(rt *route, scopes []string) error {
+	required := rt.scope()
+	for _, scope := range scopes {
+		if required != "" && scope == required {
+			return nil
+		}
+	}
+	return todo.ErrScopeDenied
+}
+
+// bearerToken returns the token from an Authorization: Bearer header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+type getAPITokensResponse struct {
+	Tokens *todo.APITokens `json:"tokens"`
+}
+
+func (h *UserHandler) handleAPITokens(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	tokens, err := h.APITokenService.APITokens(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getAPITokensResponse{Tokens: tokens}, h.Logger)
+}
+
+type createAPITokenResponse struct {
+	ID todo.APITokenID `json:"id"`
+	// Token is only ever returned here.
+	Token string `json:"token"`
+}
+
+func (h *UserHandler) handleCreateAPIToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req todo.APIToken
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	token, _, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	token = apiTokenPrefix + token
+	switch id, err := h.APITokenService.CreateAPIToken(&req, hashToken(token), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &createAPITokenResponse{ID: id, Token: token}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *UserHandler) handleDeleteAPIToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch err := h.APITokenService.DeleteAPIToken(todo.APITokenID(ps.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"API token has been deleted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// Batch modes. An atomic batch makes every change or none of them, a best
+// effort batch makes the changes that work.
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "best-effort"
+)
+
+type batchRequest struct {
+	// Mode defaults to atomic.
+	Mode string           `json:"mode"`
+	Ops  []batchOpRequest `json:"ops"`
+}
+
+type batchOpRequest struct {
+	Op        todo.BatchOpType `json:"op"`
+	ID        todo.TaskID      `json:"id"`
+	Content   todo.TaskContent `json:"content,omitempty"`
+	ProjectID todo.ProjectID   `json:"projectId,omitempty"`
+	// Patch is a merge patch, as PATCH /api/tasks/:id takes.
+	Patch map[string]json.RawMessage `json:"patch,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchResult has the status code the operation would have had as its own
+// request, and the problem document it would have returned if it failed.
+type batchResult struct {
+	Status  int              `json:"status"`
+	Task    *todo.Task       `json:"task,omitempty"`
+	Err     string           `json:"err,omitempty"`
+	Problem *problemDocument `json:"problem,omitempty"`
+}
+
+// handleBatch runs an ordered list of task operations in one transaction
+// and returns what happened to each of them.
+func (h *TaskHandler) handleBatch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	atomic := true
+	switch req.Mode {
+	case "", batchAtomic:
+	case batchBestEffort:
+		atomic = false
+	default:
+		Problem(w, todo.ErrBatchModeUnknown, h.Logger)
+		return
+	}
+	if len(req.Ops) == 0 {
+		Problem(w, todo.ErrBatchEmpty, h.Logger)
+		return
+	} else if len(req.Ops) > h.MaxBatchSize {
+		Problem(w, todo.ErrBatchTooLarge, h.Logger)
+		return
+	}
+	// Operations that can't be read fail without being run, which fails
+	// the whole of an atomic batch
+	ops := make([]todo.BatchOp, len(req.Ops))
+	results := make([]todo.BatchResult, len(req.Ops))
+	var run []todo.BatchOp
+	var runIndex []int
+	for i, op := range req.Ops {
+		ops[i] = todo.BatchOp{Type: op.Op, ID: op.ID, Content: op.Content, ProjectID: op.ProjectID}
+		switch op.Op {
+		case todo.BatchCreate:
+			if ops[i].ID == "" {
+				id, err := newTaskID()
+				if err != nil {
+					Problem(w, err, h.Logger)
+					return
+				}
+				ops[i].ID = id
+			}
+		case todo.BatchPatch:
+			if op.Patch == nil {
+				results[i].Err = todo.ErrBatchOpInvalid
+			} else {
+				ops[i].Patch, results[i].Err = taskMergePatch(op.Patch)
+			}
+		}
+		if results[i].Err == nil {
+			run = append(run, ops[i])
+			runIndex = append(runIndex, i)
+		}
+	}
+	if atomic && len(run) < len(ops) {
+		for _, i := range runIndex {
+			results[i].Err = todo.ErrBatchRolledBack
+		}
+	} else if len(run) > 0 {
+		ran, err := h.TaskService.RunBatch(run, atomic, todo.UserID(r.Header.Get("userID")))
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		}
+		for j, i := range runIndex {
+			results[i] = ran[j]
+		}
+	}
+	res := batchResponse{Committed: true, Results: make([]batchResult, len(results))}
+	for i, result := range results {
+		res.Results[i] = h.batchResult(&ops[i], &result, w.Header().Get(requestIDHeader))
+		if result.Err != nil && atomic {
+			res.Committed = false
+		}
+	}
+	encodeJSON(w, &res, h.Logger)
+}
+
+func (h *TaskHandler) batchResult(op *todo.BatchOp, result *todo.BatchResult, requestID string) batchResult {
+	switch err := result.Err; {
+	case err == nil && op.Type == todo.BatchCreate:
+		return batchResult{Status: http.StatusCreated, Task: result.Task}
+	case err == nil && op.Type == todo.BatchDelete:
+		return batchResult{Status: http.StatusNoContent}
+	case err == nil:
+		return batchResult{Status: http.StatusOK, Task: result.Task}
+	}
+	status := problemStatus(result.Err)
+	if status == http.StatusInternalServerError {
+		h.Logger.Printf("batch error: %s (request=%s)", result.Err, requestID)
+	}
+	problem := newProblem(result.Err, requestID)
+	return batchResult{Status: status, Err: problem.Detail, Problem: problem}
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// batchTasks runs batches against tasks in memory. An atomic batch that
+// fails changes nothing and marks its other operations rolled back, the way
+// the postgres service does.
+type batchTasks struct {
+	todo.TaskService
+	mu      sync.Mutex
+	tasks   map[todo.TaskID]todo.Task
+	batches int
+}
+
+func (s *batchTasks) RunBatch(ops []todo.BatchOp, atomic bool, userID todo.UserID) ([]todo.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	tasks := map[todo.TaskID]todo.Task{}
+	for id, task := range s.tasks {
+		tasks[id] = task
+	}
+	results := make([]todo.BatchResult, len(ops))
+	for i, op := range ops {
+		task, ok := tasks[op.ID]
+		switch {
+		case op.Type == todo.BatchCreate:
+			task = todo.Task{ID: op.ID, Content: op.Content}
+		case !ok:
+			results[i].Err = todo.ErrTaskNotFound
+		case op.Type == todo.BatchPatch && op.Patch.Content != nil:
+			task.Content = *op.Patch.Content
+		}
+		if results[i].Err != nil && atomic {
+			for j := range results {
+				if j != i {
+					results[j] = todo.BatchResult{Err: todo.ErrBatchRolledBack}
+				}
+			}
+			return results, nil
+		} else if results[i].Err != nil {
+			continue
+		}
+		if op.Type == todo.BatchDelete {
+			delete(tasks, op.ID)
+			continue
+		}
+		tasks[op.ID] = task
+		results[i].Task = &task
+	}
+	s.tasks = tasks
+	return results, nil
+}
+
+func newBatchTest(t *testing.T) (*Handler, *batchTasks, *testClient) {
+	h := newTestHandler()
+	tasks := &batchTasks{tasks: map[todo.TaskID]todo.Task{"a": {ID: "a", Content: "a"}}}
+	h.TaskHandler.TaskService = tasks
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, tasks, login(t, h, srv, "user")
+}
+
+// expectResults checks the status and problem code of each result.
+func expectResults(t *testing.T, res *batchResponse, statuses []int, codes []string) {
+	t.Helper()
+	if len(res.Results) != len(statuses) {
+		t.Fatalf("%d results, want %d", len(res.Results), len(statuses))
+	}
+	for i, result := range res.Results {
+		code := ""
+		if result.Problem != nil {
+			code = result.Problem.Code
+		}
+		if result.Status != statuses[i] || code != codes[i] {
+			t.Errorf("result %d = %d %q, want %d %q", i, result.Status, code, statuses[i], codes[i])
+		}
+	}
+}
+
+var mixedBatch = []batchOpRequest{
+	{Op: todo.BatchCreate, ID: "b", Content: "b"},
+	{Op: todo.BatchPatch, ID: "a", Patch: map[string]json.RawMessage{"content": json.RawMessage(`"patched"`)}},
+	{Op: todo.BatchDelete, ID: "missing"},
+}
+
+func TestBatchAtomic(t *testing.T) {
+	_, tasks, user := newBatchTest(t)
+	var res batchResponse
+	decode(t, user.do(http.MethodPost, "/api/batch", &batchRequest{Ops: mixedBatch}), http.StatusOK, &res)
+	if res.Committed {
+		t.Error("failed atomic batch committed")
+	}
+	expectResults(t, &res,
+		[]int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusNotFound},
+		[]string{"batch_rolled_back", "batch_rolled_back", "task_not_found"})
+	if _, ok := tasks.tasks["b"]; ok || tasks.tasks["a"].Content != "a" {
+		t.Errorf("failed atomic batch changed tasks to %+v", tasks.tasks)
+	}
+
+	// An operation that can't be read fails the batch without running it
+	ops := []batchOpRequest{mixedBatch[0], {Op: todo.BatchPatch, ID: "a"}}
+	res = batchResponse{}
+	decode(t, user.do(http.MethodPost, "/api/batch", &batchRequest{Mode: batchAtomic, Ops: ops}), http.StatusOK, &res)
+	if res.Committed {
+		t.Error("atomic batch with an invalid operation committed")
+	}
+	expectResults(t, &res,
+		[]int{http.StatusFailedDependency, http.StatusBadRequest},
+		[]string{"batch_rolled_back", "batch_op_invalid"})
+	if tasks.batches != 1 {
+		t.Errorf("%d batches run, want 1", tasks.batches)
+	}
+
+	res = batchResponse{}
+	decode(t, user.do(http.MethodPost, "/api/batch", &batchRequest{Ops: mixedBatch[:2]}), http.StatusOK, &res)
+	if !res.Committed {
+		t.Error("atomic batch not committed")
+	}
+	expectResults(t, &res, []int{http.StatusCreated, http.StatusOK}, []string{"", ""})
+	if res.Results[1].Task == nil || res.Results[1].Task.Content != "patched" {
+		t.Errorf("patched task = %+v", res.Results[1].Task)
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	_, tasks, user := newBatchTest(t)
+	ops := append([]batchOpRequest{{Op: todo.BatchPatch, ID: "a", Patch: map[string]json.RawMessage{"id": json.RawMessage(`"c"`)}}}, mixedBatch...)
+	var res batchResponse
+	decode(t, user.do(http.MethodPost, "/api/batch", &batchRequest{Mode: batchBestEffort, Ops: ops}), http.StatusOK, &res)
+	if !res.Committed {
+		t.Error("best effort batch not committed")
+	}
+	expectResults(t, &res,
+		[]int{http.StatusBadRequest, http.StatusCreated, http.StatusOK, http.StatusNotFound},
+		[]string{"validation_failed", "", "", "task_not_found"})
+	if _, ok := tasks.tasks["b"]; !ok || tasks.tasks["a"].Content != "patched" {
+		t.Errorf("tasks = %+v", tasks.tasks)
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	h, tasks, user := newBatchTest(t)
+	h.TaskHandler.MaxBatchSize = 2
+	for _, test := range []struct {
+		name   string
+		req    interface{}
+		status int
+		code   string
+	}{
+		{"empty", &batchRequest{}, http.StatusBadRequest, "batch_empty"},
+		{"too large", &batchRequest{Ops: mixedBatch}, http.StatusRequestEntityTooLarge, "batch_too_large"},
+		{"unknown mode", &batchRequest{Mode: "eventually", Ops: mixedBatch[:1]}, http.StatusBadRequest, "batch_mode_unknown"},
+		{"invalid JSON", []string{"ops"}, http.StatusBadRequest, "invalid_json"},
+	} {
+		var problem problemDocument
+		decode(t, user.do(http.MethodPost, "/api/batch", test.req), test.status, &problem)
+		if problem.Code != test.code {
+			t.Errorf("%s: code %q, want %q", test.name, problem.Code, test.code)
+		}
+	}
+	if tasks.batches != 0 {
+		t.Errorf("%d batches run, want none", tasks.batches)
+	}
+	// The limit allows a batch of exactly that size
+	decode(t, user.do(http.MethodPost, "/api/batch", &batchRequest{Ops: mixedBatch[:2]}), http.StatusOK, nil)
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type CommentHandler struct {
+	*router
+	CommentService todo.CommentService
+	Logger         *log.Logger
+}
+
+func NewCommentHandler() *CommentHandler {
+	h := &CommentHandler{
+		router: newScopedRouter("tasks"),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/comments", h.handleComments)
+	h.POST("/api/comments/create", h.handleCreateComment)
+	h.POST("/api/comments/edit", h.handleEditComment)
+	h.DELETE("/api/comments/delete/:id", h.handleDeleteComment)
+	return h
+}
+
+type getCommentsResponse struct {
+	Comments *todo.Comments `json:"comments"`
+}
+
+// handleComments returns the comments on the task given in the task query
+// parameter, oldest first.
+func (h *CommentHandler) handleComments(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	comments, err := h.CommentService.Comments(todo.TaskID(r.URL.Query().Get("task")), todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getCommentsResponse{Comments: comments}, h.Logger)
+}
+
+type createCommentRequest struct {
+	TaskID todo.TaskID `json:"taskId"`
+	Body   string      `json:"body"`
+}
+
+type createCommentResponse struct {
+	ID todo.CommentID `json:"id"`
+}
+
+func (h *CommentHandler) handleCreateComment(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	id, err := h.CommentService.CreateComment(req.TaskID, req.Body, todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &createCommentResponse{ID: id}, h.Logger)
+}
+
+type editCommentRequest struct {
+	ID   todo.CommentID `json:"id"`
+	Body string         `json:"body"`
+}
+
+func (h *CommentHandler) handleEditComment(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req editCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.CommentService.EditComment(req.ID, req.Body, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Comment has been updated"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *CommentHandler) handleDeleteComment(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.CommentService.DeleteComment(todo.CommentID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Comment has been deleted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
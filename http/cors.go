@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultOrigin is where the web app is served from.
+const defaultOrigin = "https://www.mattkennedy.io"
+
+// CORSConfig says which origins browsers may call the api from.
+type CORSConfig struct {
+	// AllowedOrigins are matched exactly, except that "*." in front of the
+	// host allows any subdomain, e.g. "https://*.mattkennedy.io".
+	AllowedOrigins []string
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request.
+	MaxAge time.Duration
+}
+
+// DefaultCORS only allows the web app.
+var DefaultCORS = &CORSConfig{
+	AllowedOrigins: []string{defaultOrigin},
+	MaxAge:         10 * time.Minute,
+}
+
+const (
+	corsMethods = "POST, GET, OPTIONS, PUT, PATCH, DELETE"
+	corsHeaders = "Content-Type, Authorization, " + csrfHeader + ", " + idempotencyKeyHeader + ", " + requestIDHeader
+	// corsExposedHeaders can be read by scripts on other origins.
+	corsExposedHeaders = "Location, Idempotent-Replayed, " + requestIDHeader
+)
+
+// Allowed reports whether browsers may call the api from origin.
+func (c *CORSConfig) Allowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+		i := strings.Index(allowed, "*.")
+		if i == -1 {
+			continue
+		}
+		// The scheme must match and the subdomain can't smuggle in a path,
+		// port or user
+		scheme, domain := allowed[:i], allowed[i+1:]
+		if !strings.HasPrefix(origin, scheme) || !strings.HasSuffix(origin, domain) {
+			continue
+		}
+		sub := origin[len(scheme) : len(origin)-len(domain)]
+		if sub != "" && !strings.ContainsAny(sub, "/:@") {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware adds the CORS headers for allowed origins and answers
+// preflight requests.
+func (c *CORSConfig) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.Allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// csrfHeader carries the CSRF token on requests that change something.
+const csrfHeader = "X-CSRF-Token"
+
+// csrfToken is the synchronizer token of a session: an HMAC of the session
+// cookie's hash, so nothing extra needs storing and it changes with every
+// login. Other sites can send the cookie but can't read the token, CORS only
+// lets the app's origin fetch it.
+func csrfToken(key []byte, sessionTokenHash string) string {
+	return base64.RawURLEncoding.EncodeToString(sign(key, "csrf:"+sessionTokenHash))
+}
+
+// checkCSRF returns ErrCSRFTokenInvalid if a request authenticated by the
+// session cookie changes something without the session's CSRF token.
+// Requests with an Authorization header don't need one, browsers never add
+// it by themselves.
+func (h *Handler) checkCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if _, ok := bearerToken(r); ok {
+		return nil
+	}
+	hash := sessionTokenHash(r)
+	if hash == "" {
+		return nil
+	}
+	want := csrfToken(h.UserHandler.CSRFKey, hash)
+	if !hmac.Equal([]byte(r.Header.Get(csrfHeader)), []byte(want)) {
+		return todo.ErrCSRFTokenInvalid
+	}
+	return nil
+}
+
+type csrfTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// handleCSRFToken returns the token the app must send in the X-CSRF-Token
+// header with the current session.
+func (h *UserHandler) handleCSRFToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	hash := sessionTokenHash(r)
+	if hash == "" {
+		Problem(w, todo.ErrSessionRequired, h.Logger)
+		return
+	}
+	encodeJSON(w, &csrfTokenResponse{Token: csrfToken(h.CSRFKey, hash)}, h.Logger)
+}
@@ -5,92 +5,210 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/kennedymj97/todo-api"
 )
 
 type Handler struct {
-	TaskHandler *TaskHandler
-	UserHandler *UserHandler
+	TaskHandler         *TaskHandler
+	UserHandler         *UserHandler
+	ProjectHandler      *ProjectHandler
+	SocketHandler       *SocketHandler
+	WebhookHandler      *WebhookHandler
+	CommentHandler      *CommentHandler
+	NotificationHandler *NotificationHandler
+	ReminderHandler     *ReminderHandler
+	AdminHandler        *AdminHandler
+	OAuthHandler        *OAuthHandler
+	// CORS defaults to DefaultCORS.
+	CORS *CORSConfig
+	// IdempotencyService turns on Idempotency-Key support. Responses are
+	// kept for IdempotencyTTL, by default DefaultIdempotencyTTL.
+	IdempotencyService todo.IdempotencyService
+	IdempotencyTTL     time.Duration
+	// RequireVerified lists path prefixes only accounts with a verified
+	// email can use.
+	RequireVerified []string
+
+	once   sync.Once
+	router *router
+	chain  http.Handler
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Implement middleware here
-	h.TaskHandler.Logger.Printf("%s %s %s", r.Proto, r.Method, r.URL.Path)
-	w.Header().Set("Access-Control-Allow-Origin", "https://www.mattkennedy.io")
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-	switch r.URL.Path {
-	case "/api/users/login":
-		break
-	case "/api/users/create":
-		break
-	default:
-		userID, err := h.auth(r)
-		if err != nil {
-			Error(w, todo.ErrUnauthorized, http.StatusUnauthorized, h.UserHandler.Logger)
-			return
+	h.once.Do(func() {
+		cors := h.CORS
+		if cors == nil {
+			cors = DefaultCORS
 		}
-		r.Header.Add("userID", string(userID))
+		h.router = h.newRouter()
+		h.chain = Chain(h.router,
+			RequestID,
+			LogRequests(h.TaskHandler.Logger),
+			cors.Middleware,
+			JSONContent,
+		)
+	})
+	h.chain.ServeHTTP(w, r)
+}
+
+// newRouter puts the routes of every part of the api on one router, which
+// answers requests with the wrong method with 405 and an Allow header. Each
+// route is wrapped in the middleware that needs to know about the route.
+func (h *Handler) newRouter() *router {
+	r := newRouter()
+	for _, section := range []*router{
+		h.TaskHandler.router,
+		h.UserHandler.router,
+		h.ProjectHandler.router,
+		h.SocketHandler.router,
+		h.WebhookHandler.router,
+		h.CommentHandler.router,
+		h.NotificationHandler.router,
+		h.ReminderHandler.router,
+		h.AdminHandler.router,
+		h.OAuthHandler.router,
+	} {
+		for _, rt := range section.routes {
+			rt.handle = h.routeHandle(rt)
+			r.add(rt)
+		}
+	}
+	r.HandleMethodNotAllowed = true
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		NotFound(w)
+	})
+	r.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		Problem(w, todo.ErrMethodNotAllowed, h.TaskHandler.Logger)
+	})
+	return r
+}
+
+// routeHandle runs the route's handle behind authentication and
+// idempotency.
+func (h *Handler) routeHandle(rt route) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		handle := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt.handle(w, r, ps)
+		})
+		Chain(handle, h.authenticate(&rt), h.idempotency(&rt)).ServeHTTP(w, r)
 	}
-	if strings.HasPrefix(r.URL.Path, "/api/tasks") {
-		h.TaskHandler.ServeHTTP(w, r)
-	} else if strings.HasPrefix(r.URL.Path, "/api/users") {
-		h.UserHandler.ServeHTTP(w, r)
-	} else {
-		http.NotFound(w, r)
+}
+
+// authenticate lets requests for public routes through, and otherwise
+// passes on the user the request is from in the userID header.
+func (h *Handler) authenticate(rt *route) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rt.public {
+				// A userID header sent by the client mustn't reach the handler
+				r.Header.Del("userID")
+				next.ServeHTTP(w, r)
+				return
+			}
+			userID, scopes, err := h.auth(r)
+			if err != nil {
+				Problem(w, todo.ErrUnauthorized, h.UserHandler.Logger)
+				return
+			}
+			if scopes != nil {
+				if err := checkScope(rt, scopes); err != nil {
+					Problem(w, err, h.UserHandler.Logger)
+					return
+				}
+			}
+			if err := h.checkCSRF(r); err != nil {
+				Problem(w, err, h.UserHandler.Logger)
+				return
+			}
+			// Set rather than add, a userID header sent by the client mustn't win
+			r.Header.Set("userID", string(userID))
+			if err := h.checkVerified(r.URL.Path, userID); err != nil {
+				Problem(w, err, h.UserHandler.Logger)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
-func (h *Handler) auth(r *http.Request) (todo.UserID, error) {
+// auth returns the user a request is from, by its Authorization: Bearer
+// OAuth access token, access JWT or API token, or else its session cookie.
+// Scopes are only returned for API and OAuth tokens, sessions and JWTs can
+// do everything.
+func (h *Handler) auth(r *http.Request) (todo.UserID, []string, error) {
+	if token, ok := bearerToken(r); ok && strings.HasPrefix(token, oauthAccessPrefix) {
+		if h.OAuthHandler == nil {
+			return "", nil, todo.ErrUnauthorized
+		}
+		userID, scopes, err := h.OAuthHandler.OAuthService.AuthenticateOAuthToken(hashToken(token))
+		if err != nil {
+			return "", nil, err
+		}
+		if scopes == nil {
+			scopes = []string{}
+		}
+		return userID, scopes, nil
+	} else if ok && !strings.HasPrefix(token, apiTokenPrefix) {
+		// Access JWTs are checked without the database
+		if h.UserHandler.JWTKeys == nil {
+			return "", nil, todo.ErrUnauthorized
+		}
+		claims, err := h.UserHandler.JWTKeys.Verify(token, time.Now())
+		if err != nil {
+			return "", nil, err
+		}
+		return todo.UserID(claims.Subject), nil, nil
+	} else if ok {
+		userID, scopes, err := h.UserHandler.APITokenService.AuthenticateAPIToken(hashToken(token))
+		if err != nil {
+			return "", nil, err
+		}
+		if scopes == nil {
+			scopes = []string{}
+		}
+		return userID, scopes, nil
+	}
 	sessionCookie, err := r.Cookie("session")
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	sessionID := sessionCookie.Value
 	// NEED TO CHECK THAT THE SESSION COOKIE HAS NOT EXPIRED
 	// WAY TO REFRESH THE SESSION COOKIE IMPLEMENTED HERE
-	userID, err := h.UserHandler.UserService.AuthenticateUser(todo.SessionID(sessionID))
+	userID, err := h.UserHandler.UserService.AuthenticateUser(hashToken(sessionCookie.Value))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return userID, nil
+	return userID, nil, nil
 }
 
-type infoResponse struct {
-	Info string `json:"info,omitempty"`
-}
-
-type errorResponse struct {
-	Err string `json:"err,omitempty"`
-}
-
-func Error(w http.ResponseWriter, err error, code int, logger *log.Logger) {
-	// Log error
-	logger.Printf("http error: %s (code=%d)", err, code)
-
-	// Hide error from client if it is internal
-	if code == http.StatusInternalServerError {
-		err = todo.ErrInternal
+// checkVerified returns ErrEmailNotVerified if the path needs a verified
+// email and the user hasn't verified theirs.
+func (h *Handler) checkVerified(path string, userID todo.UserID) error {
+	for _, prefix := range h.RequireVerified {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		user, err := h.UserHandler.UserService.Account(userID)
+		if err != nil {
+			return err
+		} else if user.VerifiedAt == "" {
+			return todo.ErrEmailNotVerified
+		}
+		return nil
 	}
-
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(&errorResponse{Err: err.Error()})
+	return nil
 }
 
-func NotFound(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusNotFound)
-	w.Write([]byte(`{}` + "\n"))
+type infoResponse struct {
+	Info string `json:"info,omitempty"`
 }
 
 func encodeJSON(w http.ResponseWriter, v interface{}, logger *log.Logger) {
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		Error(w, err, http.StatusInternalServerError, logger)
+		Problem(w, err, logger)
 	}
 }
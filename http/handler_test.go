@@ -0,0 +1,150 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+var discard = log.New(ioutil.Discard, "", 0)
+
+// memoryUsers is the part of a UserService the tests use, kept in memory.
+// Calls to anything else panic.
+type memoryUsers struct {
+	todo.UserService
+	mu       sync.Mutex
+	sessions map[string]todo.UserID
+	// users are the accounts by email.
+	users  map[todo.Email]*memoryUser
+	resets map[string]todo.Email
+	admins map[todo.UserID]bool
+	nextID int
+}
+
+type memoryUser struct {
+	id       todo.UserID
+	password string
+	verified bool
+}
+
+func (s *memoryUsers) AuthenticateUser(tokenHash string) (todo.UserID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userID, ok := s.sessions[tokenHash]; ok {
+		return userID, nil
+	}
+	return "", todo.ErrUnauthorized
+}
+
+// newTestHandler returns a Handler for every part of the api without any
+// services, tests set the ones they use.
+func newTestHandler() *Handler {
+	h := &Handler{
+		TaskHandler:         NewTaskHandler(),
+		UserHandler:         NewUserHandler(),
+		ProjectHandler:      NewProjectHandler(),
+		SocketHandler:       NewSocketHandler(),
+		WebhookHandler:      NewWebhookHandler(),
+		CommentHandler:      NewCommentHandler(),
+		NotificationHandler: NewNotificationHandler(),
+		ReminderHandler:     NewReminderHandler(),
+		AdminHandler:        NewAdminHandler(),
+		OAuthHandler:        NewOAuthHandler(),
+	}
+	h.TaskHandler.Logger = discard
+	h.UserHandler.Logger = discard
+	h.ProjectHandler.Logger = discard
+	h.SocketHandler.Logger = discard
+	h.WebhookHandler.Logger = discard
+	h.CommentHandler.Logger = discard
+	h.NotificationHandler.Logger = discard
+	h.ReminderHandler.Logger = discard
+	h.AdminHandler.Logger = discard
+	h.OAuthHandler.Logger = discard
+	h.UserHandler.CSRFKey = []byte("csrf key")
+	h.UserHandler.VerifyKey = []byte("verify key")
+	h.UserHandler.UserService = &memoryUsers{
+		sessions: map[string]todo.UserID{},
+		users:    map[todo.Email]*memoryUser{},
+		resets:   map[string]todo.Email{},
+	}
+	return h
+}
+
+// testClient makes requests to a test server as a logged in user, with the
+// session's CSRF token, or without logging in if it has no cookie.
+type testClient struct {
+	t      *testing.T
+	srv    *httptest.Server
+	cookie *http.Cookie
+	csrf   string
+}
+
+// login starts a session for the user, h's UserService must be the one
+// newTestHandler set.
+func login(t *testing.T, h *Handler, srv *httptest.Server, userID todo.UserID) *testClient {
+	t.Helper()
+	token, hash, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := h.UserHandler.UserService.(*memoryUsers)
+	users.mu.Lock()
+	users.sessions[hash] = userID
+	users.mu.Unlock()
+	return &testClient{
+		t:      t,
+		srv:    srv,
+		cookie: &http.Cookie{Name: "session", Value: token},
+		csrf:   csrfToken(h.UserHandler.CSRFKey, hash),
+	}
+}
+
+// do sends a JSON request, v is sent as the body unless it's nil.
+func (c *testClient) do(method, path string, v interface{}) *http.Response {
+	c.t.Helper()
+	var body io.Reader
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		body = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.cookie != nil {
+		req.AddCookie(c.cookie)
+		req.Header.Set(csrfHeader, c.csrf)
+	}
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// decode reads a JSON response into v and checks its status.
+func decode(t *testing.T, resp *http.Response, status int, v interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("%s: %s", body, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+const eventPresenceChange = "presence"
+
+// Hub keeps track of the live socket connections. Events are sent to every
+// connection of the user they belong to, and presence is shared between
+// the connections viewing the same project.
+type Hub struct {
+	mu     sync.Mutex
+	users  map[todo.UserID]map[*client]bool
+	rooms  map[todo.ProjectID]map[*client]bool
+	Logger *log.Logger
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		users:  make(map[todo.UserID]map[*client]bool),
+		rooms:  make(map[todo.ProjectID]map[*client]bool),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+type eventMessage struct {
+	Type  string         `json:"type"`
+	Event todo.EventType `json:"event"`
+	Data  interface{}    `json:"data,omitempty"`
+}
+
+type presenceMessage struct {
+	Type      string         `json:"type"`
+	ProjectID todo.ProjectID `json:"projectId"`
+	Users     []todo.UserID  `json:"users"`
+}
+
+// Publish sends an event to every connection of the user.
+func (h *Hub) Publish(userID todo.UserID, event todo.EventType, data interface{}) {
+	if h == nil {
+		return
+	}
+	msg, err := json.Marshal(&eventMessage{Type: "event", Event: event, Data: data})
+	if err != nil {
+		h.Logger.Printf("hub error: %s", err)
+		return
+	}
+	h.mu.Lock()
+	clients := make([]*client, 0, len(h.users[userID]))
+	for c := range h.users[userID] {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+	h.send(clients, msg)
+}
+
+// HandleEvent publishes an event to the user it belongs to.
+func (h *Hub) HandleEvent(e todo.Event) error {
+	h.Publish(e.UserID, e.Type, e.Payload)
+	return nil
+}
+
+func (h *Hub) join(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients, ok := h.users[c.userID]
+	if !ok {
+		clients = make(map[*client]bool)
+		h.users[c.userID] = clients
+	}
+	clients[c] = true
+}
+
+// view moves the client's presence to a project. An empty id means the
+// client is no longer viewing any project.
+func (h *Hub) view(c *client, id todo.ProjectID) {
+	h.mu.Lock()
+	old := c.room
+	if old == id || !h.users[c.userID][c] {
+		h.mu.Unlock()
+		return
+	}
+	h.removeFromRoom(c)
+	if id != "" {
+		clients, ok := h.rooms[id]
+		if !ok {
+			clients = make(map[*client]bool)
+			h.rooms[id] = clients
+		}
+		clients[c] = true
+	}
+	c.room = id
+	h.mu.Unlock()
+	h.presence(old)
+	h.presence(id)
+}
+
+func (h *Hub) leave(c *client) {
+	h.mu.Lock()
+	clients, ok := h.users[c.userID]
+	if !ok || !clients[c] {
+		h.mu.Unlock()
+		return
+	}
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(h.users, c.userID)
+	}
+	room := c.room
+	h.removeFromRoom(c)
+	close(c.send)
+	h.mu.Unlock()
+	h.presence(room)
+}
+
+// removeFromRoom must be called with h.mu held.
+func (h *Hub) removeFromRoom(c *client) {
+	if c.room == "" {
+		return
+	}
+	clients := h.rooms[c.room]
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(h.rooms, c.room)
+	}
+}
+
+// presence tells everyone viewing the project who else is viewing it.
+func (h *Hub) presence(id todo.ProjectID) {
+	if id == "" {
+		return
+	}
+	h.mu.Lock()
+	seen := make(map[todo.UserID]bool)
+	users := []todo.UserID{}
+	clients := make([]*client, 0, len(h.rooms[id]))
+	for c := range h.rooms[id] {
+		clients = append(clients, c)
+		if !seen[c.userID] {
+			seen[c.userID] = true
+			users = append(users, c.userID)
+		}
+	}
+	h.mu.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	msg, err := json.Marshal(&presenceMessage{Type: eventPresenceChange, ProjectID: id, Users: users})
+	if err != nil {
+		h.Logger.Printf("hub error: %s", err)
+		return
+	}
+	h.send(clients, msg)
+}
+
+// send never blocks on a client. A client whose send buffer is full is too
+// slow to keep up and gets disconnected instead of holding everyone up.
+func (h *Hub) send(clients []*client, msg []byte) {
+	var slow []*client
+	h.mu.Lock()
+	for _, c := range clients {
+		if !h.users[c.userID][c] {
+			// Left since the list was taken
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+	for _, c := range slow {
+		h.Logger.Printf("hub: dropping slow client %s", c.userID)
+		h.leave(c)
+	}
+}
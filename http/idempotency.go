@@ -0,0 +1,133 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// idempotencyKeyHeader lets clients retry a request without it being made
+// twice.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is how long a response is kept for retries.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength is plenty for the UUIDs clients should use.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize is the largest request body read to fingerprint it.
+const maxIdempotentBodySize = 1 << 20
+
+// idempotency makes POST, PATCH and DELETE requests sent with an
+// Idempotency-Key idempotent. The first request's response is stored and
+// sent again when the request is retried with the same key, unless it was a
+// server error, which a retry might not hit. Public routes aren't covered,
+// their responses can't be tied to a user, and neither are secret routes,
+// their responses mustn't be stored.
+func (h *Handler) idempotency(rt *route) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			userID := todo.UserID(r.Header.Get("userID"))
+			switch {
+			case rt.public || rt.secret || key == "" || userID == "" || h.IdempotencyService == nil:
+				next.ServeHTTP(w, r)
+				return
+			case r.Method != http.MethodPost && r.Method != http.MethodPatch && r.Method != http.MethodDelete:
+				next.ServeHTTP(w, r)
+				return
+			case len(key) > maxIdempotencyKeyLength:
+				Problem(w, todo.ErrIdempotencyKeyInvalid, h.TaskHandler.Logger)
+				return
+			}
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			if err != nil {
+				Problem(w, todo.ErrRequestTooLarge, h.TaskHandler.Logger)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			ttl := h.IdempotencyTTL
+			if ttl == 0 {
+				ttl = DefaultIdempotencyTTL
+			}
+			stored, err := h.IdempotencyService.StartIdempotentRequest(userID, key, requestFingerprint(r, body), time.Now().Add(ttl))
+			if err != nil {
+				Problem(w, err, h.TaskHandler.Logger)
+				return
+			}
+			if stored != nil {
+				if stored.Location != "" {
+					w.Header().Set("Location", stored.Location)
+				}
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+				return
+			}
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			saved := false
+			// Let the request be retried after a server error, or if the handler
+			// panics
+			defer func() {
+				if !saved {
+					if err := h.IdempotencyService.ReleaseIdempotencyKey(userID, key); err != nil {
+						h.TaskHandler.Logger.Printf("idempotency error: %s", err)
+					}
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+			saved = true
+			err = h.IdempotencyService.SaveIdempotentResponse(userID, key, &todo.IdempotentResponse{
+				Status:      rec.status,
+				Location:    w.Header().Get("Location"),
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+			if err != nil {
+				h.TaskHandler.Logger.Printf("idempotency error: %s", err)
+			}
+		})
+	}
+}
+
+// requestFingerprint tells apart requests sent with the same key.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response it writes.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
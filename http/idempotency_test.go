@@ -0,0 +1,256 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type idempotencyEntry struct {
+	fingerprint string
+	res         *todo.IdempotentResponse
+}
+
+// memoryIdempotency keeps idempotency keys in memory. Keys don't expire.
+type memoryIdempotency struct {
+	mu   sync.Mutex
+	keys map[string]*idempotencyEntry
+}
+
+func newMemoryIdempotency() *memoryIdempotency {
+	return &memoryIdempotency{keys: map[string]*idempotencyEntry{}}
+}
+
+func (s *memoryIdempotency) StartIdempotentRequest(userID todo.UserID, key string, fingerprint string, expiresAt time.Time) (*todo.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.keys[string(userID)+" "+key]
+	if !ok {
+		s.keys[string(userID)+" "+key] = &idempotencyEntry{fingerprint: fingerprint}
+		return nil, nil
+	} else if e.fingerprint != fingerprint {
+		return nil, todo.ErrIdempotencyKeyReused
+	} else if e.res == nil {
+		return nil, todo.ErrIdempotencyKeyInProgress
+	}
+	return e.res, nil
+}
+
+func (s *memoryIdempotency) SaveIdempotentResponse(userID todo.UserID, key string, res *todo.IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.keys[string(userID)+" "+key]; ok {
+		e.res = res
+	}
+	return nil
+}
+
+func (s *memoryIdempotency) ReleaseIdempotencyKey(userID todo.UserID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, string(userID)+" "+key)
+	return nil
+}
+
+func (s *memoryIdempotency) has(userID todo.UserID, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[string(userID)+" "+key]
+	return ok
+}
+
+type thingRequest struct {
+	// Result is what the handler does: fail with a problem, error, panic,
+	// wait until release is closed, or by default create a thing.
+	Result string `json:"result"`
+}
+
+// idempotencyTest serves test routes that count how often they are called.
+type idempotencyTest struct {
+	t       *testing.T
+	h       *Handler
+	keys    *memoryIdempotency
+	user    *testClient
+	mu      sync.Mutex
+	calls   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func newIdempotencyTest(t *testing.T) *idempotencyTest {
+	h := newTestHandler()
+	it := &idempotencyTest{
+		t:       t,
+		h:       h,
+		keys:    newMemoryIdempotency(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	h.IdempotencyService = it.keys
+	h.TaskHandler.POST("/api/things", it.handleThing)
+	h.TaskHandler.Secret(http.MethodPost, "/api/things/secret", it.handleThing)
+	h.TaskHandler.Public(http.MethodPost, "/api/things/public", it.handleThing)
+	it.user = login(t, h, nil, "user")
+	return it
+}
+
+func (it *idempotencyTest) handleThing(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	it.mu.Lock()
+	it.calls++
+	n := it.calls
+	it.mu.Unlock()
+	var req thingRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	switch req.Result {
+	case "problem":
+		Problem(w, todo.ErrInvalidJSON, discard)
+	case "error":
+		Problem(w, errors.New("database is down"), discard)
+	case "panic":
+		panic("handler panicked")
+	case "wait":
+		close(it.started)
+		<-it.release
+		fallthrough
+	default:
+		w.Header().Set("Location", "/api/things/"+strconv.Itoa(n))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"thing": n})
+	}
+}
+
+// do sends a request with the Idempotency-Key as the logged in user.
+func (it *idempotencyTest) do(path, key, result string) *httptest.ResponseRecorder {
+	it.t.Helper()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"result":"`+result+`"}`))
+	r.AddCookie(it.user.cookie)
+	r.Header.Set(csrfHeader, it.user.csrf)
+	r.Header.Set(idempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	it.h.ServeHTTP(w, r)
+	return w
+}
+
+func (it *idempotencyTest) expectCalls(want int) {
+	it.t.Helper()
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.calls != want {
+		it.t.Errorf("handler called %d times, want %d", it.calls, want)
+	}
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	it := newIdempotencyTest(t)
+	first := it.do("/api/things", "key", "")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("status %d, want %d: %s", first.Code, http.StatusCreated, first.Body)
+	}
+	replay := it.do("/api/things", "key", "")
+	it.expectCalls(1)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	for _, header := range []string{"Location", "Content-Type"} {
+		if got, want := replay.Header().Get(header), first.Header().Get(header); got != want {
+			t.Errorf("replayed %s = %q, want %q", header, got, want)
+		}
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay not marked Idempotent-Replayed")
+	}
+	// Another key is another request
+	it.do("/api/things", "other key", "")
+	it.expectCalls(2)
+}
+
+func TestIdempotentReplayProblem(t *testing.T) {
+	it := newIdempotencyTest(t)
+	first := it.do("/api/things", "key", "problem")
+	replay := it.do("/api/things", "key", "problem")
+	it.expectCalls(1)
+	if replay.Code != http.StatusBadRequest || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if got := replay.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("replayed Content-Type = %q, want application/problem+json", got)
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	it := newIdempotencyTest(t)
+	it.do("/api/things", "key", "")
+	w := it.do("/api/things", "key", "problem")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	it.expectCalls(1)
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	it := newIdempotencyTest(t)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- it.do("/api/things", "key", "wait") }()
+	<-it.started
+	w := it.do("/api/things", "key", "wait")
+	if w.Code != http.StatusConflict {
+		t.Errorf("status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	close(it.release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request status %d, want %d", first.Code, http.StatusCreated)
+	}
+	it.expectCalls(1)
+}
+
+func TestIdempotencyKeyReleased(t *testing.T) {
+	it := newIdempotencyTest(t)
+	if w := it.do("/api/things", "key", "error"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if it.keys.has("user", "key") {
+		t.Error("key kept after a server error")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("handler didn't panic")
+			}
+		}()
+		it.do("/api/things", "panic key", "panic")
+	}()
+	if it.keys.has("user", "panic key") {
+		t.Error("key kept after a panic")
+	}
+
+	// The request can be retried with the key
+	if w := it.do("/api/things", "key", "error"); w.Code != http.StatusInternalServerError {
+		t.Errorf("retry status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	it.expectCalls(3)
+}
+
+func TestIdempotencySkipsSecretAndPublicRoutes(t *testing.T) {
+	it := newIdempotencyTest(t)
+	for _, path := range []string{"/api/things/secret", "/api/things/public"} {
+		for i := 0; i < 2; i++ {
+			if w := it.do(path, path, ""); w.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("%s response replayed", path)
+			}
+		}
+		if it.keys.has("user", path) || it.keys.has("", path) {
+			t.Errorf("%s key stored", path)
+		}
+	}
+	it.expectCalls(4)
+}
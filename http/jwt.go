@@ -0,0 +1,123 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+	"github.com/kennedymj97/todo-api/jwt"
+)
+
+// loginModeJWT is the login mode that returns tokens instead of setting a
+// session cookie, for clients like native apps that don't keep cookies.
+const loginModeJWT = "jwt"
+
+type tokenResponse struct {
+	AccessToken string `json:"accessToken"`
+	TokenType   string `json:"tokenType"`
+	// ExpiresIn is how many seconds the access token works for.
+	ExpiresIn    int    `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// checkLoginMode returns an error unless the handler can log in with mode.
+func (h *UserHandler) checkLoginMode(mode string) error {
+	switch mode {
+	case "":
+		return nil
+	case loginModeJWT:
+		if h.JWTKeys == nil || h.RefreshTokenService == nil {
+			return todo.ErrJWTDisabled
+		}
+		return nil
+	}
+	return todo.ErrLoginModeUnknown
+}
+
+// login finishes logging the user in with the mode they asked for.
+func (h *UserHandler) login(w http.ResponseWriter, r *http.Request, userID todo.UserID, mode string) {
+	if mode != loginModeJWT {
+		h.createSession(w, r, userID)
+		return
+	}
+	refreshToken, hash, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if err := h.RefreshTokenService.CreateRefreshToken(userID, hash, time.Now().Add(h.RefreshTokenTTL)); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	h.writeTokens(w, userID, refreshToken)
+}
+
+func (h *UserHandler) writeTokens(w http.ResponseWriter, userID todo.UserID, refreshToken string) {
+	now := time.Now()
+	accessToken, err := h.JWTKeys.Sign(&jwt.Claims{
+		Subject:   string(userID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(h.AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, h.Logger)
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// handleRefreshToken swaps a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func (h *UserHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req refreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if err := h.checkLoginMode(loginModeJWT); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	refreshToken, hash, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	switch userID, err := h.RefreshTokenService.RotateRefreshToken(hashToken(req.RefreshToken), hash, time.Now().Add(h.RefreshTokenTTL)); err {
+	case nil:
+		h.writeTokens(w, userID, refreshToken)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+// handleRevokeToken logs out a token login. Access tokens already handed
+// out keep working until they expire.
+func (h *UserHandler) handleRevokeToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req refreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if err := h.checkLoginMode(loginModeJWT); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	switch err := h.RefreshTokenService.RevokeRefreshToken(hashToken(req.RefreshToken)); err {
+	case nil, todo.ErrRefreshTokenInvalid:
+		encodeJSON(w, &infoResponse{"Succesfully logged out"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when a login is for an email without an
+// account, so it takes as long as a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// clientIP returns the address the request came from, failed logins are
+// counted against it. Behind one of TrustedProxies it is the last address
+// in X-Forwarded-For that isn't a trusted proxy, the addresses before it
+// were sent by the client and could be anything. Other requests'
+// X-Forwarded-For is ignored.
+func (h *UserHandler) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	var forwarded []string
+	for _, v := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0 && h.trustedProxy(ip); i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+	}
+	return ip
+}
+
+func (h *UserHandler) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, proxy := range h.TrustedProxies {
+		if ip != nil && proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and
+// CIDR ranges.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", s)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// countLoginAttempt counts a login attempt as failed until
+// loginSucceeded takes it back. If logins are locked out the client is told
+// when to try again and false is returned.
+func (h *UserHandler) countLoginAttempt(w http.ResponseWriter, email todo.Email, ip string) bool {
+	if h.LoginAttempts == nil {
+		return true
+	}
+	until, err := h.LoginAttempts.LoginAttempt(email, ip)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return false
+	} else if !until.IsZero() {
+		loginLocked(w, until, h.Logger)
+		return false
+	}
+	return true
+}
+
+// loginSucceeded takes back the attempt once the user has logged in,
+// including the second factor if they have one.
+func (h *UserHandler) loginSucceeded(email todo.Email, ip string) {
+	if h.LoginAttempts == nil {
+		return
+	}
+	if err := h.LoginAttempts.LoginSucceeded(email, ip); err != nil {
+		h.Logger.Printf("login error: %s", err)
+	}
+}
+
+// loginLocked tells the client when it can try logging in again.
+func loginLocked(w http.ResponseWriter, until time.Time, logger *log.Logger) {
+	wait := math.Ceil(time.Until(until).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(wait, 1))))
+	Problem(w, todo.ErrLoginLocked, logger)
+}
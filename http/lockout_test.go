@@ -0,0 +1,308 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (s *memoryUsers) CreateUserSession(tokenHash string, userID todo.UserID, expiryTime todo.ExpiryTime, userAgent string, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[tokenHash] = userID
+	return nil
+}
+
+func (s *memoryUsers) IsAdmin(id todo.UserID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.admins[id], nil
+}
+
+type attemptKey struct {
+	kind    todo.LockoutKind
+	subject string
+}
+
+type memoryAttempts struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// memoryLoginAttempts counts failed logins in memory the way the postgres
+// service does, except failures are never forgotten.
+type memoryLoginAttempts struct {
+	mu            sync.Mutex
+	accountPolicy todo.LoginPolicy
+	ipPolicy      todo.LoginPolicy
+	attempts      map[attemptKey]*memoryAttempts
+	unlockedBy    todo.UserID
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{
+		accountPolicy: todo.LoginPolicy{Attempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour},
+		ipPolicy:      todo.LoginPolicy{Attempts: 5, Backoff: time.Minute, MaxBackoff: time.Hour},
+		attempts:      map[attemptKey]*memoryAttempts{},
+	}
+}
+
+func (s *memoryLoginAttempts) LoginAttempt(email todo.Email, ip string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []attemptKey{{todo.LockoutAccount, string(email)}, {todo.LockoutIP, ip}}
+	policies := []todo.LoginPolicy{s.accountPolicy, s.ipPolicy}
+	var until time.Time
+	for _, k := range keys {
+		if a, ok := s.attempts[k]; ok && a.lockedUntil.After(until) {
+			until = a.lockedUntil
+		}
+	}
+	if until.After(time.Now()) {
+		return until, nil
+	}
+	for i, k := range keys {
+		if k.subject == "" {
+			continue
+		}
+		a, ok := s.attempts[k]
+		if !ok {
+			a = &memoryAttempts{}
+			s.attempts[k] = a
+		}
+		a.failures++
+		if lockout := policies[i].Lockout(a.failures); lockout > 0 {
+			a.lockedUntil = time.Now().Add(lockout)
+		}
+	}
+	return time.Time{}, nil
+}
+
+func (s *memoryLoginAttempts) LoginSucceeded(email todo.Email, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, attemptKey{todo.LockoutAccount, string(email)})
+	if a, ok := s.attempts[attemptKey{todo.LockoutIP, ip}]; ok {
+		a.failures--
+		if a.failures < s.ipPolicy.Attempts {
+			a.lockedUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *memoryLoginAttempts) Lockouts() (*todo.Lockouts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockouts := todo.Lockouts{}
+	for k, a := range s.attempts {
+		if a.lockedUntil.After(time.Now()) {
+			lockouts = append(lockouts, todo.Lockout{Kind: k.kind, Subject: k.subject, Failures: a.failures})
+		}
+	}
+	return &lockouts, nil
+}
+
+func (s *memoryLoginAttempts) Unlock(kind todo.LockoutKind, subject string, adminID todo.UserID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kind != todo.LockoutAccount && kind != todo.LockoutIP {
+		return todo.ErrLockoutKind
+	}
+	k := attemptKey{kind, subject}
+	if _, ok := s.attempts[k]; !ok {
+		return todo.ErrLockoutNotFound
+	}
+	delete(s.attempts, k)
+	s.unlockedBy = adminID
+	return nil
+}
+
+// lockoutTest is a server behind a trusted proxy, so each login can come
+// from a different client address.
+type lockoutTest struct {
+	t        *testing.T
+	h        *Handler
+	srv      *httptest.Server
+	attempts *memoryLoginAttempts
+}
+
+func newLockoutTest(t *testing.T) *lockoutTest {
+	h := newTestHandler()
+	l := &lockoutTest{t: t, h: h, attempts: newMemoryLoginAttempts()}
+	h.UserHandler.LoginAttempts = l.attempts
+	proxies, err := ParseTrustedProxies("127.0.0.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.UserHandler.TrustedProxies = proxies
+	users := h.UserHandler.UserService.(*memoryUsers)
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []todo.Email{"a@example.com", "b@example.com"} {
+		if err := users.CreateUser(email, string(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.srv = httptest.NewServer(h)
+	t.Cleanup(l.srv.Close)
+	return l
+}
+
+// login tries to log in as if from the client address ip.
+func (l *lockoutTest) login(email, password, ip string) *http.Response {
+	l.t.Helper()
+	body := `{"email":"` + email + `","password":"` + password + `"}`
+	req, err := http.NewRequest(http.MethodPost, l.srv.URL+"/api/users/login", strings.NewReader(body))
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	req.Header.Set("X-Forwarded-For", ip)
+	resp, err := l.srv.Client().Do(req)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func (l *lockoutTest) expectLogin(email, password, ip string, status int) *http.Response {
+	l.t.Helper()
+	resp := l.login(email, password, ip)
+	if resp.StatusCode != status {
+		l.t.Fatalf("login(%s, %s) status %d, want %d", email, ip, resp.StatusCode, status)
+	}
+	return resp
+}
+
+func TestLoginAccountLockout(t *testing.T) {
+	l := newLockoutTest(t)
+	// Logging in forgets the account's failures
+	for i := 0; i < 2; i++ {
+		l.expectLogin("a@example.com", "wrong", "192.0.2.9", http.StatusUnauthorized)
+	}
+	l.expectLogin("a@example.com", "password", "192.0.2.9", http.StatusOK)
+
+	for i := 0; i < 3; i++ {
+		l.expectLogin("a@example.com", "wrong", "192.0.2.1", http.StatusUnauthorized)
+	}
+	resp := l.expectLogin("a@example.com", "password", "192.0.2.1", http.StatusTooManyRequests)
+	if got := resp.Header.Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	// The account is locked wherever the login comes from
+	l.expectLogin("A@Example.com", "password", "198.51.100.1", http.StatusTooManyRequests)
+	// but the address can still log into other accounts
+	l.expectLogin("b@example.com", "password", "192.0.2.1", http.StatusOK)
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	l := newLockoutTest(t)
+	for _, email := range []string{"c@example.com", "d@example.com", "e@example.com", "f@example.com", "g@example.com"} {
+		l.expectLogin(email, "wrong", "192.0.2.1", http.StatusUnauthorized)
+	}
+	l.expectLogin("a@example.com", "password", "192.0.2.1", http.StatusTooManyRequests)
+	l.expectLogin("a@example.com", "password", "198.51.100.1", http.StatusOK)
+}
+
+func TestAdminUnlock(t *testing.T) {
+	l := newLockoutTest(t)
+	l.h.AdminHandler.UserService = l.h.UserHandler.UserService
+	l.h.AdminHandler.LoginAttemptService = l.attempts
+	users := l.h.UserHandler.UserService.(*memoryUsers)
+	users.admins = map[todo.UserID]bool{"admin": true}
+	admin := login(t, l.h, l.srv, "admin")
+	user := login(t, l.h, l.srv, "user")
+
+	for i := 0; i < 3; i++ {
+		l.expectLogin("a@example.com", "wrong", "192.0.2.1", http.StatusUnauthorized)
+	}
+	l.expectLogin("a@example.com", "password", "192.0.2.1", http.StatusTooManyRequests)
+
+	var lockouts getLockoutsResponse
+	decode(t, admin.do(http.MethodGet, "/api/admin/lockouts", nil), http.StatusOK, &lockouts)
+	if len(*lockouts.Lockouts) != 1 || (*lockouts.Lockouts)[0].Subject != "a@example.com" {
+		t.Errorf("lockouts = %+v", *lockouts.Lockouts)
+	}
+	decode(t, user.do(http.MethodGet, "/api/admin/lockouts", nil), http.StatusForbidden, nil)
+
+	unlock := &unlockRequest{Kind: todo.LockoutAccount, Subject: " A@Example.com"}
+	decode(t, user.do(http.MethodPost, "/api/admin/unlock", unlock), http.StatusForbidden, nil)
+	decode(t, admin.do(http.MethodPost, "/api/admin/unlock", unlock), http.StatusOK, nil)
+	if l.attempts.unlockedBy != "admin" {
+		t.Errorf("unlocked by %q, want admin", l.attempts.unlockedBy)
+	}
+	l.expectLogin("a@example.com", "password", "192.0.2.1", http.StatusOK)
+
+	decode(t, admin.do(http.MethodPost, "/api/admin/unlock", unlock), http.StatusNotFound, nil)
+	decode(t, admin.do(http.MethodPost, "/api/admin/unlock", &unlockRequest{Kind: "user", Subject: "a@example.com"}), http.StatusBadRequest, nil)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &UserHandler{TrustedProxies: proxies}
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.9:1234", nil, "203.0.113.9"},
+		{"untrusted forwarded", "203.0.113.9:1234", []string{"198.51.100.1"}, "203.0.113.9"},
+		{"trusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed first address", "192.0.2.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"all trusted", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"invalid address", "10.0.0.1:1234", []string{"198.51.100.1, nonsense"}, "10.0.0.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"ipv6", "[2001:db8::1]:1234", []string{"198.51.100.1"}, "2001:db8::1"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/users/login", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, v := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := h.clientIP(r); got != test.want {
+			t.Errorf("%s: clientIP = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("192.0.2.1,10.0.0.0/8, 2001:db8::/32,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"10.1.2.3":    true,
+		"2001:db8::5": true,
+		"2001:db9::5": false,
+	} {
+		trusted := false
+		for _, proxy := range proxies {
+			trusted = trusted || proxy.Contains(net.ParseIP(ip))
+		}
+		if trusted != want {
+			t.Errorf("%s trusted = %t, want %t", ip, trusted, want)
+		}
+	}
+	for _, list := range []string{"nonsense", "10.0.0.0/33", "192.0.2.1, localhost"} {
+		if _, err := ParseTrustedProxies(list); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", list)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+	"github.com/kennedymj97/todo-api/totp"
+)
+
+// mfaChallengeTTL is how long a user has to enter their code after their
+// password has been accepted.
+const mfaChallengeTTL = 5 * time.Minute
+
+// recoveryCodeCount recovery codes are issued when MFA is turned on.
+const recoveryCodeCount = 10
+
+// mfaIssuer is the account issuer shown in authenticator apps.
+const mfaIssuer = "todo"
+
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	Challenge   string `json:"challenge"`
+}
+
+// startMFAChallenge is the first half of logging in with MFA: instead of a
+// session the user gets a challenge to send back with their code.
+func (h *UserHandler) startMFAChallenge(w http.ResponseWriter, userID todo.UserID) {
+	challenge, hash, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if err := h.MFAService.CreateMFAChallenge(userID, hash, time.Now().Add(mfaChallengeTTL)); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &mfaChallengeResponse{MFARequired: true, Challenge: challenge}, h.Logger)
+}
+
+type loginMFARequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+	Mode      string `json:"mode"`
+}
+
+// handleLoginMFA finishes logging in with a code from the user's app or one
+// of their recovery codes. Every code counts as a login attempt, as a
+// challenge can be asked for as often as the password is known.
+func (h *UserHandler) handleLoginMFA(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req loginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if h.MFAService == nil {
+		Problem(w, todo.ErrMFAChallengeInvalid, h.Logger)
+		return
+	}
+	if err := h.checkLoginMode(req.Mode); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	challengeHash := hashToken(req.Challenge)
+	userID, err := h.MFAService.MFAChallenge(challengeHash)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	user, err := h.UserService.Account(userID)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	ip := h.clientIP(r)
+	if !h.countLoginAttempt(w, user.Email, ip) {
+		return
+	}
+	err = h.MFAService.VerifyMFA(userID, req.Code, hashToken(normalizeRecoveryCode(req.Code)))
+	if err == nil {
+		err = h.MFAService.DeleteMFAChallenge(challengeHash)
+	}
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	h.loginSucceeded(user.Email, ip)
+	h.login(w, r, userID, req.Mode)
+}
+
+type enrollMFAResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// handleEnrollMFA starts turning on MFA. The URI is usually shown as a QR
+// code for the user's app to scan.
+func (h *UserHandler) handleEnrollMFA(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := h.UserService.Account(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if err := h.MFAService.EnrollMFA(user.ID, secret); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &enrollMFAResponse{Secret: secret, URI: totp.URI(mfaIssuer, string(user.Email), secret)}, h.Logger)
+}
+
+type confirmMFARequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// handleConfirmMFA turns MFA on once the user sends a code from their app.
+// The recovery codes are only ever shown in this response.
+func (h *UserHandler) handleConfirmMFA(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req confirmMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	if err := h.MFAService.ConfirmMFA(todo.UserID(r.Header.Get("userID")), req.Code, hashes); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &recoveryCodesResponse{codes}, h.Logger)
+}
+
+type disableMFARequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	Code            string `json:"code"`
+}
+
+// handleDisableMFA turns MFA off. Both the password and a code are needed,
+// so a stolen session alone can't remove the second factor.
+func (h *UserHandler) handleDisableMFA(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req disableMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	userID := todo.UserID(r.Header.Get("userID"))
+	_, err := h.checkPassword(userID, req.CurrentPassword)
+	if err == nil {
+		err = h.MFAService.VerifyMFA(userID, req.Code, hashToken(normalizeRecoveryCode(req.Code)))
+	}
+	if err == nil {
+		err = h.MFAService.DisableMFA(userID)
+	}
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &infoResponse{"Two-factor authentication has been turned off"}, h.Logger)
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a random 50 bit code like "abcde-fghij".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes in any case, with or
+// without the dash.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
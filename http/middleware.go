@@ -0,0 +1,74 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader identifies a request in the logs and in error responses.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request id taken from a client.
+const maxRequestIDLength = 64
+
+// Middleware wraps a handler to do something before or after it.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in the middleware. The first middleware sees the request
+// first.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// LogRequests logs every request.
+func LogRequests(logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.Printf("%s %s %s (request=%s)", r.Proto, r.Method, r.URL.Path, w.Header().Get(requestIDHeader))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// JSONContent marks every response as JSON.
+func JSONContent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestID gives every response an X-Request-ID header. The client's id is
+// used if it sent a sensible one, so requests can be followed through
+// proxies.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type NotificationHandler struct {
+	*router
+	NotificationService todo.NotificationService
+	Logger              *log.Logger
+}
+
+func NewNotificationHandler() *NotificationHandler {
+	h := &NotificationHandler{
+		router: newScopedRouter("notifications"),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/notifications", h.handleNotifications)
+	h.GET("/api/notifications/unread", h.handleUnreadCount)
+	h.POST("/api/notifications/read/:id", h.handleMarkRead)
+	h.POST("/api/notifications/readAll", h.handleMarkAllRead)
+	h.GET("/api/notifications/preferences", h.handlePreferences)
+	h.POST("/api/notifications/preferences", h.handleSetPreference)
+	return h
+}
+
+type getNotificationsResponse struct {
+	Notifications *todo.Notifications `json:"notifications"`
+}
+
+// handleNotifications returns the user's latest notifications, or only the
+// unread ones when the unread query parameter is true.
+func (h *NotificationHandler) handleNotifications(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	notifications, err := h.NotificationService.Notifications(todo.UserID(r.Header.Get("userID")), unreadOnly)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getNotificationsResponse{Notifications: notifications}, h.Logger)
+}
+
+type unreadCountResponse struct {
+	Count int `json:"count"`
+}
+
+func (h *NotificationHandler) handleUnreadCount(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	count, err := h.NotificationService.UnreadCount(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &unreadCountResponse{Count: count}, h.Logger)
+}
+
+func (h *NotificationHandler) handleMarkRead(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.NotificationService.MarkRead(todo.NotificationID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Notification marked as read"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *NotificationHandler) handleMarkAllRead(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := h.NotificationService.MarkAllRead(todo.UserID(r.Header.Get("userID"))); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &infoResponse{"All notifications marked as read"}, h.Logger)
+}
+
+type preferencesResponse struct {
+	Preferences todo.NotificationPreferences `json:"preferences"`
+}
+
+func (h *NotificationHandler) handlePreferences(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	prefs, err := h.NotificationService.Preferences(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &preferencesResponse{Preferences: prefs}, h.Logger)
+}
+
+type setPreferenceRequest struct {
+	Type    todo.EventType `json:"type"`
+	Enabled bool           `json:"enabled"`
+}
+
+func (h *NotificationHandler) handleSetPreference(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req setPreferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.NotificationService.SetPreference(todo.UserID(r.Header.Get("userID")), req.Type, req.Enabled); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Notification preference saved"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
@@ -0,0 +1,410 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// Prefixes of the tokens handed to OAuth clients.
+const (
+	oauthAccessPrefix  = "oat_"
+	oauthRefreshPrefix = "ort_"
+	oauthSecretPrefix  = "ocs_"
+)
+
+// OAuthHandler is an OAuth2 authorization server (RFC 6749) for the
+// authorization code flow. PKCE (RFC 7636) with S256 is required of every
+// client. The web app renders the consent screen from GET
+// /api/oauth/authorize and posts the user's answer back to it. Scopes are
+// the same as API tokens'.
+type OAuthHandler struct {
+	*router
+	OAuthService    todo.OAuthService
+	CodeTTL         time.Duration
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Logger          *log.Logger
+}
+
+func NewOAuthHandler() *OAuthHandler {
+	h := &OAuthHandler{
+		router:          newRouter(),
+		CodeTTL:         time.Minute,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		Logger:          log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/oauth/clients", h.handleClients)
+	h.Secret(http.MethodPost, "/api/oauth/clients", h.handleCreateClient)
+	h.DELETE("/api/oauth/clients/:id", h.handleDeleteClient)
+	h.GET("/api/oauth/authorize", h.handleConsent)
+	h.Secret(http.MethodPost, "/api/oauth/authorize", h.handleAuthorize)
+	// Clients authenticate themselves
+	h.Public(http.MethodPost, "/api/oauth/token", h.handleToken)
+	h.Public(http.MethodPost, "/api/oauth/revoke", h.handleRevoke)
+	return h
+}
+
+type getOAuthClientsResponse struct {
+	Clients *todo.OAuthClients `json:"clients"`
+}
+
+func (h *OAuthHandler) handleClients(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	clients, err := h.OAuthService.OAuthClients(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getOAuthClientsResponse{Clients: clients}, h.Logger)
+}
+
+type createClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type createClientResponse struct {
+	ClientID todo.OAuthClientID `json:"clientId"`
+	// ClientSecret is only ever returned here, and only to confidential
+	// clients.
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// handleCreateClient registers a client owned by the user.
+func (h *OAuthHandler) handleCreateClient(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	client := &todo.OAuthClient{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		OwnerID:      todo.UserID(r.Header.Get("userID")),
+	}
+	var secret string
+	if req.Confidential {
+		token, _, err := newToken()
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		}
+		secret = oauthSecretPrefix + token
+		client.SecretHash = hashToken(secret)
+	}
+	switch id, err := h.OAuthService.CreateOAuthClient(client); err {
+	case nil:
+		encodeJSON(w, &createClientResponse{ClientID: id, ClientSecret: secret}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *OAuthHandler) handleDeleteClient(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch err := h.OAuthService.DeleteOAuthClient(todo.OAuthClientID(ps.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Client has been deleted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+// authorizeRequest is an authorization request's query parameters, checked
+// for the client they name.
+type authorizeRequest struct {
+	client      *todo.OAuthClient
+	redirectURI string
+	// explicitRedirect is whether the request had the redirect URI, rather
+	// than it being the client's only one.
+	explicitRedirect bool
+	scopes           []string
+	state            string
+	codeChallenge    string
+}
+
+// parseAuthorize checks an authorization request. Errors with the client or
+// redirect URI are returned, the user must not be sent to a redirect URI
+// that isn't registered. Other errors are returned as an OAuth error code
+// to redirect back with.
+func (h *OAuthHandler) parseAuthorize(q url.Values) (*authorizeRequest, string, error) {
+	client, err := h.OAuthService.OAuthClient(todo.OAuthClientID(q.Get("client_id")))
+	if err != nil {
+		return nil, "", err
+	}
+	req := &authorizeRequest{client: client, redirectURI: q.Get("redirect_uri"), state: q.Get("state")}
+	req.explicitRedirect = req.redirectURI != ""
+	if !req.explicitRedirect && len(client.RedirectURIs) == 1 {
+		req.redirectURI = client.RedirectURIs[0]
+	}
+	registered := false
+	for _, uri := range client.RedirectURIs {
+		registered = registered || uri == req.redirectURI
+	}
+	if !registered {
+		return nil, "", todo.ErrRedirectURIInvalid
+	}
+	if q.Get("response_type") != "code" {
+		return req, "unsupported_response_type", nil
+	}
+	if req.scopes, err = parseScopes(q.Get("scope")); err != nil {
+		return req, "invalid_scope", nil
+	}
+	req.codeChallenge = q.Get("code_challenge")
+	if req.codeChallenge == "" || q.Get("code_challenge_method") != "S256" {
+		return req, "invalid_request", nil
+	}
+	return req, "", nil
+}
+
+type consentResponse struct {
+	ClientID    todo.OAuthClientID `json:"clientId"`
+	ClientName  string             `json:"clientName"`
+	RedirectURI string             `json:"redirectUri"`
+	Scopes      []string           `json:"scopes"`
+}
+
+// handleConsent returns what the consent screen should ask the user.
+func (h *OAuthHandler) handleConsent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req, code, err := h.parseAuthorize(r.URL.Query())
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	} else if code != "" {
+		encodeJSON(w, &redirectResponse{oauthRedirect(req.redirectURI, url.Values{"error": {code}, "state": {req.state}})}, h.Logger)
+		return
+	}
+	encodeJSON(w, &consentResponse{
+		ClientID:    req.client.ID,
+		ClientName:  req.client.Name,
+		RedirectURI: req.redirectURI,
+		Scopes:      req.scopes,
+	}, h.Logger)
+}
+
+type authorizeDecision struct {
+	Approve bool `json:"approve"`
+}
+
+type redirectResponse struct {
+	Redirect string `json:"redirect"`
+}
+
+// handleAuthorize records the user's answer on the consent screen and
+// returns where to send them back to the client, with a code if they
+// approved. The request's query is the authorization request.
+func (h *OAuthHandler) handleAuthorize(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var decision authorizeDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	req, code, err := h.parseAuthorize(r.URL.Query())
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	params := url.Values{"state": {req.state}}
+	if code == "" && !decision.Approve {
+		code = "access_denied"
+	}
+	if code != "" {
+		params.Set("error", code)
+		encodeJSON(w, &redirectResponse{oauthRedirect(req.redirectURI, params)}, h.Logger)
+		return
+	}
+	authCode, hash, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	err = h.OAuthService.CreateOAuthCode(hash, &todo.OAuthCode{
+		ClientID:            req.client.ID,
+		UserID:              todo.UserID(r.Header.Get("userID")),
+		RedirectURI:         req.redirectURI,
+		RedirectURIExplicit: req.explicitRedirect,
+		Scopes:              req.scopes,
+		CodeChallenge:       req.codeChallenge,
+		ExpiresAt:           time.Now().Add(h.CodeTTL),
+	})
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	params.Set("code", authCode)
+	encodeJSON(w, &redirectResponse{oauthRedirect(req.redirectURI, params)}, h.Logger)
+}
+
+// oauthRedirect adds params to the redirect URI's query, leaving out empty
+// ones.
+func oauthRedirect(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// oauthErrorResponse is the error format of RFC 6749 section 5.2.
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// handleToken exchanges an authorization code or refresh token for a new
+// token pair.
+func (h *OAuthHandler) handleToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		h.oauthError(w, "invalid_request", err)
+		return
+	}
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.oauthError(w, "invalid_client", err)
+		return
+	}
+	access, _, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	refresh, _, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	access, refresh = oauthAccessPrefix+access, oauthRefreshPrefix+refresh
+	now := time.Now()
+	tokens := &todo.OAuthTokenHashes{
+		AccessHash:       hashToken(access),
+		AccessExpiresAt:  now.Add(h.AccessTokenTTL),
+		RefreshHash:      hashToken(refresh),
+		RefreshExpiresAt: now.Add(h.RefreshTokenTTL),
+	}
+	var grant *todo.OAuthGrant
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		grant, err = h.OAuthService.ExchangeOAuthCode(hashToken(r.PostForm.Get("code")), client.ID,
+			r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"), tokens)
+	case "refresh_token":
+		grant, err = h.OAuthService.RefreshOAuthToken(hashToken(r.PostForm.Get("refresh_token")), client.ID, tokens)
+	default:
+		h.oauthError(w, "unsupported_grant_type", nil)
+		return
+	}
+	switch err {
+	case nil:
+		encodeJSON(w, &oauthTokenResponse{
+			AccessToken:  access,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(h.AccessTokenTTL.Seconds()),
+			RefreshToken: refresh,
+			Scope:        strings.Join(grant.Scopes, " "),
+		}, h.Logger)
+	case todo.ErrOAuthGrantInvalid:
+		h.oauthError(w, "invalid_grant", err)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+// handleRevoke revokes a token pair by either of its tokens, as in RFC
+// 7009. Unknown tokens aren't an error.
+func (h *OAuthHandler) handleRevoke(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		h.oauthError(w, "invalid_request", err)
+		return
+	}
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.oauthError(w, "invalid_client", err)
+		return
+	}
+	if err := h.OAuthService.RevokeOAuthToken(hashToken(r.PostForm.Get("token")), client.ID); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient returns the client making a token or revoke request.
+// Confidential clients send their secret with HTTP basic auth or in the
+// form, public clients only send their ID.
+func (h *OAuthHandler) authenticateClient(r *http.Request) (*todo.OAuthClient, error) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := h.OAuthService.OAuthClient(todo.OAuthClientID(id))
+	if err == todo.ErrOAuthClientNotFound || err == todo.ErrOAuthClientIDRequired {
+		return nil, todo.ErrOAuthClientAuthFailed
+	} else if err != nil {
+		return nil, err
+	}
+	if client.Confidential && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, todo.ErrOAuthClientAuthFailed
+	}
+	return client, nil
+}
+
+func (h *OAuthHandler) oauthError(w http.ResponseWriter, code string, err error) {
+	status := http.StatusBadRequest
+	if code == "invalid_client" {
+		if err != todo.ErrOAuthClientAuthFailed {
+			Problem(w, err, h.Logger)
+			return
+		}
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	resp := &oauthErrorResponse{Error: code}
+	if err != nil {
+		resp.Description = err.Error()
+	}
+	w.WriteHeader(status)
+	encodeJSON(w, resp, h.Logger)
+}
+
+// parseScopes splits a space separated scope parameter, every scope must
+// be one API tokens can have.
+func parseScopes(s string) ([]string, error) {
+	scopes := strings.Fields(s)
+	if len(scopes) == 0 {
+		return nil, todo.ErrScopesRequired
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, k := range todo.Scopes {
+			known = known || k == scope
+		}
+		if !known {
+			return nil, todo.ErrScopeUnknown
+		}
+	}
+	return scopes, nil
+}
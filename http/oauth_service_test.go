@@ -0,0 +1,440 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// memoryOAuth is an OAuthService that keeps everything in memory, with the
+// same rules for codes and tokens as the postgres one.
+type memoryOAuth struct {
+	mu      sync.Mutex
+	clients map[todo.OAuthClientID]*todo.OAuthClient
+	codes   map[string]*memoryOAuthCode
+	tokens  map[int]*memoryOAuthToken
+	nextID  int
+}
+
+type memoryOAuthCode struct {
+	todo.OAuthCode
+	// tokenID is the token pair the code was exchanged for, 0 until it is.
+	tokenID int
+}
+
+type memoryOAuthToken struct {
+	grant  todo.OAuthGrant
+	hashes todo.OAuthTokenHashes
+}
+
+func newMemoryOAuth() *memoryOAuth {
+	return &memoryOAuth{
+		clients: map[todo.OAuthClientID]*todo.OAuthClient{},
+		codes:   map[string]*memoryOAuthCode{},
+		tokens:  map[int]*memoryOAuthToken{},
+	}
+}
+
+func (s *memoryOAuth) CreateOAuthClient(c *todo.OAuthClient) (todo.OAuthClientID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.Name == "" {
+		return "", todo.ErrOAuthClientNameRequired
+	} else if len(c.RedirectURIs) == 0 {
+		return "", todo.ErrRedirectURIsRequired
+	}
+	s.nextID++
+	client := *c
+	client.ID = todo.OAuthClientID("client-" + strconv.Itoa(s.nextID))
+	client.Confidential = c.SecretHash != ""
+	s.clients[client.ID] = &client
+	return client.ID, nil
+}
+
+func (s *memoryOAuth) OAuthClients(ownerID todo.UserID) (*todo.OAuthClients, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := todo.OAuthClients{}
+	for _, c := range s.clients {
+		if c.OwnerID == ownerID {
+			clients = append(clients, *c)
+		}
+	}
+	return &clients, nil
+}
+
+func (s *memoryOAuth) OAuthClient(id todo.OAuthClientID) (*todo.OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		return nil, todo.ErrOAuthClientIDRequired
+	} else if c, ok := s.clients[id]; ok {
+		client := *c
+		return &client, nil
+	}
+	return nil, todo.ErrOAuthClientNotFound
+}
+
+func (s *memoryOAuth) DeleteOAuthClient(id todo.OAuthClientID, ownerID todo.UserID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[id]; !ok || c.OwnerID != ownerID {
+		return todo.ErrOAuthClientNotFound
+	}
+	delete(s.clients, id)
+	return nil
+}
+
+func (s *memoryOAuth) CreateOAuthCode(codeHash string, code *todo.OAuthCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[codeHash] = &memoryOAuthCode{OAuthCode: *code}
+	return nil
+}
+
+func (s *memoryOAuth) ExchangeOAuthCode(codeHash string, clientID todo.OAuthClientID, redirectURI string, verifier string, tokens *todo.OAuthTokenHashes) (*todo.OAuthGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.codes[codeHash]
+	if !ok {
+		return nil, todo.ErrOAuthGrantInvalid
+	} else if code.tokenID != 0 {
+		delete(s.tokens, code.tokenID)
+		return nil, todo.ErrOAuthGrantInvalid
+	}
+	redirectOK := code.RedirectURI == redirectURI || (!code.RedirectURIExplicit && redirectURI == "")
+	if time.Now().After(code.ExpiresAt) || code.ClientID != clientID || !redirectOK || !todo.VerifyPKCE(code.CodeChallenge, verifier) {
+		return nil, todo.ErrOAuthGrantInvalid
+	}
+	s.nextID++
+	code.tokenID = s.nextID
+	grant := todo.OAuthGrant{ClientID: clientID, UserID: code.UserID, Scopes: code.Scopes}
+	s.tokens[code.tokenID] = &memoryOAuthToken{grant: grant, hashes: *tokens}
+	return &grant, nil
+}
+
+func (s *memoryOAuth) RefreshOAuthToken(refreshHash string, clientID todo.OAuthClientID, tokens *todo.OAuthTokenHashes) (*todo.OAuthGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.hashes.RefreshHash == refreshHash && token.grant.ClientID == clientID && time.Now().Before(token.hashes.RefreshExpiresAt) {
+			token.hashes = *tokens
+			grant := token.grant
+			return &grant, nil
+		}
+	}
+	return nil, todo.ErrOAuthGrantInvalid
+}
+
+func (s *memoryOAuth) AuthenticateOAuthToken(accessHash string) (todo.UserID, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.hashes.AccessHash == accessHash && time.Now().Before(token.hashes.AccessExpiresAt) {
+			return token.grant.UserID, token.grant.Scopes, nil
+		}
+	}
+	return "", nil, todo.ErrUnauthorized
+}
+
+func (s *memoryOAuth) RevokeOAuthToken(tokenHash string, clientID todo.OAuthClientID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if (token.hashes.AccessHash == tokenHash || token.hashes.RefreshHash == tokenHash) && token.grant.ClientID == clientID {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// memoryTasks only lists tasks, which is all the OAuth tests call.
+type memoryTasks struct {
+	todo.TaskService
+}
+
+func (s *memoryTasks) Tasks(userID todo.UserID) (*todo.Tasks, error) {
+	return &todo.Tasks{}, nil
+}
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	otherRedirectURI = "https://app.example.com/other"
+)
+
+// oauthTest is a server with a confidential client registered by a logged
+// in user.
+type oauthTest struct {
+	t        *testing.T
+	srv      *httptest.Server
+	user     *testClient
+	clientID todo.OAuthClientID
+	secret   string
+	// omitRedirect leaves the redirect URI out of authorization requests.
+	omitRedirect bool
+}
+
+// newOAuthTest registers the client with the redirect URIs, by default
+// testRedirectURI and otherRedirectURI.
+func newOAuthTest(t *testing.T, redirectURIs ...string) *oauthTest {
+	if len(redirectURIs) == 0 {
+		redirectURIs = []string{testRedirectURI, otherRedirectURI}
+	}
+	h := newTestHandler()
+	h.OAuthHandler.OAuthService = newMemoryOAuth()
+	h.TaskHandler.TaskService = &memoryTasks{}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	o := &oauthTest{t: t, srv: srv, user: login(t, h, srv, "user")}
+
+	var client createClientResponse
+	decode(t, o.user.do(http.MethodPost, "/api/oauth/clients", &createClientRequest{
+		Name:         "App",
+		RedirectURIs: redirectURIs,
+		Confidential: true,
+	}), http.StatusOK, &client)
+	if client.ClientID == "" || !strings.HasPrefix(client.ClientSecret, oauthSecretPrefix) {
+		t.Fatalf("client = %+v", client)
+	}
+	o.clientID, o.secret = client.ClientID, client.ClientSecret
+	return o
+}
+
+// pkce returns a code verifier and its S256 challenge.
+func pkce(t *testing.T) (string, string) {
+	verifier, _, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier += verifier
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize has the user approve the client for the scope and returns the
+// code the client is sent back with.
+func (o *oauthTest) authorize(scope, challenge string) string {
+	o.t.Helper()
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {string(o.clientID)},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	if o.omitRedirect {
+		q.Del("redirect_uri")
+	}
+	var consent consentResponse
+	decode(o.t, o.user.do(http.MethodGet, "/api/oauth/authorize?"+q.Encode(), nil), http.StatusOK, &consent)
+	if consent.ClientName != "App" || consent.RedirectURI != testRedirectURI || strings.Join(consent.Scopes, " ") != scope {
+		o.t.Fatalf("consent = %+v", consent)
+	}
+	var redirect redirectResponse
+	decode(o.t, o.user.do(http.MethodPost, "/api/oauth/authorize?"+q.Encode(), &authorizeDecision{Approve: true}), http.StatusOK, &redirect)
+	u, err := url.Parse(redirect.Redirect)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	if !strings.HasPrefix(redirect.Redirect, testRedirectURI+"?") || u.Query().Get("state") != "xyz" || u.Query().Get("code") == "" {
+		o.t.Fatalf("redirect = %s", redirect.Redirect)
+	}
+	return u.Query().Get("code")
+}
+
+// token makes a token request as the client.
+func (o *oauthTest) token(form url.Values) *http.Response {
+	return o.post("/api/oauth/token", form)
+}
+
+// post sends a form as the client.
+func (o *oauthTest) post(path string, form url.Values) *http.Response {
+	o.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, o.srv.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(string(o.clientID), o.secret)
+	resp, err := o.srv.Client().Do(req)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return resp
+}
+
+// exchange swaps the code for tokens.
+func (o *oauthTest) exchange(code, redirectURI, verifier string) *http.Response {
+	return o.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// api calls the api with an access token and returns the status code.
+func (o *oauthTest) api(method, path, accessToken string) int {
+	o.t.Helper()
+	req, err := http.NewRequest(method, o.srv.URL+path, strings.NewReader("{}"))
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := o.srv.Client().Do(req)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// expectOAuthError checks a token request failed with the OAuth error code.
+func expectOAuthError(t *testing.T, resp *http.Response, code string) {
+	t.Helper()
+	var e oauthErrorResponse
+	decode(t, resp, http.StatusBadRequest, &e)
+	if e.Error != code {
+		t.Errorf("error = %q, want %q", e.Error, code)
+	}
+}
+
+func TestOAuthFlow(t *testing.T) {
+	o := newOAuthTest(t)
+	verifier, challenge := pkce(t)
+	code := o.authorize("tasks:read", challenge)
+
+	var tokens oauthTokenResponse
+	decode(t, o.exchange(code, testRedirectURI, verifier), http.StatusOK, &tokens)
+	if !strings.HasPrefix(tokens.AccessToken, oauthAccessPrefix) || !strings.HasPrefix(tokens.RefreshToken, oauthRefreshPrefix) ||
+		tokens.TokenType != "Bearer" || tokens.Scope != "tasks:read" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+
+	// The token can do what the user approved and nothing else
+	if status := o.api(http.MethodGet, "/api/tasks", tokens.AccessToken); status != http.StatusOK {
+		t.Errorf("GET /api/tasks with tasks:read = %d, want %d", status, http.StatusOK)
+	}
+	if status := o.api(http.MethodPost, "/api/tasks/create", tokens.AccessToken); status != http.StatusForbidden {
+		t.Errorf("POST /api/tasks/create with tasks:read = %d, want %d", status, http.StatusForbidden)
+	}
+	if status := o.api(http.MethodGet, "/api/oauth/clients", tokens.AccessToken); status != http.StatusForbidden {
+		t.Errorf("GET /api/oauth/clients with a token = %d, want %d", status, http.StatusForbidden)
+	}
+
+	// Refreshing replaces both tokens
+	var refreshed oauthTokenResponse
+	decode(t, o.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}), http.StatusOK, &refreshed)
+	if refreshed.AccessToken == tokens.AccessToken || refreshed.Scope != "tasks:read" {
+		t.Fatalf("refreshed = %+v", refreshed)
+	}
+	if status := o.api(http.MethodGet, "/api/tasks", tokens.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("old access token = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := o.api(http.MethodGet, "/api/tasks", refreshed.AccessToken); status != http.StatusOK {
+		t.Errorf("refreshed access token = %d, want %d", status, http.StatusOK)
+	}
+	expectOAuthError(t, o.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}), "invalid_grant")
+
+	// Revoking the refresh token revokes its access token too
+	decode(t, o.post("/api/oauth/revoke", url.Values{"token": {refreshed.RefreshToken}}), http.StatusOK, nil)
+	if status := o.api(http.MethodGet, "/api/tasks", refreshed.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("revoked access token = %d, want %d", status, http.StatusUnauthorized)
+	}
+	expectOAuthError(t, o.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed.RefreshToken}}), "invalid_grant")
+}
+
+func TestOAuthCodeReplay(t *testing.T) {
+	o := newOAuthTest(t)
+	verifier, challenge := pkce(t)
+	code := o.authorize("tasks:read", challenge)
+
+	var tokens oauthTokenResponse
+	decode(t, o.exchange(code, testRedirectURI, verifier), http.StatusOK, &tokens)
+	var refreshed oauthTokenResponse
+	decode(t, o.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}), http.StatusOK, &refreshed)
+
+	// Someone else has the code, so the tokens it was exchanged for, and the
+	// ones they were refreshed into, can't be trusted any more
+	expectOAuthError(t, o.exchange(code, testRedirectURI, verifier), "invalid_grant")
+	if status := o.api(http.MethodGet, "/api/tasks", refreshed.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("access token after the code was replayed = %d, want %d", status, http.StatusUnauthorized)
+	}
+	expectOAuthError(t, o.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed.RefreshToken}}), "invalid_grant")
+}
+
+func TestOAuthWrongVerifier(t *testing.T) {
+	o := newOAuthTest(t)
+	_, challenge := pkce(t)
+	other, _ := pkce(t)
+	code := o.authorize("tasks:read", challenge)
+
+	expectOAuthError(t, o.exchange(code, testRedirectURI, other), "invalid_grant")
+	expectOAuthError(t, o.exchange(code, testRedirectURI, ""), "invalid_grant")
+}
+
+func TestOAuthWrongRedirectURI(t *testing.T) {
+	o := newOAuthTest(t)
+	verifier, challenge := pkce(t)
+
+	// The user is never sent to a redirect URI the client didn't register
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {string(o.clientID)},
+		"redirect_uri":          {"https://evil.example.com/callback"},
+		"scope":                 {"tasks:read"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	var problem problemDocument
+	decode(t, o.user.do(http.MethodPost, "/api/oauth/authorize?"+q.Encode(), &authorizeDecision{Approve: true}), http.StatusBadRequest, &problem)
+	if problem.Code != "redirect_uri_invalid" {
+		t.Errorf("code = %q, want redirect_uri_invalid", problem.Code)
+	}
+
+	// The code only works with the redirect URI it was sent to, even one
+	// the client has registered
+	code := o.authorize("tasks:read", challenge)
+	expectOAuthError(t, o.exchange(code, otherRedirectURI, verifier), "invalid_grant")
+	expectOAuthError(t, o.exchange(code, "", verifier), "invalid_grant")
+}
+
+func TestOAuthDefaultRedirectURI(t *testing.T) {
+	// A client with one redirect URI can leave it out of the authorization
+	// request, and then out of the token request too
+	o := newOAuthTest(t, testRedirectURI)
+	o.omitRedirect = true
+	verifier, challenge := pkce(t)
+	decode(t, o.exchange(o.authorize("tasks:read", challenge), "", verifier), http.StatusOK, nil)
+	decode(t, o.exchange(o.authorize("tasks:read", challenge), testRedirectURI, verifier), http.StatusOK, nil)
+	expectOAuthError(t, o.exchange(o.authorize("tasks:read", challenge), otherRedirectURI, verifier), "invalid_grant")
+
+	// Once it's been sent it has to be sent again
+	o.omitRedirect = false
+	expectOAuthError(t, o.exchange(o.authorize("tasks:read", challenge), "", verifier), "invalid_grant")
+}
+
+func TestOAuthClientAuth(t *testing.T) {
+	o := newOAuthTest(t)
+	verifier, challenge := pkce(t)
+	code := o.authorize("tasks:read", challenge)
+
+	o.secret = oauthSecretPrefix + "wrong"
+	resp := o.exchange(code, testRedirectURI, verifier)
+	var e oauthErrorResponse
+	decode(t, resp, http.StatusUnauthorized, &e)
+	if e.Error != "invalid_client" || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("error = %+v, WWW-Authenticate = %q", e, resp.Header.Get("WWW-Authenticate"))
+	}
+}
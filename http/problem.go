@@ -0,0 +1,281 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// problemType is how an error is reported: its status code, and a code
+// clients can rely on even if the message changes.
+type problemType struct {
+	status int
+	code   string
+}
+
+// problemError is the status code and stable code an error is reported
+// with.
+type problemError struct {
+	err    error
+	status int
+	code   string
+}
+
+// problemErrors lists the domain errors with their status code and stable
+// code. It's a list rather than a map because wrapped errors are matched
+// with errors.Is, and not every error can be a map key.
+var problemErrors = []problemError{
+	// General errors
+	{todo.ErrInternal, http.StatusInternalServerError, "internal"},
+	{todo.ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{todo.ErrPermissionDenied, http.StatusForbidden, "permission_denied"},
+	{todo.ErrNotFound, http.StatusNotFound, "not_found"},
+	{sql.ErrNoRows, http.StatusNotFound, "not_found"},
+
+	// http errors
+	{todo.ErrInvalidJSON, http.StatusBadRequest, "invalid_json"},
+	{todo.ErrMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed"},
+	{todo.ErrMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{todo.ErrJSONPatchInvalid, http.StatusBadRequest, "json_patch_invalid"},
+	{todo.ErrJSONPatchTest, http.StatusConflict, "json_patch_test_failed"},
+	{todo.ErrRequestTooLarge, http.StatusRequestEntityTooLarge, "request_too_large"},
+
+	// Idempotency errors
+	{todo.ErrIdempotencyKeyInvalid, http.StatusBadRequest, "idempotency_key_invalid"},
+	{todo.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{todo.ErrIdempotencyKeyInProgress, http.StatusConflict, "idempotency_key_in_progress"},
+
+	// Socket errors
+	{todo.ErrUnknownMessageType, http.StatusBadRequest, "unknown_message_type"},
+
+	// Task errors
+	{todo.ErrTaskContentRequired, http.StatusBadRequest, "task_content_required"},
+	{todo.ErrTaskIDRequired, http.StatusBadRequest, "task_id_required"},
+	{todo.ErrTaskNotFound, http.StatusNotFound, "task_not_found"},
+	{todo.ErrCompletedBoolRequired, http.StatusBadRequest, "completed_required"},
+	{todo.ErrAssigneeNotMember, http.StatusBadRequest, "assignee_not_member"},
+	{todo.ErrDueDateInvalid, http.StatusBadRequest, "due_date_invalid"},
+	{todo.ErrDueDateRequired, http.StatusBadRequest, "due_date_required"},
+	{todo.ErrRecurrenceInvalid, http.StatusBadRequest, "recurrence_invalid"},
+	{todo.ErrTaskFieldUnknown, http.StatusBadRequest, "task_field_unknown"},
+	{todo.ErrTaskFieldReadOnly, http.StatusBadRequest, "task_field_read_only"},
+	{todo.ErrBatchEmpty, http.StatusBadRequest, "batch_empty"},
+	{todo.ErrBatchTooLarge, http.StatusRequestEntityTooLarge, "batch_too_large"},
+	{todo.ErrBatchOpInvalid, http.StatusBadRequest, "batch_op_invalid"},
+	{todo.ErrBatchModeUnknown, http.StatusBadRequest, "batch_mode_unknown"},
+	{todo.ErrBatchRolledBack, http.StatusFailedDependency, "batch_rolled_back"},
+
+	// Reminder errors
+	{todo.ErrReminderIDRequired, http.StatusBadRequest, "reminder_id_required"},
+	{todo.ErrReminderNotFound, http.StatusNotFound, "reminder_not_found"},
+	{todo.ErrReminderInvalid, http.StatusBadRequest, "reminder_invalid"},
+	{todo.ErrReminderTimeInvalid, http.StatusBadRequest, "reminder_time_invalid"},
+	{todo.ErrReminderChannelUnknown, http.StatusBadRequest, "reminder_channel_unknown"},
+	{todo.ErrTimezoneInvalid, http.StatusBadRequest, "timezone_invalid"},
+
+	// Comment errors
+	{todo.ErrCommentIDRequired, http.StatusBadRequest, "comment_id_required"},
+	{todo.ErrCommentBodyRequired, http.StatusBadRequest, "comment_body_required"},
+	{todo.ErrCommentTooLong, http.StatusBadRequest, "comment_too_long"},
+	{todo.ErrCommentNotFound, http.StatusNotFound, "comment_not_found"},
+
+	// Project errors
+	{todo.ErrProjectIDRequired, http.StatusBadRequest, "project_id_required"},
+	{todo.ErrProjectNameRequired, http.StatusBadRequest, "project_name_required"},
+	{todo.ErrProjectNotFound, http.StatusNotFound, "project_not_found"},
+	{todo.ErrRoleInvalid, http.StatusBadRequest, "role_invalid"},
+	{todo.ErrMemberNotFound, http.StatusNotFound, "member_not_found"},
+	{todo.ErrAlreadyMember, http.StatusConflict, "already_member"},
+	{todo.ErrInvitationIDRequired, http.StatusBadRequest, "invitation_id_required"},
+	{todo.ErrInvitationNotFound, http.StatusNotFound, "invitation_not_found"},
+	{todo.ErrInvitationExists, http.StatusConflict, "invitation_exists"},
+	{todo.ErrOwnerCannotLeave, http.StatusConflict, "owner_cannot_leave"},
+	{todo.ErrCannotChangeOwnRole, http.StatusConflict, "cannot_change_own_role"},
+
+	// User errors
+	{todo.ErrEmailRequired, http.StatusBadRequest, "email_required"},
+	{todo.ErrPasswordRequired, http.StatusBadRequest, "password_required"},
+	{todo.ErrSessionRequired, http.StatusBadRequest, "session_required"},
+	{todo.ErrSessionIDRequired, http.StatusBadRequest, "session_id_required"},
+	{todo.ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+	{todo.ErrCSRFTokenInvalid, http.StatusForbidden, "csrf_token_invalid"},
+	{todo.ErrExpiryTimeRequired, http.StatusBadRequest, "expiry_time_required"},
+	{todo.ErrUserIDRequired, http.StatusBadRequest, "user_id_required"},
+	{todo.ErrUsernameExists, http.StatusConflict, "username_exists"},
+	{todo.ErrEmailExists, http.StatusConflict, "email_exists"},
+	{todo.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{todo.ErrResetTokenRequired, http.StatusBadRequest, "reset_token_required"},
+	{todo.ErrResetTokenInvalid, http.StatusBadRequest, "reset_token_invalid"},
+	{todo.ErrEmailInvalid, http.StatusBadRequest, "email_invalid"},
+	{todo.ErrEmailNotVerified, http.StatusForbidden, "email_not_verified"},
+	{todo.ErrAlreadyVerified, http.StatusConflict, "already_verified"},
+	{todo.ErrVerificationInvalid, http.StatusBadRequest, "verification_invalid"},
+	{todo.ErrUserUpdateRequired, http.StatusBadRequest, "user_update_required"},
+	{todo.ErrPasswordIncorrect, http.StatusForbidden, "password_incorrect"},
+
+	// API token errors
+	{todo.ErrAPITokenIDRequired, http.StatusBadRequest, "api_token_id_required"},
+	{todo.ErrAPITokenNotFound, http.StatusNotFound, "api_token_not_found"},
+	{todo.ErrAPITokenNameRequired, http.StatusBadRequest, "api_token_name_required"},
+	{todo.ErrAPITokenExpiry, http.StatusBadRequest, "api_token_expiry_invalid"},
+	{todo.ErrScopesRequired, http.StatusBadRequest, "scopes_required"},
+	{todo.ErrScopeUnknown, http.StatusBadRequest, "scope_unknown"},
+	{todo.ErrScopeDenied, http.StatusForbidden, "scope_denied"},
+
+	// JWT errors
+	{todo.ErrJWTDisabled, http.StatusBadRequest, "jwt_disabled"},
+	{todo.ErrLoginModeUnknown, http.StatusBadRequest, "login_mode_unknown"},
+	{todo.ErrRefreshTokenRequired, http.StatusBadRequest, "refresh_token_required"},
+	{todo.ErrRefreshTokenInvalid, http.StatusUnauthorized, "refresh_token_invalid"},
+	{todo.ErrRefreshTokenReused, http.StatusUnauthorized, "refresh_token_reused"},
+
+	// OAuth errors
+	{todo.ErrOAuthClientIDRequired, http.StatusBadRequest, "oauth_client_id_required"},
+	{todo.ErrOAuthClientNotFound, http.StatusNotFound, "oauth_client_not_found"},
+	{todo.ErrOAuthClientNameRequired, http.StatusBadRequest, "oauth_client_name_required"},
+	{todo.ErrOAuthClientAuthFailed, http.StatusUnauthorized, "oauth_client_auth_failed"},
+	{todo.ErrRedirectURIInvalid, http.StatusBadRequest, "redirect_uri_invalid"},
+	{todo.ErrRedirectURIsRequired, http.StatusBadRequest, "redirect_uris_required"},
+	{todo.ErrPKCERequired, http.StatusBadRequest, "pkce_required"},
+	{todo.ErrOAuthGrantInvalid, http.StatusBadRequest, "oauth_grant_invalid"},
+
+	// Login errors
+	{todo.ErrLoginFailed, http.StatusUnauthorized, "login_failed"},
+	{todo.ErrLoginLocked, http.StatusTooManyRequests, "login_locked"},
+	{todo.ErrLockoutKind, http.StatusBadRequest, "lockout_kind_invalid"},
+	{todo.ErrLockoutNotFound, http.StatusNotFound, "lockout_not_found"},
+	{todo.ErrAdminRequired, http.StatusForbidden, "admin_required"},
+
+	// Audit errors
+	{todo.ErrAuditCursorInvalid, http.StatusBadRequest, "audit_cursor_invalid"},
+
+	// MFA errors
+	{todo.ErrMFACodeRequired, http.StatusBadRequest, "mfa_code_required"},
+	{todo.ErrMFACodeInvalid, http.StatusForbidden, "mfa_code_invalid"},
+	{todo.ErrMFANotEnrolled, http.StatusBadRequest, "mfa_not_enrolled"},
+	{todo.ErrMFAAlreadyEnabled, http.StatusConflict, "mfa_already_enabled"},
+	{todo.ErrMFANotEnabled, http.StatusConflict, "mfa_not_enabled"},
+	{todo.ErrMFAChallengeRequired, http.StatusBadRequest, "mfa_challenge_required"},
+	{todo.ErrMFAChallengeInvalid, http.StatusUnauthorized, "mfa_challenge_invalid"},
+
+	// Notification errors
+	{todo.ErrNotificationIDRequired, http.StatusBadRequest, "notification_id_required"},
+	{todo.ErrNotificationNotFound, http.StatusNotFound, "notification_not_found"},
+	{todo.ErrNotificationTypeUnknown, http.StatusBadRequest, "notification_type_unknown"},
+
+	// Webhook errors
+	{todo.ErrWebhookURLInvalid, http.StatusBadRequest, "webhook_url_invalid"},
+	{todo.ErrWebhookEventsRequired, http.StatusBadRequest, "webhook_events_required"},
+	{todo.ErrWebhookEventUnknown, http.StatusBadRequest, "webhook_event_unknown"},
+	{todo.ErrWebhookSecretRequired, http.StatusBadRequest, "webhook_secret_required"},
+	{todo.ErrWebhookIDRequired, http.StatusBadRequest, "webhook_id_required"},
+	{todo.ErrWebhookNotFound, http.StatusNotFound, "webhook_not_found"},
+	{todo.ErrWebhookAddressBlocked, http.StatusBadRequest, "webhook_address_blocked"},
+}
+
+// validationType is reported for a ValidationError, the problems with each
+// field are listed in the document.
+var validationType = problemType{http.StatusBadRequest, "validation_failed"}
+
+// lookupProblem returns the type of err, looking through wrapped errors.
+// Errors it doesn't know are internal.
+func lookupProblem(err error) (problemType, bool) {
+	var validation todo.ValidationError
+	if errors.As(err, &validation) {
+		return validationType, true
+	}
+	for _, p := range problemErrors {
+		if errors.Is(err, p.err) {
+			return problemType{p.status, p.code}, true
+		}
+	}
+	return problemType{http.StatusInternalServerError, "internal"}, false
+}
+
+// problemStatus returns the status code err is reported with.
+func problemStatus(err error) int {
+	t, _ := lookupProblem(err)
+	return t.status
+}
+
+// problemDocument is an RFC 7807 application/problem+json document. The
+// type is always about:blank, code says what the problem is.
+type problemDocument struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Code      string         `json:"code"`
+	RequestID string         `json:"requestId,omitempty"`
+	Errors    []fieldProblem `json:"errors,omitempty"`
+	// Err repeats the detail for clients that read the old error responses.
+	Err string `json:"err,omitempty"`
+}
+
+// fieldProblem is what's wrong with one field of a request.
+type fieldProblem struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// Problem writes err as a problem document with the status code it maps
+// to. Errors without one are internal errors.
+func Problem(w http.ResponseWriter, err error, logger *log.Logger) {
+	requestID := w.Header().Get(requestIDHeader)
+	doc := newProblem(err, requestID)
+	logger.Printf("http error: %s (code=%d, request=%s)", err, doc.Status, requestID)
+	writeProblem(w, doc)
+}
+
+// newProblem returns the problem document for err. Internal errors are
+// hidden from the client.
+func newProblem(err error, requestID string) *problemDocument {
+	t, _ := lookupProblem(err)
+	code := t.status
+	if code == http.StatusInternalServerError {
+		t.code = "internal"
+		err = todo.ErrInternal
+	} else if errors.Is(err, sql.ErrNoRows) {
+		// The database's message means nothing to the client
+		err = todo.ErrNotFound
+	}
+	doc := &problemDocument{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    err.Error(),
+		Code:      t.code,
+		RequestID: requestID,
+		Err:       err.Error(),
+	}
+	var validation todo.ValidationError
+	if errors.As(err, &validation) {
+		for _, field := range validation {
+			t, _ := lookupProblem(field.Err)
+			doc.Errors = append(doc.Errors, fieldProblem{Field: field.Field, Code: t.code, Detail: field.Err.Error()})
+		}
+	}
+	return doc
+}
+
+// NotFound writes a problem document for a url no route matches.
+func NotFound(w http.ResponseWriter) {
+	writeProblem(w, &problemDocument{
+		Type:      "about:blank",
+		Title:     http.StatusText(http.StatusNotFound),
+		Status:    http.StatusNotFound,
+		Code:      "not_found",
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+func writeProblem(w http.ResponseWriter, doc *problemDocument) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(doc.Status)
+	json.NewEncoder(w).Encode(doc)
+}
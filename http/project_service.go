@@ -0,0 +1,192 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type ProjectHandler struct {
+	*router
+	ProjectService todo.ProjectService
+	Logger         *log.Logger
+}
+
+func NewProjectHandler() *ProjectHandler {
+	h := &ProjectHandler{
+		router: newScopedRouter("projects"),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/projects", h.handleProjects)
+	h.POST("/api/projects/create", h.handleCreateProject)
+	h.GET("/api/projects/members/:id", h.handleMembers)
+	h.POST("/api/projects/invite", h.handleInvite)
+	h.GET("/api/projects/invitations", h.handleInvitations)
+	h.POST("/api/projects/invitations/accept/:id", h.handleAcceptInvitation)
+	h.POST("/api/projects/invitations/decline/:id", h.handleDeclineInvitation)
+	h.POST("/api/projects/role", h.handleChangeRole)
+	h.POST("/api/projects/leave/:id", h.handleLeave)
+	h.POST("/api/projects/transfer", h.handleTransfer)
+	return h
+}
+
+type getProjectsResponse struct {
+	Projects *todo.Projects `json:"projects"`
+}
+
+func (h *ProjectHandler) handleProjects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	projects, err := h.ProjectService.Projects(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getProjectsResponse{Projects: projects}, h.Logger)
+}
+
+type createProjectRequest struct {
+	Name string `json:"name"`
+}
+
+type createProjectResponse struct {
+	ID todo.ProjectID `json:"id"`
+}
+
+func (h *ProjectHandler) handleCreateProject(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	id, err := h.ProjectService.CreateProject(req.Name, todo.UserID(r.Header.Get("userID")))
+	switch err {
+	case nil:
+		encodeJSON(w, &createProjectResponse{ID: id}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type getMembersResponse struct {
+	Members *todo.Members `json:"members"`
+}
+
+func (h *ProjectHandler) handleMembers(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	members, err := h.ProjectService.Members(todo.ProjectID(p.ByName("id")), todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getMembersResponse{Members: members}, h.Logger)
+}
+
+type inviteRequest struct {
+	ProjectID todo.ProjectID `json:"projectId"`
+	Email     todo.Email     `json:"email"`
+	Role      todo.Role      `json:"role"`
+}
+
+type inviteResponse struct {
+	ID todo.InvitationID `json:"id"`
+}
+
+func (h *ProjectHandler) handleInvite(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	email, err := todo.NormalizeEmail(req.Email)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	id, err := h.ProjectService.InviteMember(req.ProjectID, email, req.Role, todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &inviteResponse{ID: id}, h.Logger)
+}
+
+type getInvitationsResponse struct {
+	Invitations *todo.Invitations `json:"invitations"`
+}
+
+func (h *ProjectHandler) handleInvitations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	invitations, err := h.ProjectService.Invitations(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getInvitationsResponse{Invitations: invitations}, h.Logger)
+}
+
+func (h *ProjectHandler) handleAcceptInvitation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.ProjectService.AcceptInvitation(todo.InvitationID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Invitation accepted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *ProjectHandler) handleDeclineInvitation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.ProjectService.DeclineInvitation(todo.InvitationID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Invitation declined"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type changeRoleRequest struct {
+	ProjectID todo.ProjectID `json:"projectId"`
+	UserID    todo.UserID    `json:"userId"`
+	Role      todo.Role      `json:"role"`
+}
+
+func (h *ProjectHandler) handleChangeRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req changeRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.ProjectService.ChangeRole(req.ProjectID, req.UserID, req.Role, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Role has been changed"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *ProjectHandler) handleLeave(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.ProjectService.LeaveProject(todo.ProjectID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Left project"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type transferRequest struct {
+	ProjectID todo.ProjectID `json:"projectId"`
+	UserID    todo.UserID    `json:"userId"`
+}
+
+func (h *ProjectHandler) handleTransfer(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.ProjectService.TransferOwnership(req.ProjectID, req.UserID, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Ownership has been transferred"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
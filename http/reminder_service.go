@@ -0,0 +1,84 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type ReminderHandler struct {
+	*router
+	ReminderService todo.ReminderService
+	Logger          *log.Logger
+}
+
+func NewReminderHandler() *ReminderHandler {
+	h := &ReminderHandler{
+		router: newScopedRouter("tasks"),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/reminders", h.handleReminders)
+	h.POST("/api/reminders/create", h.handleCreateReminder)
+	h.DELETE("/api/reminders/delete/:id", h.handleDeleteReminder)
+	return h
+}
+
+type getRemindersResponse struct {
+	Reminders *todo.Reminders `json:"reminders"`
+}
+
+// handleReminders returns the user's reminders on the task given in the
+// task query parameter.
+func (h *ReminderHandler) handleReminders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	reminders, err := h.ReminderService.Reminders(todo.TaskID(r.URL.Query().Get("task")), todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getRemindersResponse{Reminders: reminders}, h.Logger)
+}
+
+type createReminderRequest struct {
+	TaskID        todo.TaskID `json:"taskId"`
+	MinutesBefore int         `json:"minutesBefore"`
+	At            string      `json:"at"`
+	Timezone      string      `json:"timezone"`
+	Channels      []string    `json:"channels"`
+}
+
+type createReminderResponse struct {
+	ID todo.ReminderID `json:"id"`
+}
+
+func (h *ReminderHandler) handleCreateReminder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createReminderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	reminder := &todo.Reminder{
+		MinutesBefore: req.MinutesBefore,
+		At:            req.At,
+		Timezone:      req.Timezone,
+		Channels:      req.Channels,
+	}
+	id, err := h.ReminderService.CreateReminder(req.TaskID, reminder, todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &createReminderResponse{ID: id}, h.Logger)
+}
+
+func (h *ReminderHandler) handleDeleteReminder(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.ReminderService.DeleteReminder(todo.ReminderID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Reminder has been deleted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// route is a route as it was added, so it can be added to another router.
+type route struct {
+	method string
+	path   string
+	handle httprouter.Handle
+	public bool
+	// secret routes respond with credentials, which mustn't be stored to
+	// replay the response.
+	secret bool
+	// resource is what API and OAuth tokens need a scope for to use the
+	// route, e.g. "tasks" for tasks:read and tasks:write. Tokens can't use
+	// routes without one.
+	resource string
+}
+
+// router is an httprouter.Router whose routes declare if they can be called
+// without logging in, and which scopes tokens need for them. Routes added
+// with GET, POST and the rest need a logged in user, routes added with
+// Public don't.
+type router struct {
+	*httprouter.Router
+	// resource is given to every route added to the router.
+	resource string
+	routes   []route
+}
+
+func newRouter() *router {
+	return &router{Router: httprouter.New()}
+}
+
+// newScopedRouter returns a router for routes API and OAuth tokens with a
+// scope for the resource can use.
+func newScopedRouter(resource string) *router {
+	r := newRouter()
+	r.resource = resource
+	return r
+}
+
+func (r *router) GET(path string, handle httprouter.Handle) {
+	r.add(route{method: http.MethodGet, path: path, handle: handle})
+}
+
+func (r *router) POST(path string, handle httprouter.Handle) {
+	r.add(route{method: http.MethodPost, path: path, handle: handle})
+}
+
+func (r *router) PUT(path string, handle httprouter.Handle) {
+	r.add(route{method: http.MethodPut, path: path, handle: handle})
+}
+
+func (r *router) PATCH(path string, handle httprouter.Handle) {
+	r.add(route{method: http.MethodPatch, path: path, handle: handle})
+}
+
+func (r *router) DELETE(path string, handle httprouter.Handle) {
+	r.add(route{method: http.MethodDelete, path: path, handle: handle})
+}
+
+// Public adds a route anyone can call.
+func (r *router) Public(method, path string, handle httprouter.Handle) {
+	r.add(route{method: method, path: path, handle: handle, public: true})
+}
+
+// Secret adds a route whose response has a secret that's only shown once,
+// such as a new token.
+func (r *router) Secret(method, path string, handle httprouter.Handle) {
+	r.add(route{method: method, path: path, handle: handle, secret: true})
+}
+
+func (r *router) add(rt route) {
+	if rt.resource == "" {
+		rt.resource = r.resource
+	}
+	r.Handle(rt.method, rt.path, rt.handle)
+	r.routes = append(r.routes, rt)
+}
+
+// scope returns the scope a token needs for a request to the route, or an
+// empty string if tokens can't use it.
+func (rt *route) scope() string {
+	if rt.resource == "" {
+		return ""
+	} else if rt.method == http.MethodGet {
+		return rt.resource + ":read"
+	}
+	return rt.resource + ":write"
+}
@@ -0,0 +1,263 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+const (
+	// Time allowed to write a message to the client.
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong from the client.
+	pongWait = 60 * time.Second
+	// Pings are sent on this period, it must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+	// Largest message accepted from a client.
+	maxMessageSize = 8192
+	// Messages queued for a client before it is treated as too slow.
+	sendBufferSize = 256
+)
+
+type SocketHandler struct {
+	*router
+	TaskService    todo.TaskService
+	ProjectService todo.ProjectService
+	Hub            *Hub
+	// CORS defaults to DefaultCORS.
+	CORS     *CORSConfig
+	Logger   *log.Logger
+	upgrader websocket.Upgrader
+}
+
+func NewSocketHandler() *SocketHandler {
+	h := &SocketHandler{
+		router: newRouter(),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+	}
+	h.upgrader.CheckOrigin = h.checkOrigin
+	h.GET("/api/ws", h.handleSocket)
+	return h
+}
+
+// checkOrigin only lets browsers connect from origins the api allows for
+// CORS. Clients that don't send an origin (not browsers) are let through.
+func (h *SocketHandler) checkOrigin(r *http.Request) bool {
+	cors := h.CORS
+	if cors == nil {
+		cors = DefaultCORS
+	}
+	origin := r.Header.Get("Origin")
+	return origin == "" || cors.Allowed(origin)
+}
+
+// client is a single socket connection.
+type client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID todo.UserID
+	// The project the client is viewing, guarded by hub.mu.
+	room todo.ProjectID
+	send chan []byte
+}
+
+type socketRequest struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type socketResponse struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	OK   bool   `json:"ok"`
+	Err  string `json:"err,omitempty"`
+}
+
+func (h *SocketHandler) handleSocket(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		h.Logger.Printf("socket error: %s", err)
+		return
+	}
+	c := &client{
+		hub:    h.Hub,
+		conn:   conn,
+		userID: todo.UserID(r.Header.Get("userID")),
+		send:   make(chan []byte, sendBufferSize),
+	}
+	h.Hub.join(c)
+	go c.writePump()
+	h.readPump(c)
+}
+
+// readPump reads messages from the client until the connection fails or
+// the client stops answering pings.
+func (h *SocketHandler) readPump(c *client) {
+	defer func() {
+		c.hub.leave(c)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		var req socketRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.Logger.Printf("socket error: %s", err)
+			}
+			return
+		}
+		if req.Type == "ping" {
+			c.conn.SetReadDeadline(time.Now().Add(pongWait))
+			h.reply(c, &socketResponse{Type: "pong", ID: req.ID, OK: true})
+			continue
+		}
+		err := h.mutate(c, &req)
+		resp := &socketResponse{Type: "ack", ID: req.ID, OK: err == nil}
+		if err != nil {
+			h.Logger.Printf("socket error: %s (type=%s)", err, req.Type)
+			resp.Err = socketError(err).Error()
+		}
+		h.reply(c, resp)
+	}
+}
+
+// writePump is the only goroutine that writes to the connection.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h *SocketHandler) reply(c *client, resp *socketResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		h.Logger.Printf("socket error: %s", err)
+		return
+	}
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	if !c.hub.users[c.userID][c] {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		h.Logger.Printf("socket: dropping reply to slow client %s", c.userID)
+	}
+}
+
+type viewRequest struct {
+	ProjectID todo.ProjectID `json:"projectId"`
+}
+
+// mutate applies a message sent over the socket. The events caused by task
+// mutations reach clients like those of any other change.
+func (h *SocketHandler) mutate(c *client, req *socketRequest) error {
+	switch req.Type {
+	case "view":
+		var data viewRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		if data.ProjectID != "" {
+			if _, err := h.ProjectService.Role(data.ProjectID, c.userID); err != nil {
+				return err
+			}
+		}
+		h.Hub.view(c, data.ProjectID)
+		return nil
+	case "task.create":
+		var data createTaskRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.CreateTask(data.ID, data.Content, c.userID, data.ProjectID)
+	case "task.edit":
+		var data editTaskRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.EditTask(data.ID, data.Content, c.userID)
+	case "task.toggle":
+		var data taskStatusRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.EditTaskStatus(data.ID, data.Val, c.userID)
+	case "task.toggleAll":
+		var data toggleAllRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.ToggleAll(data.Val, c.userID, data.ProjectID)
+	case "task.assign":
+		var data assignTaskRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.AssignTask(data.ID, data.AssigneeID, c.userID)
+	case "task.delete":
+		var data deleteTaskRequest
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return todo.ErrInvalidJSON
+		}
+		return h.TaskService.DeleteTask(data.ID, c.userID)
+	case "task.clearCompleted":
+		var data viewRequest
+		if len(req.Data) > 0 {
+			if err := json.Unmarshal(req.Data, &data); err != nil {
+				return todo.ErrInvalidJSON
+			}
+		}
+		return h.TaskService.ClearCompleted(c.userID, data.ProjectID)
+	default:
+		return todo.ErrUnknownMessageType
+	}
+}
+
+// socketError hides internal errors from the client in the same way Error
+// does for http responses.
+func socketError(err error) error {
+	if problemStatus(err) == http.StatusInternalServerError {
+		return todo.ErrInternal
+	}
+	return err
+}
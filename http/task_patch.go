@@ -0,0 +1,273 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// Media types PATCH /api/tasks/:id accepts. Plain JSON is read as a merge
+// patch.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// readOnlyTaskFields are in the task's JSON but can't be patched.
+var readOnlyTaskFields = []string{"id", "projectId", "commentCount", "timestamp"}
+
+// handlePatchTask changes any of the task's content, status, assignee, due
+// date and recurrence at once, and returns the updated task. The body is an
+// RFC 7396 merge patch, or an RFC 6902 JSON Patch sent as
+// application/json-patch+json.
+func (h *TaskHandler) handlePatchTask(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := todo.TaskID(p.ByName("id"))
+	userID := todo.UserID(r.Header.Get("userID"))
+	mediaType := mergePatchType
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+	var task *todo.Task
+	var err error
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var patch *todo.TaskPatch
+		if patch, err = decodeMergePatch(r); err == nil {
+			task, err = h.TaskService.PatchTask(id, patch, userID)
+		}
+	case jsonPatchType:
+		var ops []jsonPatchOp
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			Problem(w, todo.ErrInvalidJSON, h.Logger)
+			return
+		}
+		// The operations are applied to the task as it is when it's
+		// changed, a test op can't pass against a task that's since moved on
+		task, err = h.TaskService.PatchTaskFunc(id, func(current *todo.Task) (*todo.TaskPatch, error) {
+			return applyJSONPatch(ops, current)
+		}, userID)
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		Problem(w, todo.ErrMediaType, h.Logger)
+		return
+	}
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, task, h.Logger)
+}
+
+// decodeMergePatch reads a merge patch. Tasks are flat, so the patch just
+// lists the fields to change and null clears a field.
+func decodeMergePatch(r *http.Request) (*todo.TaskPatch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || fields == nil {
+		return nil, todo.ErrInvalidJSON
+	}
+	return taskMergePatch(fields)
+}
+
+// taskMergePatch returns the patch that makes the changes in the merge
+// patch's fields.
+func taskMergePatch(fields map[string]json.RawMessage) (*todo.TaskPatch, error) {
+	patch := &todo.TaskPatch{}
+	var errs []*todo.FieldError
+	for _, field := range sortedFields(fields) {
+		if err := setTaskField(patch, field, fields[field]); err != nil {
+			errs = append(errs, &todo.FieldError{Field: field, Err: err})
+		}
+	}
+	if err := todo.Validation(errs); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// setTaskField sets one field of the patch from its JSON value.
+func setTaskField(patch *todo.TaskPatch, field string, value json.RawMessage) error {
+	for _, readOnly := range readOnlyTaskFields {
+		if field == readOnly {
+			return todo.ErrTaskFieldReadOnly
+		}
+	}
+	null := bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+	var err error
+	switch field {
+	case "content":
+		if null {
+			return todo.ErrTaskContentRequired
+		}
+		patch.Content = new(todo.TaskContent)
+		err = json.Unmarshal(value, patch.Content)
+	case "completed":
+		if null {
+			return todo.ErrCompletedBoolRequired
+		}
+		patch.Completed = new(bool)
+		err = json.Unmarshal(value, patch.Completed)
+	case "assigneeId":
+		patch.AssigneeID = new(todo.UserID)
+		err = json.Unmarshal(value, patch.AssigneeID)
+	case "dueAt":
+		patch.DueAt = new(string)
+		err = json.Unmarshal(value, patch.DueAt)
+	case "recurrence":
+		patch.Recurrence = new(todo.Recurrence)
+		err = json.Unmarshal(value, patch.Recurrence)
+	default:
+		return todo.ErrTaskFieldUnknown
+	}
+	if err != nil {
+		return todo.ErrInvalidJSON
+	}
+	return nil
+}
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies a JSON Patch's operations to the task, and returns
+// the fields they changed. Every field of the task is there to patch, so
+// optional fields can be replaced even when they're empty, and removing one
+// clears it.
+func applyJSONPatch(ops []jsonPatchOp, task *todo.Task) (*todo.TaskPatch, error) {
+	doc, err := taskDocument(task)
+	if err != nil {
+		return nil, err
+	}
+	before, err := taskDocument(task)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if err := applyJSONPatchOp(doc, &op); err != nil {
+			return nil, err
+		}
+	}
+	patch := &todo.TaskPatch{}
+	var errs []*todo.FieldError
+	for _, field := range sortedFields(before) {
+		after, ok := doc[field]
+		if ok && jsonEqual(after, before[field]) {
+			continue
+		} else if !ok {
+			after = json.RawMessage("null")
+		}
+		if err := setTaskField(patch, field, after); err != nil {
+			errs = append(errs, &todo.FieldError{Field: field, Err: err})
+		}
+	}
+	for _, field := range sortedFields(doc) {
+		if _, ok := before[field]; !ok {
+			errs = append(errs, &todo.FieldError{Field: field, Err: todo.ErrTaskFieldUnknown})
+		}
+	}
+	if err := todo.Validation(errs); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// taskDocument returns the task's fields as JSON values, including the
+// empty ones.
+func taskDocument(task *todo.Task) (map[string]json.RawMessage, error) {
+	doc := map[string]json.RawMessage{}
+	for field, value := range map[string]interface{}{
+		"id":           task.ID,
+		"projectId":    task.ProjectID,
+		"assigneeId":   task.AssigneeID,
+		"content":      task.Content,
+		"completed":    task.Completed,
+		"commentCount": task.CommentCount,
+		"dueAt":        task.DueAt,
+		"recurrence":   task.Recurrence,
+		"timestamp":    task.Timestamp,
+	} {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		doc[field] = b
+	}
+	return doc, nil
+}
+
+func applyJSONPatchOp(doc map[string]json.RawMessage, op *jsonPatchOp) error {
+	field, err := patchPointer(op.Path)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add", "replace":
+		if op.Value == nil {
+			return todo.ErrJSONPatchInvalid
+		} else if _, ok := doc[field]; !ok && op.Op == "replace" {
+			return todo.ErrJSONPatchInvalid
+		}
+		doc[field] = op.Value
+	case "remove":
+		if _, ok := doc[field]; !ok {
+			return todo.ErrJSONPatchInvalid
+		}
+		delete(doc, field)
+	case "move", "copy":
+		from, err := patchPointer(op.From)
+		if err != nil {
+			return err
+		}
+		value, ok := doc[from]
+		if !ok {
+			return todo.ErrJSONPatchInvalid
+		}
+		if op.Op == "move" {
+			delete(doc, from)
+		}
+		doc[field] = value
+	case "test":
+		value, ok := doc[field]
+		if !ok || !jsonEqual(value, op.Value) {
+			return todo.ErrJSONPatchTest
+		}
+	default:
+		return todo.ErrJSONPatchInvalid
+	}
+	return nil
+}
+
+// patchPointer returns the field a JSON pointer names. Tasks are flat, so
+// only pointers to top level fields are valid.
+func patchPointer(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Contains(pointer[1:], "/") {
+		return "", todo.ErrJSONPatchInvalid
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:]), nil
+}
+
+func sortedFields(fields map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+func TestPatchPointer(t *testing.T) {
+	for _, test := range []struct {
+		pointer string
+		field   string
+		err     error
+	}{
+		{"/content", "content", nil},
+		{"/", "", nil},
+		{"/a~1b", "a/b", nil},
+		{"/a~0b", "a~b", nil},
+		// ~01 is an escaped ~ followed by 1, not an escaped /
+		{"/~01", "~1", nil},
+		{"", "", todo.ErrJSONPatchInvalid},
+		{"content", "", todo.ErrJSONPatchInvalid},
+		{"/dueAt/0", "", todo.ErrJSONPatchInvalid},
+		{"/content/", "", todo.ErrJSONPatchInvalid},
+	} {
+		field, err := patchPointer(test.pointer)
+		if field != test.field || err != test.err {
+			t.Errorf("patchPointer(%q) = %q, %v, want %q, %v", test.pointer, field, err, test.field, test.err)
+		}
+	}
+}
+
+func TestApplyJSONPatchOp(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		op   string
+		doc  map[string]string
+		err  error
+	}{
+		{"add", `{"op":"add","path":"/assigneeId","value":"user"}`,
+			map[string]string{"content": `"a"`, "dueAt": `""`, "assigneeId": `"user"`}, nil},
+		{"add replaces", `{"op":"add","path":"/content","value":"b"}`,
+			map[string]string{"content": `"b"`, "dueAt": `""`}, nil},
+		{"add escaped", `{"op":"add","path":"/a~1b","value":1}`,
+			map[string]string{"content": `"a"`, "dueAt": `""`, "a/b": `1`}, nil},
+		{"add without value", `{"op":"add","path":"/content"}`, nil, todo.ErrJSONPatchInvalid},
+		{"replace", `{"op":"replace","path":"/content","value":"b"}`,
+			map[string]string{"content": `"b"`, "dueAt": `""`}, nil},
+		{"replace missing", `{"op":"replace","path":"/assigneeId","value":"user"}`, nil, todo.ErrJSONPatchInvalid},
+		{"remove", `{"op":"remove","path":"/dueAt"}`,
+			map[string]string{"content": `"a"`}, nil},
+		{"remove missing", `{"op":"remove","path":"/assigneeId"}`, nil, todo.ErrJSONPatchInvalid},
+		{"move", `{"op":"move","from":"/content","path":"/dueAt"}`,
+			map[string]string{"dueAt": `"a"`}, nil},
+		{"move missing", `{"op":"move","from":"/assigneeId","path":"/dueAt"}`, nil, todo.ErrJSONPatchInvalid},
+		{"move bad from", `{"op":"move","from":"content","path":"/dueAt"}`, nil, todo.ErrJSONPatchInvalid},
+		{"copy", `{"op":"copy","from":"/content","path":"/dueAt"}`,
+			map[string]string{"content": `"a"`, "dueAt": `"a"`}, nil},
+		{"test", `{"op":"test","path":"/content","value":"a"}`,
+			map[string]string{"content": `"a"`, "dueAt": `""`}, nil},
+		{"test fails", `{"op":"test","path":"/content","value":"b"}`, nil, todo.ErrJSONPatchTest},
+		{"test missing", `{"op":"test","path":"/assigneeId","value":""}`, nil, todo.ErrJSONPatchTest},
+		{"nested path", `{"op":"add","path":"/content/0","value":"b"}`, nil, todo.ErrJSONPatchInvalid},
+		{"unknown op", `{"op":"increment","path":"/content","value":1}`, nil, todo.ErrJSONPatchInvalid},
+	} {
+		doc := map[string]json.RawMessage{"content": json.RawMessage(`"a"`), "dueAt": json.RawMessage(`""`)}
+		var op jsonPatchOp
+		if err := json.Unmarshal([]byte(test.op), &op); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyJSONPatchOp(doc, &op); err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if len(doc) != len(test.doc) {
+			t.Errorf("%s: doc has %d fields, want %d", test.name, len(doc), len(test.doc))
+		}
+		for field, want := range test.doc {
+			if got, ok := doc[field]; !ok || !jsonEqual(got, json.RawMessage(want)) {
+				t.Errorf("%s: %s = %s, want %s", test.name, field, got, want)
+			}
+		}
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	task := &todo.Task{
+		ID:         "task",
+		AssigneeID: "user",
+		Content:    "old",
+		DueAt:      "2026-01-02T09:00:00Z",
+		Recurrence: todo.RecurrenceDaily,
+		Timestamp:  "2026-01-01T00:00:00Z",
+	}
+	for _, test := range []struct {
+		name  string
+		ops   string
+		check func(*todo.TaskPatch) bool
+	}{
+		{"replace", `[{"op":"test","path":"/content","value":"old"},{"op":"replace","path":"/content","value":"new"}]`,
+			func(p *todo.TaskPatch) bool {
+				return p.Content != nil && *p.Content == "new" && p.AssigneeID == nil && p.DueAt == nil && p.Completed == nil
+			}},
+		{"replace empty field", `[{"op":"replace","path":"/completed","value":true}]`,
+			func(p *todo.TaskPatch) bool { return p.Completed != nil && *p.Completed && p.Content == nil }},
+		{"remove clears", `[{"op":"remove","path":"/assigneeId"},{"op":"remove","path":"/recurrence"}]`,
+			func(p *todo.TaskPatch) bool {
+				return p.AssigneeID != nil && *p.AssigneeID == "" && p.Recurrence != nil && *p.Recurrence == todo.RecurrenceNone
+			}},
+		{"no change", `[{"op":"copy","from":"/content","path":"/content"},{"op":"replace","path":"/completed","value":false}]`,
+			func(p *todo.TaskPatch) bool { return *p == (todo.TaskPatch{}) }},
+	} {
+		var ops []jsonPatchOp
+		if err := json.Unmarshal([]byte(test.ops), &ops); err != nil {
+			t.Fatal(err)
+		}
+		patch, err := applyJSONPatch(ops, task)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.check(patch) {
+			t.Errorf("%s: patch = %+v", test.name, patch)
+		}
+	}
+	if task.Content != "old" || task.AssigneeID != "user" {
+		t.Errorf("task changed to %+v", task)
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	task := &todo.Task{ID: "task", Content: "old", Timestamp: "2026-01-01T00:00:00Z"}
+	for _, test := range []struct {
+		name   string
+		ops    string
+		fields map[string]error
+		err    error
+	}{
+		{"read only", `[{"op":"replace","path":"/id","value":"other"}]`,
+			map[string]error{"id": todo.ErrTaskFieldReadOnly}, nil},
+		{"remove read only", `[{"op":"remove","path":"/timestamp"}]`,
+			map[string]error{"timestamp": todo.ErrTaskFieldReadOnly}, nil},
+		{"unknown field", `[{"op":"add","path":"/colour","value":"red"}]`,
+			map[string]error{"colour": todo.ErrTaskFieldUnknown}, nil},
+		{"move to unknown field", `[{"op":"move","from":"/content","path":"/text"}]`,
+			map[string]error{"content": todo.ErrTaskContentRequired, "text": todo.ErrTaskFieldUnknown}, nil},
+		{"wrong type", `[{"op":"replace","path":"/completed","value":"yes"}]`,
+			map[string]error{"completed": todo.ErrInvalidJSON}, nil},
+		{"failed test", `[{"op":"replace","path":"/content","value":"new"},{"op":"test","path":"/content","value":"old"}]`,
+			nil, todo.ErrJSONPatchTest},
+		{"remove unknown field", `[{"op":"remove","path":"/colour"}]`, nil, todo.ErrJSONPatchInvalid},
+	} {
+		var ops []jsonPatchOp
+		if err := json.Unmarshal([]byte(test.ops), &ops); err != nil {
+			t.Fatal(err)
+		}
+		_, err := applyJSONPatch(ops, task)
+		if test.err != nil {
+			if err != test.err {
+				t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		var verr todo.ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: err = %v, want a ValidationError", test.name, err)
+			continue
+		}
+		if len(verr) != len(test.fields) {
+			t.Errorf("%s: err = %v, want %d fields", test.name, err, len(test.fields))
+		}
+		for _, fe := range verr {
+			if want, ok := test.fields[fe.Field]; !ok || fe.Err != want {
+				t.Errorf("%s: %s: %v, want %v", test.name, fe.Field, fe.Err, want)
+			}
+		}
+	}
+}
+
+// lockedTask patches one task, making JSON Patches from the task while it
+// holds the lock the way the postgres service does.
+type lockedTask struct {
+	todo.TaskService
+	mu   sync.Mutex
+	task todo.Task
+}
+
+func (s *lockedTask) PatchTaskFunc(id todo.TaskID, makePatch func(task *todo.Task) (*todo.TaskPatch, error), userID todo.UserID) (*todo.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != s.task.ID {
+		return nil, todo.ErrTaskNotFound
+	}
+	current := s.task
+	patch, err := makePatch(&current)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Content != nil {
+		s.task.Content = *patch.Content
+	}
+	if patch.Completed != nil {
+		s.task.Completed = *patch.Completed
+	}
+	task := s.task
+	return &task, nil
+}
+
+func TestPatchTaskJSONPatch(t *testing.T) {
+	h := newTestHandler()
+	tasks := &lockedTask{task: todo.Task{ID: "task", Content: "old"}}
+	h.TaskHandler.TaskService = tasks
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	user := login(t, h, srv, "user")
+	patch := func(ops string) *http.Response {
+		req, err := http.NewRequest(http.MethodPatch, srv.URL+"/api/tasks/task", strings.NewReader(ops))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", jsonPatchType)
+		req.AddCookie(user.cookie)
+		req.Header.Set(csrfHeader, user.csrf)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var task todo.Task
+	decode(t, patch(`[{"op":"test","path":"/content","value":"old"},{"op":"replace","path":"/content","value":"new"}]`), http.StatusOK, &task)
+	if task.Content != "new" {
+		t.Errorf("content = %q, want new", task.Content)
+	}
+	// The test runs against the task as it is now
+	decode(t, patch(`[{"op":"test","path":"/content","value":"old"},{"op":"replace","path":"/completed","value":true}]`), http.StatusConflict, nil)
+	decode(t, patch(`[{"op":"replace","path":"/id","value":"other"}]`), http.StatusBadRequest, nil)
+	decode(t, patch(`{"op":"replace"}`), http.StatusBadRequest, nil)
+	if tasks.task.Completed || tasks.task.ID != "task" {
+		t.Errorf("failed patches changed the task to %+v", tasks.task)
+	}
+}
@@ -12,24 +12,41 @@ import (
 	"github.com/kennedymj97/todo-api"
 )
 
+// DefaultMaxBatchSize is how many operations a batch can have by default.
+const DefaultMaxBatchSize = 100
+
 type TaskHandler struct {
-	*httprouter.Router
-	TaskService todo.TaskService
-	Logger      *log.Logger
+	*router
+	TaskService  todo.TaskService
+	MaxBatchSize int
+	Logger       *log.Logger
 }
 
 func NewTaskHandler() *TaskHandler {
 	h := &TaskHandler{
-		Router: httprouter.New(),
-		Logger: log.New(os.Stderr, "", log.LstdFlags),
+		router:       newScopedRouter("tasks"),
+		MaxBatchSize: DefaultMaxBatchSize,
+		Logger:       log.New(os.Stderr, "", log.LstdFlags),
 	}
+	// The v1 routes name the action in the path, and are kept for older
+	// clients. New clients use the v2 resource routes.
 	h.GET("/api/tasks", h.handleTasks)
+	h.GET("/api/tasks/assigned", h.handleAssignedTasks)
 	h.POST("/api/tasks/create", h.handleCreateTask)
 	h.POST("/api/tasks/edit", h.handleTaskEdit)
 	h.POST("/api/tasks/toggle", h.handleTaskToggle)
 	h.POST("/api/tasks/toggleAll", h.handleToggleAll)
+	h.POST("/api/tasks/assign", h.handleAssignTask)
+	h.POST("/api/tasks/due", h.handleSetDueDate)
 	h.DELETE("/api/tasks/delete/:id", h.handleDeleteTask)
 	h.DELETE("/api/tasks/clearCompleted", h.handleClearCompleted)
+	h.PATCH("/api/tasks/:id", h.handlePatchTask)
+	h.GET("/api/v2/tasks", h.handleTasks)
+	h.POST("/api/v2/tasks", h.handleCreateTaskV2)
+	h.GET("/api/v2/tasks/:id", h.handleTaskV2)
+	h.PATCH("/api/v2/tasks/:id", h.handlePatchTask)
+	h.DELETE("/api/v2/tasks/:id", h.handleDeleteTaskV2)
+	h.POST("/api/batch", h.handleBatch)
 	return h
 }
 
@@ -37,41 +54,77 @@ type getTasksResponse struct {
 	Tasks *todo.Tasks `json:"tasks,omitempty"`
 }
 
+// handleTasks returns every task the user can see, or only the tasks of the
+// project given in the project query parameter. The assignee query
+// parameter filters by assignee, "me" stands for the user.
 func (h *TaskHandler) handleTasks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	t, err := h.TaskService.Tasks(todo.UserID(r.Header.Get("userID")))
+	userID := todo.UserID(r.Header.Get("userID"))
+	var t *todo.Tasks
+	var err error
+	if projectID := r.URL.Query().Get("project"); projectID != "" {
+		t, err = h.TaskService.ProjectTasks(todo.ProjectID(projectID), userID)
+	} else {
+		t, err = h.TaskService.Tasks(userID)
+	}
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if t == nil {
+		NotFound(w)
+		return
+	}
 	tasks := *t
+	if assignee := r.URL.Query().Get("assignee"); assignee != "" {
+		if assignee == "me" {
+			assignee = string(userID)
+		}
+		filtered := todo.Tasks{}
+		for _, task := range tasks {
+			if task.AssigneeID == todo.UserID(assignee) {
+				filtered = append(filtered, task)
+			}
+		}
+		tasks = filtered
+	}
 	sort.Slice(tasks, func(i, j int) bool {
 		return tasks[i].Timestamp < tasks[j].Timestamp
 	})
+	encodeJSON(w, &getTasksResponse{Tasks: &tasks}, h.Logger)
+}
+
+func (h *TaskHandler) handleAssignedTasks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	t, err := h.TaskService.AssignedTasks(todo.UserID(r.Header.Get("userID")))
 	if err != nil {
-		Error(w, err, http.StatusInternalServerError, h.Logger)
-	} else if t == nil {
-		NotFound(w)
-	} else {
-		encodeJSON(w, &getTasksResponse{Tasks: &tasks}, h.Logger)
+		Problem(w, err, h.Logger)
+		return
 	}
+	tasks := *t
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Timestamp < tasks[j].Timestamp
+	})
+	encodeJSON(w, &getTasksResponse{Tasks: &tasks}, h.Logger)
 }
 
 type createTaskRequest struct {
-	ID      todo.TaskID      `json:"id"`
-	Content todo.TaskContent `json:"content,omitempty"`
+	ID        todo.TaskID      `json:"id"`
+	Content   todo.TaskContent `json:"content,omitempty"`
+	ProjectID todo.ProjectID   `json:"projectId,omitempty"`
 }
 
 func (h *TaskHandler) handleCreateTask(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req createTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
 		return
 	}
 	content := req.Content
 
-	switch err := h.TaskService.CreateTask(req.ID, content, todo.UserID(r.Header.Get("userID"))); err {
+	switch err := h.TaskService.CreateTask(req.ID, content, todo.UserID(r.Header.Get("userID")), req.ProjectID); err {
 	case nil:
 		encodeJSON(w, &infoResponse{fmt.Sprintf("Task has been successfully created with content: %s", content)}, h.Logger)
-	case todo.ErrTaskContentRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
 
@@ -83,18 +136,14 @@ type editTaskRequest struct {
 func (h *TaskHandler) handleTaskEdit(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req editTaskRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
 		return
 	}
-	switch err := h.TaskService.EditTask(req.ID, req.Content); err {
+	switch err := h.TaskService.EditTask(req.ID, req.Content, todo.UserID(r.Header.Get("userID"))); err {
 	case nil:
 		encodeJSON(w, &infoResponse{fmt.Sprintf("Task has been updated to content: %s", req.Content)}, h.Logger)
-	case todo.ErrTaskIDRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
-	case todo.ErrTaskContentRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 
 }
@@ -108,34 +157,83 @@ func (h *TaskHandler) handleTaskToggle(w http.ResponseWriter, r *http.Request, _
 	// Decode request
 	var req taskStatusRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
 		return
 	}
 
 	// Create task
-	switch err := h.TaskService.EditTaskStatus(req.ID, req.Val); err {
+	switch err := h.TaskService.EditTaskStatus(req.ID, req.Val, todo.UserID(r.Header.Get("userID"))); err {
 	case nil:
 		encodeJSON(w, &infoResponse{fmt.Sprintf("Task status has been set to %t", req.Val)}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
 
 type toggleAllRequest struct {
-	Val bool `json:"val"`
+	Val       bool           `json:"val"`
+	ProjectID todo.ProjectID `json:"projectId,omitempty"`
 }
 
 func (h *TaskHandler) handleToggleAll(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req toggleAllRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
 		return
 	}
-	switch err := h.TaskService.ToggleAll(req.Val); err {
+	switch err := h.TaskService.ToggleAll(req.Val, todo.UserID(r.Header.Get("userID")), req.ProjectID); err {
 	case nil:
 		encodeJSON(w, &infoResponse{"Tasks have all been toggled."}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
+	}
+}
+
+type assignTaskRequest struct {
+	ID         todo.TaskID `json:"id"`
+	AssigneeID todo.UserID `json:"assigneeId"`
+}
+
+func (h *TaskHandler) handleAssignTask(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req assignTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.TaskService.AssignTask(req.ID, req.AssigneeID, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		if req.AssigneeID == "" {
+			encodeJSON(w, &infoResponse{"Task has been unassigned"}, h.Logger)
+		} else {
+			encodeJSON(w, &infoResponse{fmt.Sprintf("Task has been assigned to %s", req.AssigneeID)}, h.Logger)
+		}
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type dueDateRequest struct {
+	ID         todo.TaskID     `json:"id"`
+	DueAt      string          `json:"dueAt"`
+	Recurrence todo.Recurrence `json:"recurrence"`
+}
+
+// handleSetDueDate sets or, with an empty dueAt, clears the due date.
+func (h *TaskHandler) handleSetDueDate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req dueDateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	switch err := h.TaskService.SetDueDate(req.ID, req.DueAt, req.Recurrence, todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		if req.DueAt == "" {
+			encodeJSON(w, &infoResponse{"Due date has been cleared"}, h.Logger)
+		} else {
+			encodeJSON(w, &infoResponse{fmt.Sprintf("Task is due at %s", req.DueAt)}, h.Logger)
+		}
+	default:
+		Problem(w, err, h.Logger)
 	}
 }
 
@@ -144,19 +242,22 @@ type deleteTaskRequest struct {
 }
 
 func (h *TaskHandler) handleDeleteTask(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	switch err := h.TaskService.DeleteTask(todo.TaskID(p.ByName("id"))); err {
+	switch err := h.TaskService.DeleteTask(todo.TaskID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
 	case nil:
 		encodeJSON(w, &infoResponse{"Task has been successfully deleted"}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
 
+// handleClearCompleted clears the user's own tasks, or the tasks of the
+// project given in the project query parameter.
 func (h *TaskHandler) handleClearCompleted(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	switch err := h.TaskService.ClearCompleted(); err {
+	projectID := todo.ProjectID(r.URL.Query().Get("project"))
+	switch err := h.TaskService.ClearCompleted(todo.UserID(r.Header.Get("userID")), projectID); err {
 	case nil:
 		encodeJSON(w, &infoResponse{"Completed tasks have been succesfully deleted"}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
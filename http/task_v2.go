@@ -0,0 +1,71 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+// newTaskID returns a random (version 4) UUID for a task the client didn't
+// pick an id for.
+func newTaskID() (todo.TaskID, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return todo.TaskID(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])), nil
+}
+
+// handleCreateTaskV2 creates a task and returns it with its url in the
+// Location header. The id is optional.
+func (h *TaskHandler) handleCreateTaskV2(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if req.ID == "" {
+		id, err := newTaskID()
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		}
+		req.ID = id
+	}
+	userID := todo.UserID(r.Header.Get("userID"))
+	if err := h.TaskService.CreateTask(req.ID, req.Content, userID, req.ProjectID); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	task, err := h.TaskService.Task(req.ID, userID)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	w.Header().Set("Location", "/api/v2/tasks/"+string(task.ID))
+	w.WriteHeader(http.StatusCreated)
+	encodeJSON(w, task, h.Logger)
+}
+
+func (h *TaskHandler) handleTaskV2(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	task, err := h.TaskService.Task(todo.TaskID(p.ByName("id")), todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, task, h.Logger)
+}
+
+func (h *TaskHandler) handleDeleteTaskV2(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if err := h.TaskService.DeleteTask(todo.TaskID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,31 +4,87 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/kennedymj97/todo-api"
+	"github.com/kennedymj97/todo-api/jwt"
+	"github.com/kennedymj97/todo-api/mail"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// resetTokenTTL is how long a password reset link works for.
+const resetTokenTTL = time.Hour
+
 type UserHandler struct {
-	*httprouter.Router
+	*router
 	UserService todo.UserService
-	Logger      *log.Logger
+	// MFAService is optional, without it logins never ask for a code.
+	MFAService todo.MFAService
+	// LoginAttempts is optional, without it failed logins aren't limited.
+	LoginAttempts todo.LoginAttemptService
+	// TrustedProxies are the reverse proxies in front of the api, client
+	// addresses are only read from X-Forwarded-For when a request comes
+	// through one.
+	TrustedProxies  []*net.IPNet
+	APITokenService todo.APITokenService
+	// JWTKeys turns on the jwt login mode, which needs RefreshTokenService
+	// too. Access tokens are checked with the keys alone.
+	JWTKeys             *jwt.KeySet
+	RefreshTokenService todo.RefreshTokenService
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
+	Mailer              mail.Mailer
+	Templates           *mail.Templates
+	// AppURL is the address of the web app, links in email point to it.
+	AppURL string
+	// VerifyKey signs email verification links.
+	VerifyKey []byte
+	// CSRFKey signs the CSRF tokens of sessions.
+	CSRFKey []byte
+	// SecureCookies should be set in production so the session cookie is
+	// only sent over https.
+	SecureCookies bool
+	SameSite      http.SameSite
+	Logger        *log.Logger
 }
 
 func NewUserHandler() *UserHandler {
 	h := &UserHandler{
-		Router: httprouter.New(),
-		Logger: log.New(os.Stderr, "", log.LstdFlags),
+		router:          newRouter(),
+		Templates:       mail.NewTemplates(),
+		AppURL:          defaultOrigin,
+		SameSite:        http.SameSiteLaxMode,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		Logger:          log.New(os.Stderr, "", log.LstdFlags),
 	}
-	h.POST("/api/users/create", h.handleCreateUser)
-	h.POST("/api/users/login", h.handleLogin)
+	h.Public(http.MethodPost, "/api/users/create", h.handleCreateUser)
+	h.Public(http.MethodPost, "/api/users/login", h.handleLogin)
 	h.DELETE("/api/users/logout", h.handleLogout)
 	h.DELETE("/api/users/delete", h.handleDeleteUser)
+	h.Public(http.MethodPost, "/api/users/password/forgot", h.handleForgotPassword)
+	h.Public(http.MethodPost, "/api/users/password/reset", h.handleResetPassword)
+	h.Public(http.MethodPost, "/api/users/verify", h.handleVerifyEmail)
+	h.POST("/api/users/verify/resend", h.handleResendVerification)
+	h.POST("/api/users/password", h.handleChangePassword)
+	h.POST("/api/users/email", h.handleChangeEmail)
+	h.Public(http.MethodPost, "/api/users/login/mfa", h.handleLoginMFA)
+	h.Secret(http.MethodPost, "/api/users/mfa/enroll", h.handleEnrollMFA)
+	h.Secret(http.MethodPost, "/api/users/mfa/confirm", h.handleConfirmMFA)
+	h.POST("/api/users/mfa/disable", h.handleDisableMFA)
+	h.GET("/api/users/sessions", h.handleSessions)
+	h.GET("/api/users/csrf", h.handleCSRFToken)
+	h.DELETE("/api/users/sessions", h.handleRevokeOtherSessions)
+	h.DELETE("/api/users/sessions/:id", h.handleRevokeSession)
+	h.GET("/api/users/tokens", h.handleAPITokens)
+	h.Secret(http.MethodPost, "/api/users/tokens", h.handleCreateAPIToken)
+	h.DELETE("/api/users/tokens/:id", h.handleDeleteAPIToken)
+	h.Public(http.MethodPost, "/api/users/token/refresh", h.handleRefreshToken)
+	h.Public(http.MethodPost, "/api/users/token/revoke", h.handleRevokeToken)
 	return h
 }
 
@@ -40,120 +96,455 @@ type createUserRequest struct {
 func (h *UserHandler) handleCreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req createUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	email, err := todo.NormalizeEmail(req.Email)
+	if err != nil {
+		Problem(w, err, h.Logger)
 		return
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		Error(w, todo.Error("failed to hash password"), http.StatusInternalServerError, h.Logger)
+		Problem(w, todo.Error("failed to hash password"), h.Logger)
+		return
 	}
 
-	switch err := h.UserService.CreateUser(req.Email, string(hash)); err {
+	switch err := h.UserService.CreateUser(email, string(hash)); err {
 	case nil:
-		encodeJSON(w, &infoResponse{fmt.Sprintf("User has been created with email: %s", req.Email)}, h.Logger)
-	case todo.ErrEmailRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
-	case todo.ErrPasswordRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
-	case todo.ErrEmailExists:
-		Error(w, err, http.StatusConflict, h.Logger)
+		// The account works without verifying so a failed email isn't fatal
+		userID, _, err := h.UserService.User(email)
+		if err == nil {
+			err = h.sendVerification(userID, email)
+		}
+		if err != nil {
+			h.Logger.Printf("mail error: %s (verification not sent)", err)
+		}
+		encodeJSON(w, &infoResponse{fmt.Sprintf("User has been created with email: %s", email)}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
 
 type loginRequest struct {
 	Email    todo.Email `json:"email"`
 	Password string     `json:"password"`
+	// Mode is empty for a session cookie or jwt for tokens.
+	Mode string `json:"mode"`
 }
 
 func (h *UserHandler) handleLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req loginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		Error(w, todo.ErrInvalidJSON, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
 		return
 	}
 
+	if err := h.checkLoginMode(req.Mode); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if email, err := todo.NormalizeEmail(req.Email); err == nil {
+		req.Email = email
+	}
+	ip := h.clientIP(r)
+	if !h.countLoginAttempt(w, req.Email, ip) {
+		return
+	}
+
+	// A wrong email and a wrong password must look the same, including how
+	// long they take, so a missing user is checked against a dummy hash
 	userID, pword, err := h.UserService.User(req.Email)
-	if err != nil {
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+	switch err {
+	case nil:
+	case todo.ErrUserNotFound:
+		pword = string(dummyHash)
+	default:
+		Problem(w, err, h.Logger)
 		return
 	}
+	if bcrypt.CompareHashAndPassword([]byte(pword), []byte(req.Password)) != nil || userID == "" {
+		Problem(w, todo.ErrLoginFailed, h.Logger)
+		return
+	}
+	if h.MFAService != nil {
+		enabled, err := h.MFAService.MFAEnabled(userID)
+		if err != nil {
+			Problem(w, err, h.Logger)
+			return
+		} else if enabled {
+			// The attempt stays counted until the second factor is right
+			// too, so codes can't be guessed without hitting the lockout
+			h.startMFAChallenge(w, userID)
+			return
+		}
+	}
+	h.loginSucceeded(req.Email, ip)
+	h.login(w, r, userID, req.Mode)
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(pword), []byte(req.Password))
+// createSession logs the user in by setting a new session cookie.
+func (h *UserHandler) createSession(w http.ResponseWriter, r *http.Request, userID todo.UserID) {
+	//generate session token, only its hash is stored
+	token, hash, err := newToken()
 	if err != nil {
-		Error(w, err, http.StatusBadRequest, h.Logger)
+		Problem(w, err, h.Logger)
 		return
 	}
-
-	//generate session id
-	sessionID := todo.SessionID(uuid.New().String())
 	//get expiry time
 	expiryTime := todo.ExpiryTime(time.Now().String())
-	switch err := h.UserService.CreateUserSession(sessionID, userID, expiryTime); err {
+	switch err := h.UserService.CreateUserSession(hash, userID, expiryTime, r.UserAgent(), h.clientIP(r)); err {
 	case nil:
-		// Set to secure in future so it can only be transferred over https
-		sessionCookie := &http.Cookie{
-			Name:     "session",
-			Value:    string(sessionID),
-			HttpOnly: true,
-			Expires:  time.Now().Add(24 * 14 * time.Hour),
-			Path:     "/",
-			Domain:   r.Host,
-		}
-		http.SetCookie(w, sessionCookie)
+		http.SetCookie(w, h.sessionCookie(r, token, time.Now().Add(24*14*time.Hour)))
 		encodeJSON(w, &infoResponse{"Login successful"}, h.Logger)
-	case todo.ErrSessionRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
-	case todo.ErrUserIDRequired:
-		Error(w, err, http.StatusBadRequest, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
 	}
 }
 
 func (h *UserHandler) handleLogout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	sessionIDCookie, err := r.Cookie("session")
 	if err != nil {
-		Error(w, err, http.StatusBadRequest, h.Logger)
+		Problem(w, todo.ErrSessionRequired, h.Logger)
 		return
 	}
-	sessionID := sessionIDCookie.Value
-	switch err := h.UserService.LogoutUser(todo.SessionID(sessionID)); err {
+	switch err := h.UserService.LogoutUser(hashToken(sessionIDCookie.Value)); err {
 	case nil:
-		// Set to secure in future so it can only be transferred over https
-		sessionCookie := &http.Cookie{
-			Name:     "session",
-			Value:    "",
-			HttpOnly: true,
-			Expires:  time.Now(),
-			Path:     "/",
-			Domain:   r.Host,
-		}
-		http.SetCookie(w, sessionCookie)
+		http.SetCookie(w, h.sessionCookie(r, "", time.Now()))
 		encodeJSON(w, &infoResponse{"Succesfully logged out"}, h.Logger)
-	case todo.ErrSessionRequired:
-		Error(w, todo.ErrSessionRequired, http.StatusBadRequest, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
+	}
+}
+
+// sessionCookie returns the session cookie with value. SameSite keeps
+// browsers from sending it with most cross site requests, and in production
+// it is only sent over https.
+func (h *UserHandler) sessionCookie(r *http.Request, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     "session",
+		Value:    value,
+		HttpOnly: true,
+		Secure:   h.SecureCookies,
+		SameSite: h.SameSite,
+		Expires:  expires,
+		Path:     "/",
+		Domain:   r.Host,
+	}
+}
+
+// ParseSameSite returns the SameSite mode named strict, lax or none.
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch mode {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode %q", mode)
+}
+
+// sessionTokenHash returns the hash of the request's session cookie, or an
+// empty string if it doesn't have one.
+func sessionTokenHash(r *http.Request) string {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return ""
+	}
+	return hashToken(cookie.Value)
+}
+
+type getSessionsResponse struct {
+	Sessions *todo.Sessions `json:"sessions"`
+}
+
+func (h *UserHandler) handleSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	sessions, err := h.UserService.Sessions(todo.UserID(r.Header.Get("userID")), sessionTokenHash(r))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getSessionsResponse{Sessions: sessions}, h.Logger)
+}
+
+func (h *UserHandler) handleRevokeSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch err := h.UserService.RevokeSession(todo.SessionID(ps.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Session has been logged out"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+// handleRevokeOtherSessions logs the user out everywhere except the session
+// making the request.
+func (h *UserHandler) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	switch err := h.UserService.RevokeOtherSessions(todo.UserID(r.Header.Get("userID")), sessionTokenHash(r)); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Logged out of all other sessions"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
 	}
 }
 
 func (h *UserHandler) handleDeleteUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	switch err := h.UserService.DeleteUser(todo.UserID(r.Header.Get("userID"))); err {
 	case nil:
-		// Set to secure in future so it can only be transferred over https
-		sessionCookie := &http.Cookie{
-			Name:     "session",
-			Value:    "",
-			HttpOnly: true,
-			Expires:  time.Now(),
-			Path:     "/api/",
-			Domain:   r.Host,
-		}
-		http.SetCookie(w, sessionCookie)
+		http.SetCookie(w, h.sessionCookie(r, "", time.Now()))
 		encodeJSON(w, &infoResponse{"User deleted successfully"}, h.Logger)
 	default:
-		Error(w, err, http.StatusInternalServerError, h.Logger)
+		Problem(w, err, h.Logger)
+	}
+}
+
+type forgotPasswordRequest struct {
+	Email todo.Email `json:"email"`
+}
+
+// handleForgotPassword emails a password reset link. The response is the
+// same whether or not there is an account for the email, so it can't be
+// used to find out who has an account.
+func (h *UserHandler) handleForgotPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req forgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if email, err := todo.NormalizeEmail(req.Email); err == nil {
+		req.Email = email
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	switch _, err := h.UserService.CreatePasswordReset(req.Email, hash, time.Now().Add(resetTokenTTL)); err {
+	case nil:
+		h.sendPasswordReset(req.Email, token)
+	case todo.ErrUserNotFound:
+	default:
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &infoResponse{"If there is an account for that email a password reset link has been sent to it"}, h.Logger)
+}
+
+// sendPasswordReset queues the reset email. Failures are only logged, the
+// response mustn't differ from when there is no account.
+func (h *UserHandler) sendPasswordReset(email todo.Email, token string) {
+	if h.Mailer == nil {
+		h.Logger.Printf("mail error: no mailer, password reset not sent")
+		return
+	}
+	m, err := h.Templates.Render(mail.TemplatePasswordReset, string(email), map[string]string{
+		"URL":       fmt.Sprintf("%s/password/reset?token=%s", h.AppURL, token),
+		"ExpiresIn": "1 hour",
+	})
+	if err == nil {
+		err = h.Mailer.Send(m)
+	}
+	if err != nil {
+		h.Logger.Printf("mail error: %s", err)
+	}
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h *UserHandler) handleResetPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if req.Token == "" {
+		Problem(w, todo.ErrResetTokenRequired, h.Logger)
+		return
+	} else if req.Password == "" {
+		Problem(w, todo.ErrPasswordRequired, h.Logger)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		Problem(w, todo.Error("failed to hash password"), h.Logger)
+		return
+	}
+	switch err := h.UserService.ResetPassword(hashToken(req.Token), string(hash)); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Password has been reset"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+// sendVerification queues an email with a link that verifies the address.
+func (h *UserHandler) sendVerification(userID todo.UserID, email todo.Email) error {
+	if h.Mailer == nil {
+		return todo.Error("no mailer")
+	}
+	token, err := signVerification(h.VerifyKey, userID, email, time.Now().Add(verificationTTL))
+	if err != nil {
+		return err
+	}
+	m, err := h.Templates.Render(mail.TemplateVerifyEmail, string(email), map[string]string{
+		"URL":       fmt.Sprintf("%s/verify?token=%s", h.AppURL, token),
+		"ExpiresIn": "48 hours",
+	})
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(m)
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (h *UserHandler) handleVerifyEmail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req verifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	userID, email, err := parseVerification(h.VerifyKey, req.Token)
+	if err == nil {
+		err = h.UserService.VerifyEmail(userID, email)
+	}
+	switch err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Email address has been verified"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *UserHandler) handleResendVerification(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, err := h.UserService.Account(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	email := user.Email
+	if user.PendingEmail != "" {
+		email = user.PendingEmail
+	} else if user.VerifiedAt != "" {
+		Problem(w, todo.ErrAlreadyVerified, h.Logger)
+		return
+	}
+	if err := h.sendVerification(user.ID, email); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &infoResponse{"Verification email has been sent"}, h.Logger)
+}
+
+// checkPassword returns ErrPasswordIncorrect unless password is the user's
+// current password.
+func (h *UserHandler) checkPassword(userID todo.UserID, password string) (*todo.User, error) {
+	user, err := h.UserService.Account(userID)
+	if err != nil {
+		return nil, err
+	}
+	_, hash, err := h.UserService.User(user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, todo.ErrPasswordIncorrect
+	}
+	return user, nil
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// handleChangePassword sets a new password and signs out every other
+// session of the user.
+func (h *UserHandler) handleChangePassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	if req.NewPassword == "" {
+		Problem(w, todo.ErrPasswordRequired, h.Logger)
+		return
+	}
+	userID := todo.UserID(r.Header.Get("userID"))
+	if _, err := h.checkPassword(userID, req.CurrentPassword); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		Problem(w, todo.Error("failed to hash password"), h.Logger)
+		return
+	}
+	if _, err := h.UserService.UpdateUser(userID, todo.UserUpdate{Password: string(hash)}, sessionTokenHash(r)); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &infoResponse{"Password has been changed"}, h.Logger)
+}
+
+type changeEmailRequest struct {
+	Email           todo.Email `json:"email"`
+	CurrentPassword string     `json:"currentPassword"`
+}
+
+// handleChangeEmail starts changing the user's email. The new address gets
+// a verification link and takes over once it is followed, the old address
+// is told about the change.
+func (h *UserHandler) handleChangeEmail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	email, err := todo.NormalizeEmail(req.Email)
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	userID := todo.UserID(r.Header.Get("userID"))
+	if _, err := h.checkPassword(userID, req.CurrentPassword); err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	previous, err := h.UserService.UpdateUser(userID, todo.UserUpdate{Email: email}, "")
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	if previous.Email == email {
+		encodeJSON(w, &infoResponse{"Email address is unchanged"}, h.Logger)
+		return
+	}
+	if err := h.sendVerification(userID, email); err != nil {
+		h.Logger.Printf("mail error: %s (verification not sent)", err)
+	}
+	if err := h.sendEmailChanged(previous.Email, email); err != nil {
+		h.Logger.Printf("mail error: %s (email change notice not sent)", err)
+	}
+	encodeJSON(w, &infoResponse{fmt.Sprintf("A verification link has been sent to %s", email)}, h.Logger)
+}
+
+func (h *UserHandler) sendEmailChanged(old todo.Email, email todo.Email) error {
+	if h.Mailer == nil {
+		return todo.Error("no mailer")
+	}
+	m, err := h.Templates.Render(mail.TemplateEmailChanged, string(old), map[string]string{
+		"Email": string(email),
+		"URL":   fmt.Sprintf("%s/password/forgot", h.AppURL),
+	})
+	if err != nil {
+		return err
 	}
+	return h.Mailer.Send(m)
 }
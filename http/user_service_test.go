@@ -0,0 +1,178 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/kennedymj97/todo-api/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (s *memoryUsers) CreateUser(email todo.Email, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[email]; ok {
+		return todo.ErrEmailExists
+	}
+	s.nextID++
+	s.users[email] = &memoryUser{id: todo.UserID("user-" + strconv.Itoa(s.nextID)), password: password}
+	return nil
+}
+
+func (s *memoryUsers) User(email todo.Email) (todo.UserID, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[email]; ok {
+		return u.id, u.password, nil
+	}
+	return "", "", todo.ErrUserNotFound
+}
+
+func (s *memoryUsers) CreatePasswordReset(email todo.Email, tokenHash string, expiresAt time.Time) (todo.UserID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[email]
+	if !ok {
+		return "", todo.ErrUserNotFound
+	}
+	s.resets[tokenHash] = email
+	return u.id, nil
+}
+
+func (s *memoryUsers) ResetPassword(tokenHash string, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := s.resets[tokenHash]
+	if !ok {
+		return todo.ErrResetTokenInvalid
+	}
+	delete(s.resets, tokenHash)
+	s.users[email].password = password
+	return nil
+}
+
+func (s *memoryUsers) VerifyEmail(id todo.UserID, email todo.Email) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[email]; ok && u.id == id {
+		u.verified = true
+		return nil
+	}
+	return todo.ErrVerificationInvalid
+}
+
+// mailTest is a server whose email is kept in memory.
+type mailTest struct {
+	t      *testing.T
+	srv    *httptest.Server
+	users  *memoryUsers
+	mailer *mail.MemoryMailer
+}
+
+func newMailTest(t *testing.T) *mailTest {
+	h := newTestHandler()
+	m := &mailTest{t: t, users: h.UserHandler.UserService.(*memoryUsers), mailer: &mail.MemoryMailer{}}
+	h.UserHandler.Mailer = m.mailer
+	h.UserHandler.AppURL = "https://app.example.com"
+	m.srv = httptest.NewServer(h)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// post sends a JSON request without logging in.
+func (m *mailTest) post(path string, v interface{}) *http.Response {
+	return (&testClient{t: m.t, srv: m.srv}).do(http.MethodPost, path, v)
+}
+
+// link returns the token of the link to the app's path in the only email
+// sent to the address, and checks the email's subject.
+func (m *mailTest) link(to, subject, path string) string {
+	m.t.Helper()
+	var sent []mail.Message
+	for _, msg := range m.mailer.Messages() {
+		if len(msg.To) == 1 && msg.To[0] == to {
+			sent = append(sent, msg)
+		}
+	}
+	if len(sent) != 1 {
+		m.t.Fatalf("%d emails sent to %s, want 1", len(sent), to)
+	} else if sent[0].Subject != subject {
+		m.t.Fatalf("subject = %q, want %q", sent[0].Subject, subject)
+	}
+	prefix := "https://app.example.com" + path + "?token="
+	i := strings.Index(sent[0].Text, prefix)
+	if i < 0 {
+		m.t.Fatalf("no %s link in %q", path, sent[0].Text)
+	}
+	link, err := url.Parse(strings.Fields(sent[0].Text[i:])[0])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if !strings.Contains(sent[0].HTML, link.String()) {
+		m.t.Errorf("html doesn't link to %s: %q", link, sent[0].HTML)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordResetEmail(t *testing.T) {
+	m := newMailTest(t)
+	m.users.CreateUser("ann@example.com", "old hash")
+
+	decode(t, m.post("/api/users/password/forgot", &forgotPasswordRequest{Email: "Ann@Example.com"}), http.StatusOK, nil)
+	token := m.link("ann@example.com", "Reset your password", "/password/reset")
+
+	decode(t, m.post("/api/users/password/reset", &resetPasswordRequest{Token: token, Password: "new password"}), http.StatusOK, nil)
+	_, hash, _ := m.users.User("ann@example.com")
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("new password")); err != nil {
+		t.Errorf("password wasn't reset: %s", err)
+	}
+	// The link only works once
+	var problem problemDocument
+	decode(t, m.post("/api/users/password/reset", &resetPasswordRequest{Token: token, Password: "another"}), http.StatusBadRequest, &problem)
+	if problem.Code != "reset_token_invalid" {
+		t.Errorf("code = %q, want reset_token_invalid", problem.Code)
+	}
+}
+
+func TestPasswordResetNoAccount(t *testing.T) {
+	m := newMailTest(t)
+	m.users.CreateUser("ann@example.com", "hash")
+
+	// The response mustn't give away who has an account
+	var known, unknown infoResponse
+	decode(t, m.post("/api/users/password/forgot", &forgotPasswordRequest{Email: "ann@example.com"}), http.StatusOK, &known)
+	decode(t, m.post("/api/users/password/forgot", &forgotPasswordRequest{Email: "bob@example.com"}), http.StatusOK, &unknown)
+	if known != unknown {
+		t.Errorf("responses differ: %+v and %+v", known, unknown)
+	}
+	if sent := m.mailer.Messages(); len(sent) != 1 || sent[0].To[0] != "ann@example.com" {
+		t.Errorf("sent %+v, want one email to ann@example.com", sent)
+	}
+}
+
+func TestVerificationEmail(t *testing.T) {
+	m := newMailTest(t)
+
+	decode(t, m.post("/api/users/create", &createUserRequest{Email: "Ann@Example.com", Password: "password"}), http.StatusOK, nil)
+	token := m.link("ann@example.com", "Verify your email address", "/verify")
+
+	var problem problemDocument
+	decode(t, m.post("/api/users/verify", &verifyEmailRequest{Token: token + "x"}), http.StatusBadRequest, &problem)
+	if problem.Code != "verification_invalid" {
+		t.Errorf("code = %q, want verification_invalid", problem.Code)
+	}
+	if m.users.users["ann@example.com"].verified {
+		t.Fatal("verified with a tampered link")
+	}
+
+	decode(t, m.post("/api/users/verify", &verifyEmailRequest{Token: token}), http.StatusOK, nil)
+	if !m.users.users["ann@example.com"].verified {
+		t.Error("email wasn't verified")
+	}
+}
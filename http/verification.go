@@ -0,0 +1,69 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// verificationTTL is how long an email verification link works for.
+const verificationTTL = 48 * time.Hour
+
+// DefaultRequireVerified are the routes unverified accounts can't use:
+// sharing projects with other people and sending webhooks.
+var DefaultRequireVerified = []string{
+	"/api/projects/invite",
+	"/api/projects/invitations/accept/",
+	"/api/projects/transfer",
+	"/api/webhooks/create",
+}
+
+type verificationClaims struct {
+	UserID  todo.UserID `json:"u"`
+	Email   todo.Email  `json:"e"`
+	Expires int64       `json:"x"`
+}
+
+// signVerification returns a token proving the user owns the email. The
+// token is the claims and their HMAC-SHA256, so nothing needs storing.
+func signVerification(key []byte, userID todo.UserID, email todo.Email, expires time.Time) (string, error) {
+	claims, err := json.Marshal(&verificationClaims{UserID: userID, Email: email, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key, payload)), nil
+}
+
+// parseVerification checks a token from signVerification and returns the
+// user and email it was issued for.
+func parseVerification(key []byte, token string) (todo.UserID, todo.Email, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", todo.ErrVerificationInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, sign(key, parts[0])) {
+		return "", "", todo.ErrVerificationInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", todo.ErrVerificationInvalid
+	}
+	var claims verificationClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || time.Now().Unix() > claims.Expires {
+		return "", "", todo.ErrVerificationInvalid
+	}
+	return claims.UserID, claims.Email, nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
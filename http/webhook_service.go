@@ -0,0 +1,100 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kennedymj97/todo-api"
+)
+
+type WebhookHandler struct {
+	*router
+	WebhookService todo.WebhookService
+	Logger         *log.Logger
+}
+
+func NewWebhookHandler() *WebhookHandler {
+	h := &WebhookHandler{
+		router: newRouter(),
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	h.GET("/api/webhooks", h.handleWebhooks)
+	h.POST("/api/webhooks/create", h.handleCreateWebhook)
+	h.POST("/api/webhooks/enable/:id", h.handleEnableWebhook)
+	h.DELETE("/api/webhooks/delete/:id", h.handleDeleteWebhook)
+	h.GET("/api/webhooks/deliveries/:id", h.handleDeliveries)
+	return h
+}
+
+type getWebhooksResponse struct {
+	Webhooks *todo.Webhooks `json:"webhooks"`
+}
+
+func (h *WebhookHandler) handleWebhooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	webhooks, err := h.WebhookService.Webhooks(todo.UserID(r.Header.Get("userID")))
+	if err != nil {
+		Problem(w, err, h.Logger)
+		return
+	}
+	encodeJSON(w, &getWebhooksResponse{Webhooks: webhooks}, h.Logger)
+}
+
+type createWebhookRequest struct {
+	URL    string           `json:"url"`
+	Events []todo.EventType `json:"events"`
+	Secret string           `json:"secret"`
+}
+
+type createWebhookResponse struct {
+	ID todo.WebhookID `json:"id"`
+}
+
+func (h *WebhookHandler) handleCreateWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Problem(w, todo.ErrInvalidJSON, h.Logger)
+		return
+	}
+	id, err := h.WebhookService.CreateWebhook(todo.UserID(r.Header.Get("userID")), req.URL, req.Events, req.Secret)
+	switch err {
+	case nil:
+		encodeJSON(w, &createWebhookResponse{ID: id}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *WebhookHandler) handleEnableWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.WebhookService.EnableWebhook(todo.WebhookID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Webhook has been enabled"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+func (h *WebhookHandler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	switch err := h.WebhookService.DeleteWebhook(todo.WebhookID(p.ByName("id")), todo.UserID(r.Header.Get("userID"))); err {
+	case nil:
+		encodeJSON(w, &infoResponse{"Webhook has been successfully deleted"}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
+
+type getDeliveriesResponse struct {
+	Deliveries *todo.WebhookDeliveries `json:"deliveries"`
+}
+
+func (h *WebhookHandler) handleDeliveries(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	deliveries, err := h.WebhookService.Deliveries(todo.WebhookID(p.ByName("id")), todo.UserID(r.Header.Get("userID")))
+	switch err {
+	case nil:
+		encodeJSON(w, &getDeliveriesResponse{Deliveries: deliveries}, h.Logger)
+	default:
+		Problem(w, err, h.Logger)
+	}
+}
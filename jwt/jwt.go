@@ -0,0 +1,205 @@
+// Package jwt signs and verifies compact JSON Web Tokens with HS256 or
+// EdDSA (Ed25519) keys. Every token names the key it was signed with so
+// keys can be rotated: tokens are signed with the newest key and verified
+// with whichever key they name.
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Algorithms keys can use.
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrUnknownKey       = errors.New("jwt: unknown key id")
+	ErrAlgorithm        = errors.New("jwt: algorithm does not match key")
+	ErrSignature        = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token has expired")
+	ErrNoSigningKey     = errors.New("jwt: no signing key")
+	ErrKeyInvalid       = errors.New("jwt: invalid key")
+	ErrAlgorithmUnknown = errors.New("jwt: algorithm must be HS256 or EdDSA")
+)
+
+// Key is a signing key. HS256 keys use Secret, EdDSA keys use PrivateKey to
+// sign and PublicKey to verify, so a verify only key can leave PrivateKey
+// nil.
+type Key struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+// KeySet signs with its first key and verifies with any of them. Retired
+// keys stay in the set until every token they signed has expired.
+type KeySet struct {
+	keys []*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, ErrKeyInvalid
+		}
+		switch k.Algorithm {
+		case HS256:
+			if len(k.Secret) < 32 {
+				return nil, ErrKeyInvalid
+			}
+		case EdDSA:
+			if k.PublicKey == nil && k.PrivateKey != nil {
+				k.PublicKey = k.PrivateKey.Public().(ed25519.PublicKey)
+			}
+			if len(k.PublicKey) != ed25519.PublicKeySize {
+				return nil, ErrKeyInvalid
+			}
+		default:
+			return nil, ErrAlgorithmUnknown
+		}
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// ParseKeys reads keys written as "id:algorithm:key", separated by commas.
+// The key is base64: the secret for HS256, the 32 byte seed for EdDSA.
+func ParseKeys(s string) (*KeySet, error) {
+	var keys []*Key
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 {
+			return nil, ErrKeyInvalid
+		}
+		raw, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, ErrKeyInvalid
+		}
+		k := &Key{ID: fields[0], Algorithm: fields[1]}
+		switch k.Algorithm {
+		case HS256:
+			k.Secret = raw
+		case EdDSA:
+			if len(raw) != ed25519.SeedSize {
+				return nil, ErrKeyInvalid
+			}
+			k.PrivateKey = ed25519.NewKeyFromSeed(raw)
+		default:
+			return nil, ErrAlgorithmUnknown
+		}
+		keys = append(keys, k)
+	}
+	return NewKeySet(keys...)
+}
+
+func (ks *KeySet) key(id string) *Key {
+	for _, k := range ks.keys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// Claims are the registered claims this package checks.
+type Claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign returns claims signed with the set's signing key.
+func (ks *KeySet) Sign(claims *Claims) (string, error) {
+	if len(ks.keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	k := ks.keys[0]
+	if k.Algorithm == EdDSA && k.PrivateKey == nil {
+		return "", ErrNoSigningKey
+	}
+	h, err := json.Marshal(&header{Algorithm: k.Algorithm, KeyID: k.ID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	return signed + "." + encoding.EncodeToString(k.sign(signed)), nil
+}
+
+// Verify checks the token's signature and expiry and returns its claims.
+// The algorithm comes from the key, never the token, so a token can't pick
+// a weaker one.
+func (ks *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	k := ks.key(h.KeyID)
+	if k == nil {
+		return nil, ErrUnknownKey
+	} else if h.Algorithm != k.Algorithm {
+		return nil, ErrAlgorithm
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil || !k.verify(parts[0]+"."+parts[1], sig) {
+		return nil, ErrSignature
+	}
+	var claims Claims
+	if err := decode(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func decode(part string, v interface{}) error {
+	raw, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (k *Key) sign(signed string) []byte {
+	if k.Algorithm == EdDSA {
+		return ed25519.Sign(k.PrivateKey, []byte(signed))
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func (k *Key) verify(signed string, sig []byte) bool {
+	if k.Algorithm == EdDSA {
+		return ed25519.Verify(k.PublicKey, []byte(signed), sig)
+	}
+	return hmac.Equal(sig, k.sign(signed))
+}
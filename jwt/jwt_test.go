@@ -0,0 +1,231 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func testClaims() *Claims {
+	return &Claims{Subject: "user", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+}
+
+func hsKey(id string) *Key {
+	return &Key{ID: id, Algorithm: HS256, Secret: []byte(strings.Repeat(id, 32))}
+}
+
+func edKey(id string) *Key {
+	seed := make([]byte, ed25519.SeedSize)
+	copy(seed, id)
+	return &Key{ID: id, Algorithm: EdDSA, PrivateKey: ed25519.NewKeyFromSeed(seed)}
+}
+
+func newTestKeySet(t *testing.T, keys ...*Key) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func sign(t *testing.T, ks *KeySet, claims *Claims) string {
+	t.Helper()
+	token, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// forge builds a token with any header, signed with the HMAC secret or the
+// private key given.
+func forge(t *testing.T, h *header, claims *Claims, secret []byte, private ed25519.PrivateKey) string {
+	t.Helper()
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := encoding.EncodeToString(hb) + "." + encoding.EncodeToString(cb)
+	var sig []byte
+	if private != nil {
+		sig = ed25519.Sign(private, []byte(signed))
+	} else {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	return signed + "." + encoding.EncodeToString(sig)
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, k := range []*Key{hsKey("hs"), edKey("ed")} {
+		ks := newTestKeySet(t, k)
+		claims, err := ks.Verify(sign(t, ks, testClaims()), now)
+		if err != nil {
+			t.Errorf("%s: %v", k.Algorithm, err)
+		} else if *claims != *testClaims() {
+			t.Errorf("%s: claims = %+v, want %+v", k.Algorithm, claims, testClaims())
+		}
+	}
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	hs, ed := hsKey("hs"), edKey("ed")
+	ks := newTestKeySet(t, hs, ed)
+	// An HS256 token for the EdDSA key, using its public key as the secret
+	token := forge(t, &header{Algorithm: HS256, KeyID: "ed", Type: "JWT"}, testClaims(), ed.PrivateKey.Public().(ed25519.PublicKey), nil)
+	if _, err := ks.Verify(token, now); err != ErrAlgorithm {
+		t.Errorf("HS256 token for EdDSA key: err = %v, want %v", err, ErrAlgorithm)
+	}
+	// and an EdDSA token for the HS256 key
+	token = forge(t, &header{Algorithm: EdDSA, KeyID: "hs", Type: "JWT"}, testClaims(), nil, ed.PrivateKey)
+	if _, err := ks.Verify(token, now); err != ErrAlgorithm {
+		t.Errorf("EdDSA token for HS256 key: err = %v, want %v", err, ErrAlgorithm)
+	}
+	for _, alg := range []string{"none", "", "HS512"} {
+		token = forge(t, &header{Algorithm: alg, KeyID: "hs", Type: "JWT"}, testClaims(), hs.Secret, nil)
+		if _, err := ks.Verify(token, now); err != ErrAlgorithm {
+			t.Errorf("alg %q: err = %v, want %v", alg, err, ErrAlgorithm)
+		}
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	ks := newTestKeySet(t, hsKey("hs"))
+	other := newTestKeySet(t, hsKey("other"))
+	if _, err := ks.Verify(sign(t, other, testClaims()), now); err != ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+	token := forge(t, &header{Algorithm: HS256, Type: "JWT"}, testClaims(), hsKey("hs").Secret, nil)
+	if _, err := ks.Verify(token, now); err != ErrUnknownKey {
+		t.Errorf("no kid: err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	for _, k := range []*Key{hsKey("hs"), edKey("ed")} {
+		ks := newTestKeySet(t, k)
+		parts := strings.Split(sign(t, ks, testClaims()), ".")
+
+		claims := testClaims()
+		claims.Subject = "admin"
+		payload, _ := json.Marshal(claims)
+		tampered := parts[0] + "." + encoding.EncodeToString(payload) + "." + parts[2]
+		if _, err := ks.Verify(tampered, now); err != ErrSignature {
+			t.Errorf("%s tampered payload: err = %v, want %v", k.Algorithm, err, ErrSignature)
+		}
+
+		sig, _ := encoding.DecodeString(parts[2])
+		sig[0] ^= 1
+		tampered = parts[0] + "." + parts[1] + "." + encoding.EncodeToString(sig)
+		if _, err := ks.Verify(tampered, now); err != ErrSignature {
+			t.Errorf("%s tampered signature: err = %v, want %v", k.Algorithm, err, ErrSignature)
+		}
+		if _, err := ks.Verify(parts[0]+"."+parts[1]+".", now); err != ErrSignature {
+			t.Errorf("%s no signature: err = %v, want %v", k.Algorithm, err, ErrSignature)
+		}
+	}
+	ks := newTestKeySet(t, hsKey("hs"))
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.sig"} {
+		if _, err := ks.Verify(token, now); err != ErrMalformed {
+			t.Errorf("Verify(%q) err = %v, want %v", token, err, ErrMalformed)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ks := newTestKeySet(t, hsKey("hs"))
+	token := sign(t, ks, testClaims())
+	for _, test := range []struct {
+		at  time.Time
+		err error
+	}{
+		{now, nil},
+		{now.Add(59 * time.Second), nil},
+		{now.Add(time.Minute), ErrExpired},
+		{now.Add(time.Hour), ErrExpired},
+	} {
+		if _, err := ks.Verify(token, test.at); err != test.err {
+			t.Errorf("Verify at %s: err = %v, want %v", test.at.Sub(now), err, test.err)
+		}
+	}
+	// A token without an expiry has expired
+	noExp := sign(t, ks, &Claims{Subject: "user"})
+	if _, err := ks.Verify(noExp, now); err != ErrExpired {
+		t.Errorf("no exp: err = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestVerifyOnlyKey(t *testing.T) {
+	signer := edKey("ed")
+	verifier := newTestKeySet(t, &Key{ID: "ed", Algorithm: EdDSA, PublicKey: signer.PrivateKey.Public().(ed25519.PublicKey)})
+	if _, err := verifier.Sign(testClaims()); err != ErrNoSigningKey {
+		t.Errorf("Sign err = %v, want %v", err, ErrNoSigningKey)
+	}
+	token := sign(t, newTestKeySet(t, signer), testClaims())
+	if _, err := verifier.Verify(token, now); err != nil {
+		t.Error(err)
+	}
+	if _, err := newTestKeySet(t).Sign(testClaims()); err != ErrNoSigningKey {
+		t.Errorf("empty set Sign err = %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, next := hsKey("old"), edKey("new")
+	oldToken := sign(t, newTestKeySet(t, old), testClaims())
+	rotated := newTestKeySet(t, next, old)
+	newToken := sign(t, rotated, testClaims())
+	var h header
+	if err := decode(strings.Split(newToken, ".")[0], &h); err != nil {
+		t.Fatal(err)
+	} else if h.KeyID != "new" || h.Algorithm != EdDSA {
+		t.Errorf("rotated set signed with %s %s, want new EdDSA", h.KeyID, h.Algorithm)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := rotated.Verify(token, now); err != nil {
+			t.Error(err)
+		}
+	}
+	// Once the old key is retired its tokens stop working
+	retired := newTestKeySet(t, next)
+	if _, err := retired.Verify(oldToken, now); err != ErrUnknownKey {
+		t.Errorf("retired key: err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))
+	seed := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize))
+	ks, err := ParseKeys("b:EdDSA:" + seed + ", a:HS256:" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.keys) != 2 || ks.keys[0].ID != "b" || ks.keys[0].PublicKey == nil || ks.keys[1].ID != "a" {
+		t.Errorf("keys = %+v", ks.keys)
+	}
+	for _, s := range []string{
+		"a:HS256",
+		"a:HS256:not base64!",
+		"a:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"a:EdDSA:" + secret[:8],
+		"a:RS256:" + secret,
+		":HS256:" + secret,
+	} {
+		if _, err := ParseKeys(s); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// Message is an email with a plain text body and an optional HTML
+// alternative.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(m *Message) error
+}
+
+// Bytes encodes the message as RFC 5322 text ready to be sent.
+func (m *Message) Bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, todo.ErrMailRecipientRequired
+	}
+	var buf bytes.Buffer
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(m.From, "@"); at >= 0 {
+		domain = strings.Trim(m.From[at+1:], ">")
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(m.Text)
+		return buf.Bytes(), nil
+	}
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	// Clients show the last alternative they understand, so HTML goes last
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SMTPMailer sends email through an SMTP server, using STARTTLS when the
+// server supports it.
+type SMTPMailer struct {
+	// Addr is the host:port of the server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailer) Send(m *Message) error {
+	if m.From == "" {
+		m.From = s.From
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, m.To, msg)
+}
+
+// FileMailer writes each email to its own .eml file in Dir, for local
+// development without a mail server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(m *Message) error {
+	if m.From == "" {
+		m.From = f.From
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Replace(m.To[0], "@", "_at_", -1))
+	return ioutil.WriteFile(filepath.Join(f.Dir, filepath.Base(name)), msg, 0644)
+}
+
+// MemoryMailer keeps sent email in memory, for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (mm *MemoryMailer) Send(m *Message) error {
+	if len(m.To) == 0 {
+		return todo.ErrMailRecipientRequired
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.messages = append(mm.messages, *m)
+	return nil
+}
+
+// Messages returns the email sent so far, oldest first.
+func (mm *MemoryMailer) Messages() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Message(nil), mm.messages...)
+}
+
+// NewMailerFromEnv returns an SMTP mailer when MAILHOST is set, a file mailer
+// when MAILDIR is set, and otherwise a mailer that keeps email in memory.
+// MAILFROM is the sender address.
+func NewMailerFromEnv() Mailer {
+	from := os.Getenv("MAILFROM")
+	if host, ok := os.LookupEnv("MAILHOST"); ok {
+		port, ok := os.LookupEnv("MAILPORT")
+		if !ok {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Addr:     host + ":" + port,
+			Username: os.Getenv("MAILUSER"),
+			Password: os.Getenv("MAILPASSWORD"),
+			From:     from,
+		}
+	}
+	if dir, ok := os.LookupEnv("MAILDIR"); ok {
+		return &FileMailer{Dir: dir, From: from}
+	}
+	return &MemoryMailer{}
+}
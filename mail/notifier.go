@@ -0,0 +1,24 @@
+package mail
+
+import (
+	"github.com/kennedymj97/todo-api"
+)
+
+var _ todo.Notifier = &ReminderNotifier{}
+
+// ReminderNotifier emails due reminders to the user who set them.
+type ReminderNotifier struct {
+	Mailer    Mailer
+	Templates *Templates
+}
+
+func (n *ReminderNotifier) Notify(r *todo.DueReminder) error {
+	if r.Email == "" {
+		return todo.ErrEmailRequired
+	}
+	m, err := n.Templates.Render(TemplateReminder, string(r.Email), r)
+	if err != nil {
+		return err
+	}
+	return n.Mailer.Send(m)
+}
@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// Queue is a Mailer that sends email in the background so callers never
+// wait on the mail server. Failed sends are retried with exponential
+// backoff. The queue is held in memory, email still queued when the
+// process exits is lost.
+type Queue struct {
+	Mailer Mailer
+	Logger *log.Logger
+	// Number of goroutines sending email.
+	Workers int
+	// Attempts before an email is dropped.
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	jobs        chan *job
+}
+
+type job struct {
+	message  *Message
+	attempts int
+}
+
+// NewQueue returns a queue holding up to size email in front of mailer.
+func NewQueue(mailer Mailer, size int) *Queue {
+	return &Queue{
+		Mailer:      mailer,
+		Logger:      log.New(os.Stderr, "", log.LstdFlags),
+		Workers:     2,
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Second,
+		MaxBackoff:  10 * time.Minute,
+		jobs:        make(chan *job, size),
+	}
+}
+
+// Send queues the email. It returns ErrMailQueueFull rather than blocking
+// when the queue is full.
+func (q *Queue) Send(m *Message) error {
+	if len(m.To) == 0 {
+		return todo.ErrMailRecipientRequired
+	}
+	return q.enqueue(&job{message: m})
+}
+
+func (q *Queue) enqueue(j *job) error {
+	select {
+	case q.jobs <- j:
+		return nil
+	default:
+		return todo.ErrMailQueueFull
+	}
+}
+
+// Run sends queued email until stop is closed.
+func (q *Queue) Run(stop <-chan struct{}) {
+	for i := 0; i < q.Workers; i++ {
+		go q.work(stop)
+	}
+	<-stop
+}
+
+func (q *Queue) work(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case j := <-q.jobs:
+			q.send(j)
+		}
+	}
+}
+
+func (q *Queue) send(j *job) {
+	err := q.Mailer.Send(j.message)
+	if err == nil {
+		return
+	}
+	j.attempts++
+	if j.attempts >= q.MaxAttempts {
+		q.Logger.Printf("mail error: %s (to=%v, giving up after %d attempts)", err, j.message.To, j.attempts)
+		return
+	}
+	q.Logger.Printf("mail error: %s (to=%v, attempt %d)", err, j.message.To, j.attempts)
+	time.AfterFunc(todo.Backoff(q.BaseBackoff, q.MaxBackoff, j.attempts), func() {
+		if err := q.enqueue(j); err != nil {
+			q.Logger.Printf("mail error: %s (to=%v, dropped)", err, j.message.To)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// flakyMailer fails the first failures sends, then sends to memory.
+type flakyMailer struct {
+	MemoryMailer
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (f *flakyMailer) Send(m *Message) error {
+	f.mu.Lock()
+	f.attempts++
+	if f.failures > 0 {
+		f.failures--
+		f.mu.Unlock()
+		return todo.Error("connection refused")
+	}
+	f.mu.Unlock()
+	return f.MemoryMailer.Send(m)
+}
+
+func (f *flakyMailer) Attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts
+}
+
+// runQueue starts a queue with short backoffs in front of mailer.
+func runQueue(t *testing.T, mailer Mailer, maxAttempts int) *Queue {
+	q := NewQueue(mailer, 10)
+	q.Logger = log.New(ioutil.Discard, "", 0)
+	q.MaxAttempts = maxAttempts
+	q.BaseBackoff = time.Millisecond
+	q.MaxBackoff = 5 * time.Millisecond
+	stop := make(chan struct{})
+	go q.Run(stop)
+	t.Cleanup(func() { close(stop) })
+	return q
+}
+
+// waitFor polls until ok returns true or a second has passed.
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	mailer := &flakyMailer{failures: 2}
+	q := runQueue(t, mailer, 5)
+	if err := q.Send(&Message{To: []string{"a@example.com"}, Subject: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(mailer.Messages()) == 1 })
+	if got := mailer.Attempts(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if got := mailer.Messages()[0]; got.Subject != "Hi" || got.To[0] != "a@example.com" {
+		t.Errorf("sent %+v", got)
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	mailer := &flakyMailer{failures: 10}
+	q := runQueue(t, mailer, 3)
+	if err := q.Send(&Message{To: []string{"a@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return mailer.Attempts() == 3 })
+	// Give it time to try again if it was going to
+	time.Sleep(20 * time.Millisecond)
+	if got := mailer.Attempts(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Error("email sent after giving up")
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// Nothing takes email off a queue that isn't running
+	q := NewQueue(&MemoryMailer{}, 1)
+	if err := q.Send(&Message{To: []string{"a@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Send(&Message{To: []string{"b@example.com"}}); err != todo.ErrMailQueueFull {
+		t.Errorf("Send() to a full queue = %v, want %v", err, todo.ErrMailQueueFull)
+	}
+	if err := q.Send(&Message{}); err != todo.ErrMailRecipientRequired {
+		t.Errorf("Send() without a recipient = %v, want %v", err, todo.ErrMailRecipientRequired)
+	}
+}
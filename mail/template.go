@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// Template renders the subject and bodies of one kind of email. The HTML
+// body is escaped for HTML, the subject and text body are not.
+type Template struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template
+	HTML    *htmltemplate.Template
+}
+
+// Templates holds the email templates by name.
+type Templates struct {
+	mu        sync.RWMutex
+	templates map[string]*Template
+}
+
+// NewTemplates returns the default templates.
+func NewTemplates() *Templates {
+	t := &Templates{templates: make(map[string]*Template)}
+	for name, tmpl := range defaultTemplates {
+		if err := t.Register(name, tmpl.subject, tmpl.text, tmpl.html); err != nil {
+			panic(err)
+		}
+	}
+	return t
+}
+
+// Register adds or replaces a template. html may be empty for text only
+// email.
+func (t *Templates) Register(name, subject, text, html string) error {
+	s, err := texttemplate.New(name + ".subject").Parse(subject)
+	if err != nil {
+		return err
+	}
+	tx, err := texttemplate.New(name + ".txt").Parse(text)
+	if err != nil {
+		return err
+	}
+	tmpl := &Template{Subject: s, Text: tx}
+	if html != "" {
+		tmpl.HTML, err = htmltemplate.New(name + ".html").Parse(html)
+		if err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.templates[name] = tmpl
+	return nil
+}
+
+// Render builds a message to the recipient from the named template.
+func (t *Templates) Render(name string, to string, data interface{}) (*Message, error) {
+	t.mu.RLock()
+	tmpl, ok := t.templates[name]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, todo.ErrMailTemplateNotFound
+	}
+	var subject, text, html bytes.Buffer
+	if err := tmpl.Subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.Text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if tmpl.HTML != nil {
+		if err := tmpl.HTML.Execute(&html, data); err != nil {
+			return nil, err
+		}
+	}
+	return &Message{
+		To:      []string{to},
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// Names of the default templates.
+const (
+	TemplateReminder      = "reminder"
+	TemplatePasswordReset = "password_reset"
+	TemplateVerifyEmail   = "verify_email"
+	TemplateEmailChanged  = "email_changed"
+)
+
+var defaultTemplates = map[string]struct{ subject, text, html string }{
+	TemplateReminder: {
+		subject: `Reminder: {{.Task.Content}}`,
+		text: `{{.Task.Content}} is due at {{.Occurrence}}.
+`,
+		html: `<p><strong>{{.Task.Content}}</strong> is due at {{.Occurrence}}.</p>
+`,
+	},
+	TemplatePasswordReset: {
+		subject: `Reset your password`,
+		text: `Someone asked to reset the password for your account. If it was you, follow this link within {{.ExpiresIn}} to choose a new password:
+
+{{.URL}}
+
+If it wasn't you, you can ignore this email.
+`,
+		html: `<p>Someone asked to reset the password for your account. If it was you, follow this link within {{.ExpiresIn}} to choose a new password:</p>
+<p><a href="{{.URL}}">Reset your password</a></p>
+<p>If it wasn't you, you can ignore this email.</p>
+`,
+	},
+	TemplateVerifyEmail: {
+		subject: `Verify your email address`,
+		text: `Follow this link within {{.ExpiresIn}} to verify your email address:
+
+{{.URL}}
+`,
+		html: `<p>Follow this link within {{.ExpiresIn}} to verify your email address:</p>
+<p><a href="{{.URL}}">Verify your email address</a></p>
+`,
+	},
+	TemplateEmailChanged: {
+		subject: `Your email address is being changed`,
+		text: `Someone asked to change the email address of your account to {{.Email}}. The change happens once the new address has been verified.
+
+If it wasn't you, reset your password straight away:
+
+{{.URL}}
+`,
+		html: `<p>Someone asked to change the email address of your account to <strong>{{.Email}}</strong>. The change happens once the new address has been verified.</p>
+<p>If it wasn't you, <a href="{{.URL}}">reset your password</a> straight away.</p>
+`,
+	},
+}
@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+func TestRenderDefaults(t *testing.T) {
+	templates := NewTemplates()
+	m, err := templates.Render(TemplatePasswordReset, "a@example.com", map[string]string{
+		"URL":       "https://app.example.com/password/reset?token=abc&x=1",
+		"ExpiresIn": "1 hour",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Reset your password" || len(m.To) != 1 || m.To[0] != "a@example.com" {
+		t.Errorf("message = %+v", m)
+	}
+	if !strings.Contains(m.Text, "within 1 hour") || !strings.Contains(m.Text, "https://app.example.com/password/reset?token=abc&x=1\n") {
+		t.Errorf("text = %q", m.Text)
+	}
+	if !strings.Contains(m.HTML, `<a href="https://app.example.com/password/reset?token=abc&amp;x=1">`) {
+		t.Errorf("html = %q", m.HTML)
+	}
+
+	for _, name := range []string{TemplateReminder, TemplateVerifyEmail, TemplateEmailChanged} {
+		if _, err := templates.Render(name, "a@example.com", map[string]interface{}{}); err != nil {
+			t.Errorf("Render(%s) = %v", name, err)
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	templates := NewTemplates()
+	if err := templates.Register("hello", "Hi {{.}}", "Hi {{.}}", "<p>Hi {{.}}</p>"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := templates.Render("hello", "a@example.com", "<b>Ann</b>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the HTML body is HTML
+	if m.Subject != "Hi <b>Ann</b>" || m.Text != "Hi <b>Ann</b>" || m.HTML != "<p>Hi &lt;b&gt;Ann&lt;/b&gt;</p>" {
+		t.Errorf("message = %+v", m)
+	}
+}
+
+func TestRenderTextOnly(t *testing.T) {
+	templates := NewTemplates()
+	if err := templates.Register("plain", "Subject", "Body", ""); err != nil {
+		t.Fatal(err)
+	}
+	m, err := templates.Render("plain", "a@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HTML != "" {
+		t.Errorf("html = %q, want none", m.HTML)
+	}
+	b, err := m.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Content-Type: text/plain; charset=utf-8\r\n\r\nBody") {
+		t.Errorf("message = %q", b)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	templates := NewTemplates()
+	if _, err := templates.Render("missing", "a@example.com", nil); err != todo.ErrMailTemplateNotFound {
+		t.Errorf("Render(missing) = %v, want %v", err, todo.ErrMailTemplateNotFound)
+	}
+	if err := templates.Register("broken", "{{.", "", ""); err == nil {
+		t.Error("Register() with a broken template = nil, want an error")
+	}
+}
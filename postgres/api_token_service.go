@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.APITokenService = &APITokenService{}
+
+type APITokenService struct {
+	client *Client
+}
+
+func (s *APITokenService) APITokens(userID todo.UserID) (*todo.APITokens, error) {
+	rows, err := s.client.db.Query(`SELECT tokenID, name, scopes,
+	COALESCE(to_char(expiresAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+	COALESCE(to_char(lastUsedAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), timestamp
+	FROM todo.apiTokens WHERE userID=$1 ORDER BY timestamp`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := todo.APITokens{}
+	for rows.Next() {
+		var t todo.APIToken
+		if err := rows.Scan(&t.ID, &t.Name, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.Timestamp); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return &tokens, rows.Err()
+}
+
+func (s *APITokenService) CreateAPIToken(t *todo.APIToken, tokenHash string, userID todo.UserID) (todo.APITokenID, error) {
+	if FormatInput(userID) == "" {
+		return "", todo.ErrUserIDRequired
+	} else if FormatInput(t.Name) == "" {
+		return "", todo.ErrAPITokenNameRequired
+	}
+	expiresAt, err := validateAPIToken(t)
+	if err != nil {
+		return "", err
+	}
+	var id todo.APITokenID
+	row := s.client.db.QueryRow(`INSERT INTO todo.apiTokens(userID, name, tokenHash, scopes, expiresAt)
+	VALUES($1, $2, $3, $4, $5) RETURNING tokenID`, userID, t.Name, tokenHash, pq.Array(t.Scopes), expiresAt)
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *APITokenService) DeleteAPIToken(id todo.APITokenID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrAPITokenIDRequired
+	}
+	res, err := s.client.db.Exec("DELETE FROM todo.apiTokens WHERE tokenID::text=$1 AND userID=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrAPITokenNotFound)
+}
+
+// AuthenticateAPIToken also records when the token was last used, at most
+// once a minute.
+func (s *APITokenService) AuthenticateAPIToken(tokenHash string) (todo.UserID, []string, error) {
+	var userID todo.UserID
+	var scopes []string
+	row := s.client.db.QueryRow(`SELECT userID, scopes FROM todo.apiTokens
+	WHERE tokenHash=$1 AND (expiresAt IS NULL OR expiresAt > current_timestamp)`, tokenHash)
+	if err := row.Scan(&userID, pq.Array(&scopes)); err == sql.ErrNoRows {
+		return "", nil, todo.ErrUnauthorized
+	} else if err != nil {
+		return "", nil, err
+	}
+	_, err := s.client.db.Exec(`UPDATE todo.apiTokens SET lastUsedAt=current_timestamp
+	WHERE tokenHash=$1 AND (lastUsedAt IS NULL OR lastUsedAt < current_timestamp - interval '1 minute')`, tokenHash)
+	if err != nil {
+		return "", nil, err
+	}
+	return userID, scopes, nil
+}
+
+// validateAPIToken checks the token's scopes and returns its expiry, nil
+// when it doesn't expire.
+func validateAPIToken(t *todo.APIToken) (*time.Time, error) {
+	if len(t.Scopes) == 0 {
+		return nil, todo.ErrScopesRequired
+	}
+	for _, scope := range t.Scopes {
+		known := false
+		for _, s := range todo.Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, todo.ErrScopeUnknown
+		}
+	}
+	if t.ExpiresAt == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, t.ExpiresAt)
+	if err != nil || !expiresAt.After(time.Now()) {
+		return nil, todo.ErrAPITokenExpiry
+	}
+	return &expiresAt, nil
+}
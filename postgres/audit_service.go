@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+var _ todo.AuditService = &AuditService{}
+
+type AuditService struct {
+	client *Client
+}
+
+// AuditLog returns the newest entries first. Passing the ID of the last
+// entry returned as before gets the next page, zero starts from the newest.
+func (s *AuditService) AuditLog(before int64, limit int) (*todo.AuditLog, error) {
+	rows, err := s.client.db.Query(`SELECT auditID, action, COALESCE(actorID::text, ''), subject, ip, timestamp
+	FROM todo.auditLog WHERE $1=0 OR auditID < $1 ORDER BY auditID DESC LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	log := todo.AuditLog{}
+	for rows.Next() {
+		var e todo.AuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.ActorID, &e.Subject, &e.IP, &e.Timestamp); err != nil {
+			return nil, err
+		}
+		log = append(log, e)
+	}
+	return &log, rows.Err()
+}
+
+// writeAudit records an action in the same transaction as the change it
+// describes.
+func writeAudit(tx *sql.Tx, action todo.AuditAction, actorID todo.UserID, subject string, ip string) error {
+	_, err := tx.Exec("INSERT INTO todo.auditLog(action, actorID, subject, ip) VALUES($1, $2, $3, $4)",
+		action, nullable(string(actorID)), subject, ip)
+	return err
+}
@@ -13,21 +13,58 @@ import (
 )
 
 type Client struct {
-	db          *sql.DB
-	taskService TaskService
-	userService UserService
+	db                  *sql.DB
+	taskService         TaskService
+	userService         UserService
+	projectService      ProjectService
+	webhookService      WebhookService
+	commentService      CommentService
+	notificationService NotificationService
+	reminderService     ReminderService
+	inAppNotifier       InAppNotifier
+	mfaService          MFAService
+	loginAttemptService LoginAttemptService
+	auditService        AuditService
+	apiTokenService     APITokenService
+	refreshTokenService RefreshTokenService
+	oauthService        OAuthService
+	idempotencyService  IdempotencyService
+	dispatcher          EventDispatcher
+	listener            EventListener
+	// dsn is the connection string, for connections outside of db.
+	dsn string
 }
 
 func NewClient() *Client {
 	c := &Client{}
 	c.taskService.client = c
 	c.userService.client = c
+	c.projectService.client = c
+	c.webhookService.client = c
+	c.commentService.client = c
+	c.notificationService.client = c
+	c.reminderService.client = c
+	c.inAppNotifier.client = c
+	c.mfaService.client = c
+	c.loginAttemptService.client = c
+	c.loginAttemptService.AccountPolicy = DefaultAccountPolicy
+	c.loginAttemptService.IPPolicy = DefaultIPPolicy
+	c.auditService.client = c
+	c.apiTokenService.client = c
+	c.refreshTokenService.client = c
+	c.oauthService.client = c
+	c.idempotencyService.client = c
+	c.dispatcher.client = c
+	c.dispatcher.init()
+	c.listener.client = c
+	c.listener.init()
 	return c
 }
 
 func (c *Client) Open() error {
+	// The settings can come from the environment instead of a .env file
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
@@ -69,6 +106,8 @@ func (c *Client) Open() error {
 	newTaskTable := `CREATE TABLE IF NOT EXISTS todo.tasks( 
 	taskID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
 	userID UUID NOT NULL,
+	projectID UUID,
+	assigneeID UUID,
 	content TEXT NOT NULL, 
 	completed BOOL NOT NULL DEFAULT false, 
 	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
@@ -84,11 +123,253 @@ func (c *Client) Open() error {
 	userID UUID NOT NULL,
 	expiryTime TEXT NOT NULL 
 	);`
+	newPasswordResetTable := `CREATE TABLE IF NOT EXISTS todo.passwordResets(
+	tokenHash TEXT PRIMARY KEY,
+	userID UUID NOT NULL,
+	expiresAt TIMESTAMPTZ NOT NULL,
+	usedAt TIMESTAMPTZ,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newRecoveryCodeTable := `CREATE TABLE IF NOT EXISTS todo.recoveryCodes(
+	userID UUID NOT NULL,
+	codeHash TEXT NOT NULL,
+	usedAt TIMESTAMPTZ,
+	PRIMARY KEY (userID, codeHash)
+	);`
+	newMFAChallengeTable := `CREATE TABLE IF NOT EXISTS todo.mfaChallenges(
+	challengeHash TEXT PRIMARY KEY,
+	userID UUID NOT NULL,
+	expiresAt TIMESTAMPTZ NOT NULL,
+	attempts INT NOT NULL DEFAULT 0
+	);`
+	newLoginAttemptTable := `CREATE TABLE IF NOT EXISTS todo.loginAttempts(
+	kind TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INT NOT NULL DEFAULT 0,
+	lastFailure TIMESTAMPTZ NOT NULL,
+	lockedUntil TIMESTAMPTZ,
+	PRIMARY KEY (kind, subject)
+	);`
+	newAuditLogTable := `CREATE TABLE IF NOT EXISTS todo.auditLog(
+	auditID BIGSERIAL PRIMARY KEY,
+	action TEXT NOT NULL,
+	actorID UUID,
+	subject TEXT NOT NULL,
+	ip TEXT NOT NULL DEFAULT '',
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newAPITokenTable := `CREATE TABLE IF NOT EXISTS todo.apiTokens(
+	tokenID UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	userID UUID NOT NULL,
+	name TEXT NOT NULL,
+	tokenHash TEXT NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL,
+	expiresAt TIMESTAMPTZ,
+	lastUsedAt TIMESTAMPTZ,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newRefreshTokenTable := `CREATE TABLE IF NOT EXISTS todo.refreshTokens(
+	tokenHash TEXT PRIMARY KEY,
+	familyID UUID NOT NULL,
+	userID UUID NOT NULL,
+	expiresAt TIMESTAMPTZ NOT NULL,
+	usedAt TIMESTAMPTZ,
+	revokedAt TIMESTAMPTZ,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newOAuthClientTable := `CREATE TABLE IF NOT EXISTS todo.oauthClients(
+	clientID UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	ownerID UUID NOT NULL,
+	name TEXT NOT NULL,
+	redirectURIs TEXT[] NOT NULL,
+	secretHash TEXT,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newOAuthCodeTable := `CREATE TABLE IF NOT EXISTS todo.oauthCodes(
+	codeHash TEXT PRIMARY KEY,
+	clientID UUID NOT NULL,
+	userID UUID NOT NULL,
+	redirectURI TEXT NOT NULL,
+	scopes TEXT[] NOT NULL,
+	codeChallenge TEXT NOT NULL,
+	expiresAt TIMESTAMPTZ NOT NULL,
+	tokenID UUID
+	);`
+	newOAuthTokenTable := `CREATE TABLE IF NOT EXISTS todo.oauthTokens(
+	tokenID UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	clientID UUID NOT NULL,
+	userID UUID NOT NULL,
+	scopes TEXT[] NOT NULL,
+	accessHash TEXT NOT NULL UNIQUE,
+	accessExpiresAt TIMESTAMPTZ NOT NULL,
+	refreshHash TEXT NOT NULL UNIQUE,
+	refreshExpiresAt TIMESTAMPTZ NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newIdempotencyKeyTable := `CREATE TABLE IF NOT EXISTS todo.idempotencyKeys(
+	userID UUID NOT NULL,
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	status INTEGER,
+	location TEXT,
+	body BYTEA,
+	expiresAt TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (userID, key)
+	);`
+	newProjectTable := `CREATE TABLE IF NOT EXISTS todo.projects(
+	projectID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	name TEXT NOT NULL,
+	ownerID UUID NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newProjectMemberTable := `CREATE TABLE IF NOT EXISTS todo.projectMembers(
+	projectID UUID NOT NULL,
+	userID UUID NOT NULL,
+	role TEXT NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY (projectID, userID)
+	);`
+	newInvitationTable := `CREATE TABLE IF NOT EXISTS todo.invitations(
+	invitationID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	projectID UUID NOT NULL,
+	email TEXT NOT NULL,
+	role TEXT NOT NULL,
+	invitedBy UUID NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp,
+	UNIQUE (projectID, email)
+	);`
+	newWebhookTable := `CREATE TABLE IF NOT EXISTS todo.webhooks(
+	webhookID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	userID UUID NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT[] NOT NULL,
+	secret TEXT NOT NULL,
+	active BOOL NOT NULL DEFAULT true,
+	failures INT NOT NULL DEFAULT 0,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newWebhookDeliveryTable := `CREATE TABLE IF NOT EXISTS todo.webhookDeliveries(
+	deliveryID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	webhookID UUID NOT NULL,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	responseCode INT,
+	lastError TEXT,
+	nextAttempt TIMESTAMP NOT NULL DEFAULT current_timestamp,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newOutboxTable := `CREATE TABLE IF NOT EXISTS todo.outbox(
+	eventID BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	userID UUID NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	lastError TEXT,
+	deliveredAt TIMESTAMP,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newCommentTable := `CREATE TABLE IF NOT EXISTS todo.comments(
+	commentID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	taskID UUID NOT NULL REFERENCES todo.tasks(taskID) ON DELETE CASCADE,
+	userID UUID NOT NULL,
+	body TEXT NOT NULL,
+	mentions UUID[] NOT NULL DEFAULT '{}',
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp,
+	editedAt TIMESTAMP
+	);`
+	newNotificationTable := `CREATE TABLE IF NOT EXISTS todo.notifications(
+	notificationID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	eventID BIGINT UNIQUE,
+	userID UUID NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	readAt TIMESTAMP,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newReminderTable := `CREATE TABLE IF NOT EXISTS todo.reminders(
+	reminderID UUID PRIMARY KEY DEFAULT uuid_generate_v1(),
+	taskID UUID NOT NULL REFERENCES todo.tasks(taskID) ON DELETE CASCADE,
+	userID UUID NOT NULL,
+	minutesBefore INT NOT NULL DEFAULT 0,
+	atTime TEXT NOT NULL DEFAULT '',
+	timezone TEXT NOT NULL DEFAULT '',
+	channels TEXT[] NOT NULL,
+	fireAt TIMESTAMPTZ,
+	occurrence TIMESTAMPTZ,
+	timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
+	);`
+	newNotificationPreferenceTable := `CREATE TABLE IF NOT EXISTS todo.notificationPreferences(
+	userID UUID NOT NULL,
+	type TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL,
+	PRIMARY KEY (userID, type)
+	);`
 	db.Exec(newTaskTable)
 	db.Exec(newUserTable)
 	db.Exec(newUserSessionTable)
+	db.Exec("ALTER TABLE todo.userSessions ADD COLUMN IF NOT EXISTS tokenHash TEXT;")
+	db.Exec("ALTER TABLE todo.userSessions ADD COLUMN IF NOT EXISTS createdAt TIMESTAMPTZ NOT NULL DEFAULT current_timestamp;")
+	db.Exec("ALTER TABLE todo.userSessions ADD COLUMN IF NOT EXISTS lastSeen TIMESTAMPTZ NOT NULL DEFAULT current_timestamp;")
+	db.Exec("ALTER TABLE todo.userSessions ADD COLUMN IF NOT EXISTS userAgent TEXT NOT NULL DEFAULT '';")
+	db.Exec("ALTER TABLE todo.userSessions ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';")
+	// Sessions from before cookies were hashed used their ID as the cookie,
+	// which is listed by GET /api/users/sessions, so they get a new ID
+	db.Exec(`UPDATE todo.userSessions SET tokenHash=encode(sha256(convert_to(sessionID::text, 'UTF8')), 'hex'),
+	sessionID=uuid_generate_v4() WHERE tokenHash IS NULL;`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS userSessions_token ON todo.userSessions(tokenHash);")
+	db.Exec(newPasswordResetTable)
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS verifiedAt TIMESTAMPTZ;")
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS pendingEmail TEXT;")
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS totpSecret TEXT;")
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS totpEnabled BOOLEAN NOT NULL DEFAULT false;")
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS totpLastStep BIGINT NOT NULL DEFAULT 0;")
+	db.Exec(newRecoveryCodeTable)
+	db.Exec(newMFAChallengeTable)
+	db.Exec("ALTER TABLE todo.users ADD COLUMN IF NOT EXISTS admin BOOLEAN NOT NULL DEFAULT false;")
+	db.Exec(newLoginAttemptTable)
+	db.Exec(newAuditLogTable)
+	db.Exec(newAPITokenTable)
+	db.Exec(newRefreshTokenTable)
+	db.Exec("CREATE INDEX IF NOT EXISTS refreshTokens_family ON todo.refreshTokens(familyID);")
+	db.Exec(newOAuthClientTable)
+	db.Exec(newOAuthCodeTable)
+	db.Exec("ALTER TABLE todo.oauthCodes ADD COLUMN IF NOT EXISTS redirectURIExplicit BOOL NOT NULL DEFAULT true;")
+	db.Exec(newOAuthTokenTable)
+	db.Exec(newIdempotencyKeyTable)
+	db.Exec("CREATE INDEX IF NOT EXISTS idempotencyKeys_expiry ON todo.idempotencyKeys(expiresAt);")
+	db.Exec("ALTER TABLE todo.idempotencyKeys ADD COLUMN IF NOT EXISTS contentType TEXT;")
+	db.Exec(newProjectTable)
+	db.Exec(newProjectMemberTable)
+	db.Exec(newInvitationTable)
+	db.Exec("ALTER TABLE todo.tasks ADD COLUMN IF NOT EXISTS projectID UUID;")
+	db.Exec("ALTER TABLE todo.tasks ADD COLUMN IF NOT EXISTS assigneeID UUID;")
+	db.Exec("CREATE INDEX IF NOT EXISTS tasks_project ON todo.tasks(projectID);")
+	db.Exec("CREATE INDEX IF NOT EXISTS tasks_assignee ON todo.tasks(assigneeID);")
+	db.Exec("CREATE INDEX IF NOT EXISTS projectMembers_user ON todo.projectMembers(userID);")
+	db.Exec(newWebhookTable)
+	db.Exec(newWebhookDeliveryTable)
+	db.Exec(newOutboxTable)
+	db.Exec("ALTER TABLE todo.outbox ADD COLUMN IF NOT EXISTS deliveredTo TEXT[] NOT NULL DEFAULT '{}';")
+	db.Exec("ALTER TABLE todo.outbox ADD COLUMN IF NOT EXISTS claimedUntil TIMESTAMP;")
+	db.Exec("CREATE INDEX IF NOT EXISTS outbox_undelivered ON todo.outbox(eventID) WHERE deliveredAt IS NULL;")
+	db.Exec("CREATE INDEX IF NOT EXISTS webhookDeliveries_due ON todo.webhookDeliveries(nextAttempt) WHERE status='pending';")
+	db.Exec(newCommentTable)
+	db.Exec("CREATE INDEX IF NOT EXISTS comments_task ON todo.comments(taskID);")
+	db.Exec(newNotificationTable)
+	db.Exec(newNotificationPreferenceTable)
+	db.Exec("CREATE INDEX IF NOT EXISTS notifications_user ON todo.notifications(userID, timestamp);")
+	// Reminders aren't outbox events so their notifications have no eventID
+	db.Exec("ALTER TABLE todo.notifications ALTER COLUMN eventID DROP NOT NULL;")
+	db.Exec("ALTER TABLE todo.tasks ADD COLUMN IF NOT EXISTS dueAt TIMESTAMPTZ;")
+	db.Exec("ALTER TABLE todo.tasks ADD COLUMN IF NOT EXISTS recurrence TEXT NOT NULL DEFAULT '';")
+	db.Exec(newReminderTable)
+	db.Exec("CREATE INDEX IF NOT EXISTS reminders_due ON todo.reminders(fireAt) WHERE fireAt IS NOT NULL;")
+	db.Exec("CREATE INDEX IF NOT EXISTS reminders_task ON todo.reminders(taskID);")
 
 	c.db = db
+	c.dsn = psqlInfo
 
 	fmt.Println("Succesfully connected to database")
 	return nil
@@ -105,6 +386,37 @@ func (c *Client) TaskService() todo.TaskService { return &c.taskService }
 
 func (c *Client) UserService() todo.UserService { return &c.userService }
 
+func (c *Client) ProjectService() todo.ProjectService { return &c.projectService }
+
+func (c *Client) WebhookService() todo.WebhookService { return &c.webhookService }
+
+func (c *Client) CommentService() todo.CommentService { return &c.commentService }
+
+func (c *Client) NotificationService() todo.NotificationService { return &c.notificationService }
+
+func (c *Client) ReminderService() todo.ReminderService { return &c.reminderService }
+
+// InAppNotifier sends reminders to the notification inbox.
+func (c *Client) InAppNotifier() todo.Notifier { return &c.inAppNotifier }
+
+func (c *Client) MFAService() todo.MFAService { return &c.mfaService }
+
+func (c *Client) LoginAttemptService() todo.LoginAttemptService { return &c.loginAttemptService }
+
+func (c *Client) AuditService() todo.AuditService { return &c.auditService }
+
+func (c *Client) APITokenService() todo.APITokenService { return &c.apiTokenService }
+
+func (c *Client) RefreshTokenService() todo.RefreshTokenService { return &c.refreshTokenService }
+
+func (c *Client) OAuthService() todo.OAuthService { return &c.oauthService }
+
+func (c *Client) IdempotencyService() todo.IdempotencyService { return &c.idempotencyService }
+
+func (c *Client) Dispatcher() *EventDispatcher { return &c.dispatcher }
+
+func (c *Client) Listener() *EventListener { return &c.listener }
+
 func FormatInput(input interface{}) string {
 	s := reflect.ValueOf(input).String()
 	return strings.TrimSpace(s)
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// openTestClient connects to the database in the DBHOST, DBPORT, DBUSER,
+// DBPASSWORD and DBNAME settings. Tests are skipped without one, they
+// write to it so it shouldn't be one that matters.
+func openTestClient(t *testing.T) *Client {
+	t.Helper()
+	if os.Getenv("DBHOST") == "" {
+		t.Skip("DBHOST not set, skipping database test")
+	}
+	c := NewClient()
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// createTestUser creates a user with an email no other test uses.
+func createTestUser(t *testing.T, c *Client) (todo.UserID, todo.Email) {
+	t.Helper()
+	b := make([]byte, 8)
+	rand.Read(b)
+	email := todo.Email("test-" + hex.EncodeToString(b) + "@example.com")
+	if err := c.UserService().CreateUser(email, "not a hash"); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := c.UserService().User(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, email
+}
+
+// newTestID returns a random uuid for the ids clients choose, like task ids.
+func newTestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
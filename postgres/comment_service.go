@@ -0,0 +1,310 @@
+package postgres
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.CommentService = &CommentService{}
+
+// maxCommentLength is the longest comment body accepted, in characters.
+const maxCommentLength = 10000
+
+type CommentService struct {
+	client *Client
+}
+
+// Event payload written to the outbox for comments and mentions.
+type commentPayload struct {
+	ID        todo.CommentID `json:"id"`
+	TaskID    todo.TaskID    `json:"taskId"`
+	ProjectID todo.ProjectID `json:"projectId,omitempty"`
+	AuthorID  todo.UserID    `json:"authorId"`
+}
+
+func (s *CommentService) Comments(taskID todo.TaskID, userID todo.UserID) (*todo.Comments, error) {
+	if FormatInput(taskID) == "" {
+		return nil, todo.ErrTaskIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	if _, _, err := taskAccess(tx, taskID, userID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT commentID, taskID, userID, body, mentions, timestamp, COALESCE(editedAt::text, '') FROM todo.comments
+	WHERE taskID=$1 ORDER BY timestamp`, taskID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	comments := todo.Comments{}
+	for rows.Next() {
+		var c todo.Comment
+		var mentions []string
+		if err := rows.Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Body, pq.Array(&mentions), &c.Timestamp, &c.EditedAt); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		c.Mentions = []todo.UserID{}
+		for _, m := range mentions {
+			c.Mentions = append(c.Mentions, todo.UserID(m))
+		}
+		comments = append(comments, c)
+	}
+	return &comments, nil
+}
+
+func (s *CommentService) CreateComment(taskID todo.TaskID, body string, userID todo.UserID) (todo.CommentID, error) {
+	if FormatInput(taskID) == "" {
+		return "", todo.ErrTaskIDRequired
+	} else if err := validateCommentBody(body); err != nil {
+		return "", err
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	projectID, role, err := taskAccess(tx, taskID, userID)
+	if err != nil {
+		return "", err
+	} else if !role.AtLeast(todo.RoleCommenter) {
+		return "", todo.ErrPermissionDenied
+	}
+	mentions, err := resolveMentions(tx, projectID, userID, body)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	var id todo.CommentID
+	row := tx.QueryRow("INSERT INTO todo.comments(taskID, userID, body, mentions) VALUES($1, $2, $3, $4) RETURNING commentID", taskID, userID, body, pq.Array(mentions))
+	if err := row.Scan(&id); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	payload := &commentPayload{ID: id, TaskID: taskID, ProjectID: projectID, AuthorID: userID}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	err = writeEvents(tx, todo.EventCommentCreated, userIDs, payload)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	err = writeEvents(tx, todo.EventMentioned, withoutUser(mentions, userID), payload)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return id, nil
+}
+
+// EditComment replaces the body of one of the user's own comments. Users
+// mentioned for the first time by the edit are notified.
+func (s *CommentService) EditComment(id todo.CommentID, body string, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrCommentIDRequired
+	} else if err := validateCommentBody(body); err != nil {
+		return err
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	c, projectID, role, err := commentAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if c.AuthorID != userID || !role.AtLeast(todo.RoleCommenter) {
+		return todo.ErrPermissionDenied
+	}
+	mentions, err := resolveMentions(tx, projectID, userID, body)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.comments SET body=$2, mentions=$3, editedAt=current_timestamp WHERE commentID=$1", id, body, pq.Array(mentions))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	payload := &commentPayload{ID: id, TaskID: c.TaskID, ProjectID: projectID, AuthorID: userID}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventCommentEdited, userIDs, payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var added []todo.UserID
+	for _, m := range withoutUser(mentions, userID) {
+		if !containsUser(c.Mentions, m) {
+			added = append(added, m)
+		}
+	}
+	err = writeEvents(tx, todo.EventMentioned, added, payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// DeleteComment deletes one of the user's own comments. Project owners can
+// delete any comment in their project.
+func (s *CommentService) DeleteComment(id todo.CommentID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrCommentIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	c, projectID, role, err := commentAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if c.AuthorID != userID && role != todo.RoleOwner {
+		return todo.ErrPermissionDenied
+	}
+	_, err = tx.Exec("DELETE FROM todo.comments WHERE commentID=$1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventCommentDeleted, userIDs, &commentPayload{ID: id, TaskID: c.TaskID, ProjectID: projectID, AuthorID: c.AuthorID})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// commentAccess loads a comment along with the project of its task and the
+// user's role in it. Comments on tasks the user can't see are not found.
+func commentAccess(tx *sql.Tx, id todo.CommentID, userID todo.UserID) (*todo.Comment, todo.ProjectID, todo.Role, error) {
+	var c todo.Comment
+	var mentions []string
+	row := tx.QueryRow("SELECT commentID, taskID, userID, mentions FROM todo.comments WHERE commentID=$1", id)
+	if err := row.Scan(&c.ID, &c.TaskID, &c.AuthorID, pq.Array(&mentions)); err == sql.ErrNoRows {
+		return nil, "", "", todo.ErrCommentNotFound
+	} else if err != nil {
+		return nil, "", "", err
+	}
+	for _, m := range mentions {
+		c.Mentions = append(c.Mentions, todo.UserID(m))
+	}
+	projectID, role, err := taskAccess(tx, c.TaskID, userID)
+	if err == todo.ErrTaskNotFound {
+		return nil, "", "", todo.ErrCommentNotFound
+	} else if err != nil {
+		return nil, "", "", err
+	}
+	return &c, projectID, role, nil
+}
+
+func validateCommentBody(body string) error {
+	if FormatInput(body) == "" {
+		return todo.ErrCommentBodyRequired
+	} else if utf8.RuneCountInString(body) > maxCommentLength {
+		return todo.ErrCommentTooLong
+	}
+	return nil
+}
+
+// mentionPattern matches @email and @name. A name is matched against the
+// part of a member's email before the @.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.+-]+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+
+// parseMentions returns the lower cased email addresses and names mentioned
+// in a comment body.
+func parseMentions(body string) []string {
+	var mentions []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		// Full stops at the end of a sentence aren't part of the mention
+		mention := strings.ToLower(strings.TrimRight(m[1], "."))
+		if mention != "" {
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions
+}
+
+// resolveMentions returns the project members mentioned in the body. For a
+// task outside of any project only the owner can be mentioned.
+func resolveMentions(tx *sql.Tx, projectID todo.ProjectID, userID todo.UserID, body string) ([]string, error) {
+	mentions := parseMentions(body)
+	if len(mentions) == 0 {
+		return []string{}, nil
+	}
+	var rows *sql.Rows
+	var err error
+	if projectID == "" {
+		rows, err = tx.Query("SELECT userID, email FROM todo.users WHERE userID=$1", userID)
+	} else {
+		rows, err = tx.Query(`SELECT u.userID, u.email FROM todo.projectMembers m
+		JOIN todo.users u ON u.userID=m.userID WHERE m.projectID=$1`, projectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	userIDs := []string{}
+	for rows.Next() {
+		var id string
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+		email = strings.ToLower(email)
+		name := email
+		if at := strings.Index(email, "@"); at >= 0 {
+			name = email[:at]
+		}
+		for _, mention := range mentions {
+			if mention == email || mention == name {
+				userIDs = append(userIDs, id)
+				break
+			}
+		}
+	}
+	return userIDs, rows.Err()
+}
+
+func withoutUser(userIDs []string, userID todo.UserID) []todo.UserID {
+	var others []todo.UserID
+	for _, id := range userIDs {
+		if todo.UserID(id) != userID {
+			others = append(others, todo.UserID(id))
+		}
+	}
+	return others
+}
+
+func containsUser(userIDs []todo.UserID, userID todo.UserID) bool {
+	for _, id := range userIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+var _ todo.IdempotencyService = &IdempotencyService{}
+
+type IdempotencyService struct {
+	client *Client
+}
+
+// StartIdempotentRequest also clears out expired keys. A key whose request
+// never finished, because the server stopped, is stuck in progress until it
+// expires.
+func (s *IdempotencyService) StartIdempotentRequest(userID todo.UserID, key string, fingerprint string, expiresAt time.Time) (*todo.IdempotentResponse, error) {
+	if FormatInput(userID) == "" {
+		return nil, todo.ErrUserIDRequired
+	} else if key == "" {
+		return nil, todo.ErrIdempotencyKeyInvalid
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM todo.idempotencyKeys WHERE expiresAt <= current_timestamp"); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	res, err := tx.Exec(`INSERT INTO todo.idempotencyKeys(userID, key, fingerprint, expiresAt)
+	VALUES($1, $2, $3, $4) ON CONFLICT DO NOTHING`, userID, key, fingerprint, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if n == 1 {
+		return nil, tx.Commit()
+	}
+	var storedFingerprint string
+	var status sql.NullInt64
+	var location, contentType sql.NullString
+	var body []byte
+	row := tx.QueryRow(`SELECT fingerprint, status, location, contentType, body FROM todo.idempotencyKeys
+	WHERE userID=$1 AND key=$2`, userID, key)
+	if err := row.Scan(&storedFingerprint, &status, &location, &contentType, &body); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if storedFingerprint != fingerprint {
+		return nil, todo.ErrIdempotencyKeyReused
+	} else if !status.Valid {
+		return nil, todo.ErrIdempotencyKeyInProgress
+	}
+	return &todo.IdempotentResponse{
+		Status:      int(status.Int64),
+		Location:    location.String,
+		ContentType: contentType.String,
+		Body:        body,
+	}, nil
+}
+
+func (s *IdempotencyService) SaveIdempotentResponse(userID todo.UserID, key string, res *todo.IdempotentResponse) error {
+	_, err := s.client.db.Exec(`UPDATE todo.idempotencyKeys SET status=$3, location=$4, contentType=$5, body=$6
+	WHERE userID=$1 AND key=$2`, userID, key, res.Status, nullable(res.Location), nullable(res.ContentType), res.Body)
+	return err
+}
+
+func (s *IdempotencyService) ReleaseIdempotencyKey(userID todo.UserID, key string) error {
+	_, err := s.client.db.Exec("DELETE FROM todo.idempotencyKeys WHERE userID=$1 AND key=$2", userID, key)
+	return err
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+// EventListener publishes every event to the handlers on every api replica,
+// for things each replica needs to hear about, like the websocket clients
+// connected to it. Events are published once, after the change that caused
+// them commits. Unlike the outbox nothing is retried: an event that fails
+// or is sent while the listener is reconnecting is missed.
+type EventListener struct {
+	client   *Client
+	mu       sync.RWMutex
+	handlers []todo.EventHandler
+	Logger   *log.Logger
+}
+
+func (l *EventListener) init() {
+	l.Logger = log.New(os.Stderr, "", log.LstdFlags)
+}
+
+// Subscribe registers a handler for every event. It should be called before
+// Run.
+func (l *EventListener) Subscribe(h todo.EventHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers = append(l.handlers, h)
+}
+
+// Run listens for events until stop is closed. The client must be open.
+func (l *EventListener) Run(stop <-chan struct{}) {
+	listener := pq.NewListener(l.client.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.Logger.Printf("listener error: %s", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(eventChannel); err != nil {
+		l.Logger.Printf("listener error: %s", err)
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case n := <-listener.Notify:
+			// nil after the connection is re-established
+			if n != nil {
+				l.publish(n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			// Notice a dead connection even when nothing is happening
+			go listener.Ping()
+		}
+	}
+}
+
+// publish loads the event with the id and hands it to every handler.
+func (l *EventListener) publish(id string) {
+	e, err := l.event(todo.EventID(id))
+	if err != nil {
+		l.Logger.Printf("listener error: %s (event=%s)", err, id)
+		return
+	}
+	l.mu.RLock()
+	handlers := l.handlers
+	l.mu.RUnlock()
+	for _, h := range handlers {
+		if err := callHandler(h, *e); err != nil {
+			l.Logger.Printf("listener error: %s (event=%s)", err, id)
+		}
+	}
+}
+
+func (l *EventListener) event(id todo.EventID) (*todo.Event, error) {
+	tx, err := l.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	var e todo.Event
+	var payload string
+	row := tx.QueryRow("SELECT eventID, type, userID, payload, timestamp FROM todo.outbox WHERE eventID=$1", id)
+	if err := row.Scan(&e.ID, &e.Type, &e.UserID, &payload, &e.Timestamp); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	e.Payload = json.RawMessage(payload)
+	return &e, nil
+}
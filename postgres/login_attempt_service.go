@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.LoginAttemptService = &LoginAttemptService{}
+
+// DefaultAccountPolicy slows down guessing one account's password.
+var DefaultAccountPolicy = todo.LoginPolicy{
+	Attempts:   5,
+	Backoff:    30 * time.Second,
+	MaxBackoff: time.Hour,
+	Window:     24 * time.Hour,
+}
+
+// DefaultIPPolicy slows down one address trying passwords against many
+// accounts. It allows more failures since many users can share an address.
+var DefaultIPPolicy = todo.LoginPolicy{
+	Attempts:   50,
+	Backoff:    time.Minute,
+	MaxBackoff: time.Hour,
+	Window:     time.Hour,
+}
+
+type LoginAttemptService struct {
+	client        *Client
+	AccountPolicy todo.LoginPolicy
+	IPPolicy      todo.LoginPolicy
+}
+
+// LoginAttempt counts the attempt against both the account and the IP
+// address, locking either out once its policy says so. The rows are locked
+// while they are checked and counted, so parallel attempts can't all get
+// in before the lockout. The account's row is always locked first.
+func (s *LoginAttemptService) LoginAttempt(email todo.Email, ip string) (time.Time, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var until time.Time
+	for _, a := range []struct {
+		kind    todo.LockoutKind
+		subject string
+	}{{todo.LockoutAccount, string(email)}, {todo.LockoutIP, ip}} {
+		if a.subject == "" {
+			continue
+		}
+		lockedUntil, err := lockAttempts(tx, a.kind, a.subject)
+		if err != nil {
+			tx.Rollback()
+			return time.Time{}, err
+		} else if lockedUntil.After(until) {
+			until = lockedUntil
+		}
+	}
+	if !until.IsZero() {
+		return until, tx.Commit()
+	}
+	if email != "" {
+		if err := recordFailure(tx, todo.LockoutAccount, string(email), ip, s.AccountPolicy); err != nil {
+			tx.Rollback()
+			return time.Time{}, err
+		}
+	}
+	if ip != "" {
+		if err := recordFailure(tx, todo.LockoutIP, ip, ip, s.IPPolicy); err != nil {
+			tx.Rollback()
+			return time.Time{}, err
+		}
+	}
+	return time.Time{}, tx.Commit()
+}
+
+// LoginSucceeded forgets the account's failures. The IP address only gets
+// the attempt back, otherwise logging into an account of their own would
+// let someone reset its failures between guesses.
+func (s *LoginAttemptService) LoginSucceeded(email todo.Email, ip string) error {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM todo.loginAttempts WHERE kind=$1 AND subject=$2", todo.LockoutAccount, email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`UPDATE todo.loginAttempts SET failures=GREATEST(failures-1, 0),
+	lockedUntil=CASE WHEN failures-1 < $3 THEN NULL ELSE lockedUntil END
+	WHERE kind=$1 AND subject=$2`, todo.LockoutIP, ip, s.IPPolicy.Attempts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *LoginAttemptService) Lockouts() (*todo.Lockouts, error) {
+	rows, err := s.client.db.Query(`SELECT kind, subject, failures,
+	to_char(lockedUntil AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+	FROM todo.loginAttempts WHERE lockedUntil > current_timestamp ORDER BY lockedUntil DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lockouts := todo.Lockouts{}
+	for rows.Next() {
+		var l todo.Lockout
+		if err := rows.Scan(&l.Kind, &l.Subject, &l.Failures, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return &lockouts, rows.Err()
+}
+
+// Unlock clears the failures of an account or IP address on behalf of an
+// admin.
+func (s *LoginAttemptService) Unlock(kind todo.LockoutKind, subject string, adminID todo.UserID) error {
+	if kind != todo.LockoutAccount && kind != todo.LockoutIP {
+		return todo.ErrLockoutKind
+	} else if FormatInput(subject) == "" {
+		return todo.ErrLockoutNotFound
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM todo.loginAttempts WHERE kind=$1 AND subject=$2", kind, subject)
+	if err == nil {
+		err = expectRow(res, todo.ErrLockoutNotFound)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := writeAudit(tx, todo.AuditLoginUnlocked, adminID, string(kind)+":"+subject, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockAttempts locks the row counting the subject's failures, creating it if
+// there isn't one, and returns when it is locked out until if it is.
+func lockAttempts(tx *sql.Tx, kind todo.LockoutKind, subject string) (time.Time, error) {
+	_, err := tx.Exec(`INSERT INTO todo.loginAttempts(kind, subject, lastFailure)
+	VALUES($1, $2, current_timestamp) ON CONFLICT DO NOTHING`, kind, subject)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var until pq.NullTime
+	row := tx.QueryRow(`SELECT CASE WHEN lockedUntil > current_timestamp THEN lockedUntil END
+	FROM todo.loginAttempts WHERE kind=$1 AND subject=$2 FOR UPDATE`, kind, subject)
+	if err := row.Scan(&until); err != nil {
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+// recordFailure counts one more failure, starting again from one if the
+// last was longer ago than the policy's window, and sets the lockout the
+// policy gives for the new count. Going from unlocked to locked is audited.
+func recordFailure(tx *sql.Tx, kind todo.LockoutKind, subject string, ip string, policy todo.LoginPolicy) error {
+	var failures int
+	var locked bool
+	row := tx.QueryRow(`INSERT INTO todo.loginAttempts AS a(kind, subject, failures, lastFailure)
+	VALUES($1, $2, 1, current_timestamp)
+	ON CONFLICT (kind, subject) DO UPDATE SET
+	failures=CASE WHEN a.lastFailure < current_timestamp - $3 * interval '1 millisecond' THEN 1 ELSE a.failures+1 END,
+	lastFailure=current_timestamp
+	RETURNING failures, COALESCE(lockedUntil > current_timestamp, false)`, kind, subject, policy.Window.Milliseconds())
+	if err := row.Scan(&failures, &locked); err != nil {
+		return err
+	}
+	lockout := policy.Lockout(failures)
+	if lockout == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE todo.loginAttempts SET lockedUntil=current_timestamp + $3 * interval '1 millisecond'
+	WHERE kind=$1 AND subject=$2`, kind, subject, lockout.Milliseconds())
+	if err != nil || locked {
+		return err
+	}
+	return writeAudit(tx, todo.AuditLoginLocked, "", string(kind)+":"+subject, ip)
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// attemptLogins makes n failed login attempts and returns when the last one
+// said logins were locked until.
+func attemptLogins(t *testing.T, s todo.LoginAttemptService, email todo.Email, ip string, n int) time.Time {
+	t.Helper()
+	var until time.Time
+	for i := 0; i < n; i++ {
+		var err error
+		if until, err = s.LoginAttempt(email, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return until
+}
+
+func TestLoginAttemptLockout(t *testing.T) {
+	c := openTestClient(t)
+	s := &LoginAttemptService{
+		client:        c,
+		AccountPolicy: todo.LoginPolicy{Attempts: 2, Backoff: time.Minute, MaxBackoff: time.Hour, Window: time.Hour},
+		IPPolicy:      todo.LoginPolicy{Attempts: 4, Backoff: time.Minute, MaxBackoff: time.Hour, Window: time.Hour},
+	}
+	adminID, email := createTestUser(t, c)
+	ip := newTestID()
+
+	// Logging in forgets the account's failures but only takes back one of
+	// the address's
+	if until := attemptLogins(t, s, email, ip, 1); !until.IsZero() {
+		t.Fatalf("locked after one failure until %s", until)
+	}
+	if err := s.LoginSucceeded(email, ip); err != nil {
+		t.Fatal(err)
+	}
+	if until := attemptLogins(t, s, email, ip, 2); !until.IsZero() {
+		t.Fatalf("locked after two failures until %s", until)
+	}
+	until := attemptLogins(t, s, email, ip, 1)
+	if wait := time.Until(until); wait < 50*time.Second || wait > time.Minute {
+		t.Fatalf("account locked for %s, want a minute", wait)
+	}
+	// The lockout holds from other addresses, and locked attempts aren't
+	// counted
+	if until := attemptLogins(t, s, email, newTestID(), 3); until.IsZero() {
+		t.Error("account not locked from another address")
+	}
+
+	// The address has had two failures, two more lock it for any account
+	if until := attemptLogins(t, s, "other-"+email, ip, 2); !until.IsZero() {
+		t.Fatalf("address locked before four failures until %s", until)
+	}
+	if until := attemptLogins(t, s, "another-"+email, ip, 1); until.IsZero() {
+		t.Error("address not locked after four failures")
+	}
+
+	lockouts, err := s.Lockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := map[string]bool{}
+	for _, l := range *lockouts {
+		locked[string(l.Kind)+":"+l.Subject] = true
+	}
+	if !locked["account:"+string(email)] || !locked["ip:"+ip] {
+		t.Errorf("lockouts = %+v", *lockouts)
+	}
+
+	if err := s.Unlock(todo.LockoutAccount, string(email), adminID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlock(todo.LockoutAccount, string(email), adminID); err != todo.ErrLockoutNotFound {
+		t.Errorf("second Unlock = %v, want %v", err, todo.ErrLockoutNotFound)
+	}
+	if err := s.Unlock(todo.LockoutIP, ip, adminID); err != nil {
+		t.Fatal(err)
+	}
+	if until := attemptLogins(t, s, email, ip, 1); !until.IsZero() {
+		t.Errorf("still locked after unlocking until %s", until)
+	}
+	log, err := c.AuditService().AuditLog(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked := false
+	for _, e := range *log {
+		unlocked = unlocked || (e.Action == todo.AuditLoginUnlocked && e.ActorID == adminID && e.Subject == "account:"+string(email))
+	}
+	if !unlocked {
+		t.Error("unlock wasn't audited")
+	}
+}
@@ -0,0 +1,193 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/kennedymj97/todo-api/totp"
+)
+
+var _ todo.MFAService = &MFAService{}
+
+// Codes from one step either side of now are accepted to allow for clock
+// drift, and a challenge allows a few wrong codes before it is thrown away.
+const (
+	totpSkew             = 1
+	maxChallengeAttempts = 5
+)
+
+type MFAService struct {
+	client *Client
+}
+
+func (s *MFAService) MFAEnabled(userID todo.UserID) (bool, error) {
+	var enabled bool
+	row := s.client.db.QueryRow("SELECT totpEnabled FROM todo.users WHERE userID=$1", userID)
+	if err := row.Scan(&enabled); err == sql.ErrNoRows {
+		return false, todo.ErrUserNotFound
+	} else if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// EnrollMFA stores a new secret for the user. It isn't used to log in until
+// ConfirmMFA has been called with a code generated from it.
+func (s *MFAService) EnrollMFA(userID todo.UserID, secret string) error {
+	if FormatInput(userID) == "" {
+		return todo.ErrUserIDRequired
+	}
+	res, err := s.client.db.Exec("UPDATE todo.users SET totpSecret=$1, totpLastStep=0 WHERE userID=$2 AND NOT totpEnabled", secret, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrMFAAlreadyEnabled)
+}
+
+// ConfirmMFA turns MFA on once the user has proven their app generates the
+// right codes, replacing any previous recovery codes.
+func (s *MFAService) ConfirmMFA(userID todo.UserID, code string, recoveryCodeHashes []string) error {
+	if FormatInput(code) == "" {
+		return todo.ErrMFACodeRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	var secret sql.NullString
+	var enabled bool
+	row := tx.QueryRow("SELECT totpSecret, totpEnabled FROM todo.users WHERE userID=$1 FOR UPDATE", userID)
+	err = row.Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		err = todo.ErrUserNotFound
+	} else if err == nil && enabled {
+		err = todo.ErrMFAAlreadyEnabled
+	} else if err == nil && !secret.Valid {
+		err = todo.ErrMFANotEnrolled
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	step, ok := totp.Validate(secret.String, code, time.Now(), totpSkew, 0)
+	if !ok {
+		tx.Rollback()
+		return todo.ErrMFACodeInvalid
+	}
+	_, err = tx.Exec("UPDATE todo.users SET totpEnabled=true, totpLastStep=$1 WHERE userID=$2", step, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM todo.recoveryCodes WHERE userID=$1", userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO todo.recoveryCodes(userID, codeHash) VALUES($1, $2)", userID, hash)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// VerifyMFA checks a code from the user's app, or one of their recovery
+// codes by its hash. Either can only be used once.
+func (s *MFAService) VerifyMFA(userID todo.UserID, code string, codeHash string) error {
+	if FormatInput(code) == "" {
+		return todo.ErrMFACodeRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	row := tx.QueryRow("SELECT totpSecret, totpEnabled, totpLastStep FROM todo.users WHERE userID=$1 FOR UPDATE", userID)
+	err = row.Scan(&secret, &enabled, &lastStep)
+	if err == sql.ErrNoRows {
+		err = todo.ErrUserNotFound
+	} else if err == nil && !enabled {
+		err = todo.ErrMFANotEnabled
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if step, ok := totp.Validate(secret.String, code, time.Now(), totpSkew, lastStep); ok {
+		_, err = tx.Exec("UPDATE todo.users SET totpLastStep=$1 WHERE userID=$2", step, userID)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec("UPDATE todo.recoveryCodes SET usedAt=current_timestamp WHERE userID=$1 AND codeHash=$2 AND usedAt IS NULL", userID, codeHash)
+		if err == nil {
+			err = expectRow(res, todo.ErrMFACodeInvalid)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *MFAService) DisableMFA(userID todo.UserID) error {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE todo.users SET totpEnabled=false, totpSecret=NULL, totpLastStep=0 WHERE userID=$1 AND totpEnabled", userID)
+	if err == nil {
+		err = expectRow(res, todo.ErrMFANotEnabled)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM todo.recoveryCodes WHERE userID=$1", userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *MFAService) CreateMFAChallenge(userID todo.UserID, challengeHash string, expiresAt time.Time) error {
+	if FormatInput(challengeHash) == "" {
+		return todo.ErrMFAChallengeRequired
+	}
+	_, err := s.client.db.Exec("INSERT INTO todo.mfaChallenges(challengeHash, userID, expiresAt) VALUES($1, $2, $3)", challengeHash, userID, expiresAt)
+	return err
+}
+
+// MFAChallenge returns the user a login challenge was issued to. Every call
+// counts as an attempt, and the challenge stops working after a few.
+func (s *MFAService) MFAChallenge(challengeHash string) (todo.UserID, error) {
+	if FormatInput(challengeHash) == "" {
+		return "", todo.ErrMFAChallengeRequired
+	}
+	var userID todo.UserID
+	row := s.client.db.QueryRow(`UPDATE todo.mfaChallenges SET attempts=attempts+1
+	WHERE challengeHash=$1 AND expiresAt > current_timestamp AND attempts < $2
+	RETURNING userID`, challengeHash, maxChallengeAttempts)
+	if err := row.Scan(&userID); err == sql.ErrNoRows {
+		return "", todo.ErrMFAChallengeInvalid
+	} else if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// DeleteMFAChallenge uses up a challenge. Only one caller can delete it, so
+// a challenge only ever leads to one session.
+func (s *MFAService) DeleteMFAChallenge(challengeHash string) error {
+	res, err := s.client.db.Exec("DELETE FROM todo.mfaChallenges WHERE challengeHash=$1", challengeHash)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrMFAChallengeInvalid)
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"encoding/json"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+var _ todo.NotificationService = &NotificationService{}
+var _ todo.Notifier = &InAppNotifier{}
+
+// maxNotifications is how many notifications are listed at once, newest
+// first.
+const maxNotifications = 100
+
+type NotificationService struct {
+	client *Client
+}
+
+func (s *NotificationService) Notifications(userID todo.UserID, unreadOnly bool) (*todo.Notifications, error) {
+	rows, err := s.client.db.Query(`SELECT notificationID, type, payload, readAt IS NOT NULL, timestamp FROM todo.notifications
+	WHERE userID=$1 AND (NOT $2 OR readAt IS NULL)
+	ORDER BY timestamp DESC
+	LIMIT $3`, userID, unreadOnly, maxNotifications)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := todo.Notifications{}
+	for rows.Next() {
+		var n todo.Notification
+		var payload string
+		if err := rows.Scan(&n.ID, &n.Type, &payload, &n.Read, &n.Timestamp); err != nil {
+			return nil, err
+		}
+		n.Data = json.RawMessage(payload)
+		notifications = append(notifications, n)
+	}
+	return &notifications, nil
+}
+
+func (s *NotificationService) UnreadCount(userID todo.UserID) (int, error) {
+	var count int
+	row := s.client.db.QueryRow("SELECT COUNT(*) FROM todo.notifications WHERE userID=$1 AND readAt IS NULL", userID)
+	if err := row.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *NotificationService) MarkRead(id todo.NotificationID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrNotificationIDRequired
+	}
+	res, err := s.client.db.Exec("UPDATE todo.notifications SET readAt=COALESCE(readAt, current_timestamp) WHERE notificationID=$1 AND userID=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrNotificationNotFound)
+}
+
+func (s *NotificationService) MarkAllRead(userID todo.UserID) error {
+	_, err := s.client.db.Exec("UPDATE todo.notifications SET readAt=current_timestamp WHERE userID=$1 AND readAt IS NULL", userID)
+	return err
+}
+
+// Preferences returns whether the user wants each type of notification.
+func (s *NotificationService) Preferences(userID todo.UserID) (todo.NotificationPreferences, error) {
+	prefs := todo.NotificationPreferences{}
+	for _, t := range todo.NotificationTypes {
+		prefs[t] = true
+	}
+	rows, err := s.client.db.Query("SELECT type, enabled FROM todo.notificationPreferences WHERE userID=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t todo.EventType
+		var enabled bool
+		if err := rows.Scan(&t, &enabled); err != nil {
+			return nil, err
+		}
+		if notificationType(t) {
+			prefs[t] = enabled
+		}
+	}
+	return prefs, rows.Err()
+}
+
+func (s *NotificationService) SetPreference(userID todo.UserID, event todo.EventType, enabled bool) error {
+	if !notificationType(event) {
+		return todo.ErrNotificationTypeUnknown
+	}
+	_, err := s.client.db.Exec(`INSERT INTO todo.notificationPreferences(userID, type, enabled) VALUES($1, $2, $3)
+	ON CONFLICT (userID, type) DO UPDATE SET enabled=EXCLUDED.enabled`, userID, string(event), enabled)
+	return err
+}
+
+// Notify adds an event to the inbox of the user it was sent to, if it
+// concerns them and they haven't turned that type of notification off. It
+// is subscribed to the outbox, and an event seen twice is only added once.
+func (s *NotificationService) Notify(e todo.Event) error {
+	if !notifies(e) {
+		return nil
+	}
+	_, err := s.client.db.Exec(`INSERT INTO todo.notifications(eventID, userID, type, payload)
+	SELECT $1, $2, $3, $4
+	WHERE NOT EXISTS(SELECT 1 FROM todo.notificationPreferences WHERE userID=$2 AND type=$3 AND NOT enabled)
+	ON CONFLICT (eventID) DO NOTHING`, e.ID, e.UserID, string(e.Type), string(e.Payload))
+	return err
+}
+
+// notifies reports whether an event belongs in the inbox of the user it was
+// sent to. Events about the user's own actions don't.
+func notifies(e todo.Event) bool {
+	if !notificationType(e.Type) {
+		return false
+	}
+	var p struct {
+		UserID     todo.UserID `json:"userId"`
+		AssigneeID todo.UserID `json:"assigneeId"`
+		AssignedBy todo.UserID `json:"assignedBy"`
+	}
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		return false
+	}
+	switch e.Type {
+	case todo.EventTaskAssigned:
+		return p.AssigneeID == e.UserID && p.AssignedBy != e.UserID
+	case todo.EventMemberInvited, todo.EventRoleChanged:
+		return p.UserID == e.UserID
+	case todo.EventMemberJoined, todo.EventMemberLeft:
+		return p.UserID != e.UserID
+	}
+	return true
+}
+
+func notificationType(e todo.EventType) bool {
+	for _, t := range todo.NotificationTypes {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// InAppNotifier sends due reminders to the user's notification inbox.
+type InAppNotifier struct {
+	client *Client
+}
+
+func (n *InAppNotifier) Notify(r *todo.DueReminder) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = n.client.db.Exec(`INSERT INTO todo.notifications(userID, type, payload)
+	SELECT $1, $2, $3
+	WHERE NOT EXISTS(SELECT 1 FROM todo.notificationPreferences WHERE userID=$1 AND type=$2 AND NOT enabled)`,
+		r.UserID, string(todo.EventTaskDueSoon), string(payload))
+	return err
+}
@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.OAuthService = &OAuthService{}
+
+type OAuthService struct {
+	client *Client
+}
+
+func (s *OAuthService) CreateOAuthClient(c *todo.OAuthClient) (todo.OAuthClientID, error) {
+	if FormatInput(c.OwnerID) == "" {
+		return "", todo.ErrUserIDRequired
+	} else if FormatInput(c.Name) == "" {
+		return "", todo.ErrOAuthClientNameRequired
+	} else if len(c.RedirectURIs) == 0 {
+		return "", todo.ErrRedirectURIsRequired
+	}
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return "", todo.ErrRedirectURIsRequired
+		}
+	}
+	var id todo.OAuthClientID
+	row := s.client.db.QueryRow(`INSERT INTO todo.oauthClients(ownerID, name, redirectURIs, secretHash)
+	VALUES($1, $2, $3, $4) RETURNING clientID`, c.OwnerID, c.Name, pq.Array(c.RedirectURIs), nullable(c.SecretHash))
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *OAuthService) OAuthClients(ownerID todo.UserID) (*todo.OAuthClients, error) {
+	rows, err := s.client.db.Query(`SELECT clientID, name, redirectURIs, secretHash IS NOT NULL, ownerID, timestamp
+	FROM todo.oauthClients WHERE ownerID=$1 ORDER BY timestamp`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := todo.OAuthClients{}
+	for rows.Next() {
+		var c todo.OAuthClient
+		if err := rows.Scan(&c.ID, &c.Name, pq.Array(&c.RedirectURIs), &c.Confidential, &c.OwnerID, &c.Timestamp); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return &clients, rows.Err()
+}
+
+func (s *OAuthService) OAuthClient(id todo.OAuthClientID) (*todo.OAuthClient, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrOAuthClientIDRequired
+	}
+	var c todo.OAuthClient
+	row := s.client.db.QueryRow(`SELECT clientID, name, redirectURIs, COALESCE(secretHash, ''), ownerID, timestamp
+	FROM todo.oauthClients WHERE clientID::text=$1`, id)
+	if err := row.Scan(&c.ID, &c.Name, pq.Array(&c.RedirectURIs), &c.SecretHash, &c.OwnerID, &c.Timestamp); err == sql.ErrNoRows {
+		return nil, todo.ErrOAuthClientNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	c.Confidential = c.SecretHash != ""
+	return &c, nil
+}
+
+// DeleteOAuthClient removes the client along with every code and token it
+// was given.
+func (s *OAuthService) DeleteOAuthClient(id todo.OAuthClientID, ownerID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrOAuthClientIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM todo.oauthClients WHERE clientID::text=$1 AND ownerID=$2", id, ownerID)
+	if err == nil {
+		err = expectRow(res, todo.ErrOAuthClientNotFound)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM todo.oauthCodes WHERE clientID::text=$1",
+		"DELETE FROM todo.oauthTokens WHERE clientID::text=$1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *OAuthService) CreateOAuthCode(codeHash string, code *todo.OAuthCode) error {
+	_, err := s.client.db.Exec(`INSERT INTO todo.oauthCodes(codeHash, clientID, userID, redirectURI, redirectURIExplicit, scopes, codeChallenge, expiresAt)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8)`,
+		codeHash, code.ClientID, code.UserID, code.RedirectURI, code.RedirectURIExplicit, pq.Array(code.Scopes), code.CodeChallenge, code.ExpiresAt)
+	return err
+}
+
+// ExchangeOAuthCode uses up the code. A code used a second time has been
+// intercepted, so the tokens it was first exchanged for are revoked.
+func (s *OAuthService) ExchangeOAuthCode(codeHash string, clientID todo.OAuthClientID, redirectURI string, verifier string, tokens *todo.OAuthTokenHashes) (*todo.OAuthGrant, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var grant todo.OAuthGrant
+	var codeRedirectURI, challenge string
+	var tokenID sql.NullString
+	var explicit, expired bool
+	row := tx.QueryRow(`SELECT clientID, userID, redirectURI, redirectURIExplicit, scopes, codeChallenge, tokenID, expiresAt <= current_timestamp
+	FROM todo.oauthCodes WHERE codeHash=$1 FOR UPDATE`, codeHash)
+	if err := row.Scan(&grant.ClientID, &grant.UserID, &codeRedirectURI, &explicit, pq.Array(&grant.Scopes), &challenge, &tokenID, &expired); err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, todo.ErrOAuthGrantInvalid
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if tokenID.Valid {
+		if _, err := tx.Exec("DELETE FROM todo.oauthTokens WHERE tokenID=$1", tokenID.String); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, todo.ErrOAuthGrantInvalid
+	}
+	// RFC 6749 4.1.3, the redirect URI is only needed if the authorization
+	// request had it
+	redirectOK := codeRedirectURI == redirectURI || (!explicit && redirectURI == "")
+	if expired || grant.ClientID != clientID || !redirectOK || !todo.VerifyPKCE(challenge, verifier) {
+		tx.Rollback()
+		return nil, todo.ErrOAuthGrantInvalid
+	}
+	id, err := insertOAuthToken(tx, &grant, tokens)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE todo.oauthCodes SET tokenID=$1 WHERE codeHash=$2", id, codeHash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// RefreshOAuthToken replaces the tokens of the pair the refresh token
+// belongs to, so the old refresh token stops working.
+func (s *OAuthService) RefreshOAuthToken(refreshHash string, clientID todo.OAuthClientID, tokens *todo.OAuthTokenHashes) (*todo.OAuthGrant, error) {
+	// The pair keeps its ID so replaying the code it came from still
+	// revokes it
+	var grant todo.OAuthGrant
+	row := s.client.db.QueryRow(`UPDATE todo.oauthTokens SET accessHash=$3, accessExpiresAt=$4, refreshHash=$5, refreshExpiresAt=$6
+	WHERE refreshHash=$1 AND clientID::text=$2 AND refreshExpiresAt > current_timestamp
+	RETURNING clientID, userID, scopes`, refreshHash, clientID, tokens.AccessHash, tokens.AccessExpiresAt, tokens.RefreshHash, tokens.RefreshExpiresAt)
+	if err := row.Scan(&grant.ClientID, &grant.UserID, pq.Array(&grant.Scopes)); err == sql.ErrNoRows {
+		return nil, todo.ErrOAuthGrantInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (s *OAuthService) AuthenticateOAuthToken(accessHash string) (todo.UserID, []string, error) {
+	var userID todo.UserID
+	var scopes []string
+	row := s.client.db.QueryRow(`SELECT t.userID, t.scopes FROM todo.oauthTokens t
+	JOIN todo.oauthClients c ON c.clientID=t.clientID
+	WHERE t.accessHash=$1 AND t.accessExpiresAt > current_timestamp`, accessHash)
+	if err := row.Scan(&userID, pq.Array(&scopes)); err == sql.ErrNoRows {
+		return "", nil, todo.ErrUnauthorized
+	} else if err != nil {
+		return "", nil, err
+	}
+	return userID, scopes, nil
+}
+
+// RevokeOAuthToken doesn't report unknown tokens, as RFC 7009 asks.
+func (s *OAuthService) RevokeOAuthToken(tokenHash string, clientID todo.OAuthClientID) error {
+	_, err := s.client.db.Exec("DELETE FROM todo.oauthTokens WHERE (accessHash=$1 OR refreshHash=$1) AND clientID::text=$2", tokenHash, clientID)
+	return err
+}
+
+func insertOAuthToken(tx *sql.Tx, grant *todo.OAuthGrant, tokens *todo.OAuthTokenHashes) (string, error) {
+	var id string
+	row := tx.QueryRow(`INSERT INTO todo.oauthTokens(clientID, userID, scopes, accessHash, accessExpiresAt, refreshHash, refreshExpiresAt)
+	VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING tokenID`,
+		grant.ClientID, grant.UserID, pq.Array(grant.Scopes), tokens.AccessHash, tokens.AccessExpiresAt, tokens.RefreshHash, tokens.RefreshExpiresAt)
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
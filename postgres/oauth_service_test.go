@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+// newTestTokens returns token hashes no other test uses.
+func newTestTokens() *todo.OAuthTokenHashes {
+	return &todo.OAuthTokenHashes{
+		AccessHash:       newTestID(),
+		AccessExpiresAt:  time.Now().Add(time.Hour),
+		RefreshHash:      newTestID(),
+		RefreshExpiresAt: time.Now().Add(time.Hour),
+	}
+}
+
+// createTestCode creates a client and a code for it, and returns the code's
+// hash.
+func createTestCode(t *testing.T, c *Client) (string, todo.OAuthClientID) {
+	t.Helper()
+	s := c.OAuthService()
+	userID, _ := createTestUser(t, c)
+	clientID, err := s.CreateOAuthClient(&todo.OAuthClient{
+		Name:         "App",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		OwnerID:      userID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(testVerifier))
+	codeHash := newTestID()
+	if err := s.CreateOAuthCode(codeHash, &todo.OAuthCode{
+		ClientID:            clientID,
+		UserID:              userID,
+		RedirectURI:         "https://app.example.com/callback",
+		RedirectURIExplicit: true,
+		Scopes:              []string{"tasks:read"},
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return codeHash, clientID
+}
+
+func TestExchangeOAuthCodeReplay(t *testing.T) {
+	c := openTestClient(t)
+	s := c.OAuthService()
+	codeHash, clientID := createTestCode(t, c)
+
+	tokens := newTestTokens()
+	if _, err := s.ExchangeOAuthCode(codeHash, clientID, "https://app.example.com/callback", testVerifier, tokens); err != nil {
+		t.Fatal(err)
+	}
+	refreshed := newTestTokens()
+	if _, err := s.RefreshOAuthToken(tokens.RefreshHash, clientID, refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.AuthenticateOAuthToken(tokens.AccessHash); err != todo.ErrUnauthorized {
+		t.Errorf("AuthenticateOAuthToken() refreshed access token = %v, want %v", err, todo.ErrUnauthorized)
+	}
+
+	// Replaying the code revokes the tokens it was exchanged for, after
+	// they've been refreshed too
+	if _, err := s.ExchangeOAuthCode(codeHash, clientID, "https://app.example.com/callback", testVerifier, newTestTokens()); err != todo.ErrOAuthGrantInvalid {
+		t.Errorf("ExchangeOAuthCode() replayed = %v, want %v", err, todo.ErrOAuthGrantInvalid)
+	}
+	if _, _, err := s.AuthenticateOAuthToken(refreshed.AccessHash); err != todo.ErrUnauthorized {
+		t.Errorf("AuthenticateOAuthToken() after replay = %v, want %v", err, todo.ErrUnauthorized)
+	}
+	if _, err := s.RefreshOAuthToken(refreshed.RefreshHash, clientID, newTestTokens()); err != todo.ErrOAuthGrantInvalid {
+		t.Errorf("RefreshOAuthToken() after replay = %v, want %v", err, todo.ErrOAuthGrantInvalid)
+	}
+}
+
+func TestExchangeOAuthCodeChecks(t *testing.T) {
+	c := openTestClient(t)
+	s := c.OAuthService()
+	codeHash, clientID := createTestCode(t, c)
+	_, otherClientID := createTestCode(t, c)
+
+	for _, tt := range []struct {
+		name        string
+		clientID    todo.OAuthClientID
+		redirectURI string
+		verifier    string
+	}{
+		{"wrong verifier", clientID, "https://app.example.com/callback", testVerifier + "x"},
+		{"no verifier", clientID, "https://app.example.com/callback", ""},
+		{"wrong redirect uri", clientID, "https://app.example.com/other", testVerifier},
+		{"no redirect uri", clientID, "", testVerifier},
+		{"wrong client", otherClientID, "https://app.example.com/callback", testVerifier},
+	} {
+		if _, err := s.ExchangeOAuthCode(codeHash, tt.clientID, tt.redirectURI, tt.verifier, newTestTokens()); err != todo.ErrOAuthGrantInvalid {
+			t.Errorf("ExchangeOAuthCode() %s = %v, want %v", tt.name, err, todo.ErrOAuthGrantInvalid)
+		}
+	}
+	// A failed exchange doesn't use up the code
+	if _, err := s.ExchangeOAuthCode(codeHash, clientID, "https://app.example.com/callback", testVerifier, newTestTokens()); err != nil {
+		t.Errorf("ExchangeOAuthCode() = %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+// eventChannel is the channel writeEvent notifies with the id of each
+// event, for EventListener.
+const eventChannel = "todo_events"
+
+// writeEvent records an event in the outbox as part of tx, so the event
+// exists if and only if the change that caused it is committed. Listeners
+// are notified when tx commits.
+func writeEvent(tx *sql.Tx, event todo.EventType, userID todo.UserID, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`WITH e AS (
+		INSERT INTO todo.outbox(type, userID, payload) VALUES($1, $2, $3) RETURNING eventID
+	) SELECT pg_notify($4, eventID::text) FROM e`, string(event), userID, string(payload), eventChannel)
+	return err
+}
+
+// writeEvents records the same event for each of the users.
+func writeEvents(tx *sql.Tx, event todo.EventType, userIDs []todo.UserID, data interface{}) error {
+	for _, userID := range userIDs {
+		if err := writeEvent(tx, event, userID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventDispatcher delivers the events in the outbox to the subscribers at
+// least once. Events are claimed with SKIP LOCKED so several api replicas
+// can run a dispatcher against the same database, and each event is only
+// delivered by one of them. Which subscribers have had an event is
+// recorded, so one failing doesn't deliver it to the others again.
+type EventDispatcher struct {
+	client      *Client
+	mu          sync.RWMutex
+	subscribers []subscriber
+	Logger      *log.Logger
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	// ClaimTTL is how long a claimed event is left to the replica that
+	// claimed it, before another can deliver it.
+	ClaimTTL time.Duration
+}
+
+type subscriber struct {
+	name   string
+	handle todo.EventHandler
+}
+
+func (d *EventDispatcher) init() {
+	d.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	d.Interval = 500 * time.Millisecond
+	d.BatchSize = 100
+	d.MaxAttempts = 10
+	d.ClaimTTL = time.Minute
+}
+
+// Subscribe registers a handler for every event. It should be called before
+// Run. The name records which events the handler has had, so it mustn't
+// change between releases.
+func (d *EventDispatcher) Subscribe(name string, h todo.EventHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers = append(d.subscribers, subscriber{name, h})
+}
+
+// Run publishes events until stop is closed.
+func (d *EventDispatcher) Run(stop <-chan struct{}) {
+	todo.Poll(stop, d.Interval, d.BatchSize, d.Flush, func(err error) {
+		d.Logger.Printf("outbox error: %s", err)
+	})
+}
+
+// claimedEvent is an event claimed from the outbox and the subscribers it
+// has been delivered to.
+type claimedEvent struct {
+	todo.Event
+	seq         int64
+	attempts    int
+	deliveredTo []string
+	// lastError is the error of the last subscriber that failed.
+	lastError string
+}
+
+// Flush publishes one batch of events and returns how many were claimed.
+// The claim is committed before the subscribers are called, so no rows are
+// locked while they run.
+func (d *EventDispatcher) Flush() (int, error) {
+	events, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	for i := range events {
+		d.publish(&events[i])
+	}
+	return len(events), d.record(events)
+}
+
+// claim leases a batch of undelivered events to this dispatcher for
+// ClaimTTL, oldest first.
+func (d *EventDispatcher) claim() ([]claimedEvent, error) {
+	tx, err := d.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`UPDATE todo.outbox o SET claimedUntil=current_timestamp + $2 * interval '1 millisecond'
+	FROM (
+		SELECT eventID FROM todo.outbox
+		WHERE deliveredAt IS NULL AND (claimedUntil IS NULL OR claimedUntil <= current_timestamp)
+		ORDER BY eventID
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	) due
+	WHERE o.eventID=due.eventID
+	RETURNING o.eventID, o.type, o.userID, o.payload, o.attempts, o.deliveredTo, o.timestamp`, d.BatchSize, d.ClaimTTL.Milliseconds())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	var events []claimedEvent
+	for rows.Next() {
+		var e claimedEvent
+		var payload string
+		if err := rows.Scan(&e.seq, &e.Type, &e.UserID, &payload, &e.attempts, pq.Array(&e.deliveredTo), &e.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		e.ID = todo.EventID(strconv.FormatInt(e.seq, 10))
+		e.Payload = json.RawMessage(payload)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	// RETURNING doesn't keep the order of the subquery
+	sort.Slice(events, func(i, j int) bool { return events[i].seq < events[j].seq })
+	return events, nil
+}
+
+// publish hands the event to every subscriber that hasn't had it yet, and
+// adds the ones that take it to deliveredTo. A subscriber that panics is
+// treated as having failed.
+func (d *EventDispatcher) publish(e *claimedEvent) {
+	d.mu.RLock()
+	subscribers := d.subscribers
+	d.mu.RUnlock()
+	for _, s := range subscribers {
+		if delivered(e.deliveredTo, s.name) {
+			continue
+		}
+		if err := callHandler(s.handle, e.Event); err != nil {
+			d.Logger.Printf("outbox error: %s (event=%s, subscriber=%s)", err, e.ID, s.name)
+			e.lastError = s.name + ": " + err.Error()
+			continue
+		}
+		e.deliveredTo = append(e.deliveredTo, s.name)
+	}
+}
+
+// record saves who the events were delivered to. Events every subscriber
+// has had, or that have run out of attempts, are done with; the rest are
+// released to be claimed again.
+func (d *EventDispatcher) record(events []claimedEvent) error {
+	d.mu.RLock()
+	subscribers := d.subscribers
+	d.mu.RUnlock()
+	tx, err := d.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	for _, e := range events {
+		done := true
+		for _, s := range subscribers {
+			done = done && delivered(e.deliveredTo, s.name)
+		}
+		query := "UPDATE todo.outbox SET deliveredTo=$2, deliveredAt=current_timestamp, claimedUntil=NULL, lastError=NULL WHERE eventID=$1"
+		args := []interface{}{e.seq, pq.Array(e.deliveredTo)}
+		if !done {
+			if e.attempts+1 >= d.MaxAttempts {
+				d.Logger.Printf("outbox: giving up on event %s: %s", e.ID, e.lastError)
+				query = "UPDATE todo.outbox SET deliveredTo=$2, attempts=attempts+1, lastError=$3, deliveredAt=current_timestamp, claimedUntil=NULL WHERE eventID=$1"
+			} else {
+				query = "UPDATE todo.outbox SET deliveredTo=$2, attempts=attempts+1, lastError=$3, claimedUntil=NULL WHERE eventID=$1"
+			}
+			args = append(args, e.lastError)
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func delivered(deliveredTo []string, name string) bool {
+	for _, n := range deliveredTo {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func callHandler(h todo.EventHandler, e todo.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("event handler panic: %v", r)
+		}
+	}()
+	return h(e)
+}
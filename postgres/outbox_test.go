@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// writeTestEvent commits an event for the user.
+func writeTestEvent(t *testing.T, c *Client, userID todo.UserID) {
+	t.Helper()
+	tx, err := c.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEvent(tx, todo.EventTaskCreated, userID, map[string]string{"id": "task"}); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingHandler counts the user's events, failing the first failures.
+type countingHandler struct {
+	mu       sync.Mutex
+	userID   todo.UserID
+	calls    int
+	failures int
+}
+
+func (h *countingHandler) handle(e todo.Event) error {
+	if e.UserID != h.userID {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	if h.failures > 0 {
+		h.failures--
+		return todo.Error("failed")
+	}
+	return nil
+}
+
+func (h *countingHandler) Calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
+
+func TestDispatcherPerSubscriber(t *testing.T) {
+	c := openTestClient(t)
+	userID, _ := createTestUser(t, c)
+	d := &EventDispatcher{client: c}
+	d.init()
+	d.BatchSize = 1000
+	ok := &countingHandler{userID: userID}
+	flaky := &countingHandler{userID: userID, failures: 1}
+	d.Subscribe("ok", ok.handle)
+	d.Subscribe("flaky", flaky.handle)
+
+	writeTestEvent(t, c, userID)
+	for i := 0; i < 3; i++ {
+		if _, err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The event is retried for the subscriber that failed and no other
+	if ok.Calls() != 1 || flaky.Calls() != 2 {
+		t.Errorf("calls = %d and %d, want 1 and 2", ok.Calls(), flaky.Calls())
+	}
+}
+
+func TestDispatcherHoldsNoLocks(t *testing.T) {
+	c := openTestClient(t)
+	userID, _ := createTestUser(t, c)
+	d := &EventDispatcher{client: c}
+	d.init()
+	d.BatchSize = 1000
+	other := &EventDispatcher{client: c}
+	other.init()
+	other.BatchSize = 1000
+	otherCalls := &countingHandler{userID: userID}
+	other.Subscribe("handler", otherCalls.handle)
+
+	calls := 0
+	d.Subscribe("handler", func(e todo.Event) error {
+		if e.UserID != userID {
+			return nil
+		}
+		calls++
+		// The row is claimed but not locked, and no one else takes it
+		if _, err := c.db.Exec("SELECT 1 FROM todo.outbox WHERE eventID=$1 FOR UPDATE NOWAIT", e.ID); err != nil {
+			t.Errorf("event row is locked while handlers run: %s", err)
+		}
+		if _, err := other.Flush(); err != nil {
+			t.Error(err)
+		}
+		return nil
+	})
+
+	writeTestEvent(t, c, userID)
+	if _, err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || otherCalls.Calls() != 0 {
+		t.Errorf("calls = %d and %d, want 1 and 0", calls, otherCalls.Calls())
+	}
+}
+
+func TestListenerFansOut(t *testing.T) {
+	c := openTestClient(t)
+	userID, _ := createTestUser(t, c)
+	stop := make(chan struct{})
+	defer close(stop)
+	// Each replica has its own listener
+	var replicas []*countingHandler
+	for i := 0; i < 2; i++ {
+		l := &EventListener{client: c}
+		l.init()
+		h := &countingHandler{userID: userID}
+		l.Subscribe(h.handle)
+		replicas = append(replicas, h)
+		go l.Run(stop)
+	}
+	// Give the listeners time to connect
+	time.Sleep(500 * time.Millisecond)
+
+	writeTestEvent(t, c, userID)
+	deadline := time.Now().Add(5 * time.Second)
+	for replicas[0].Calls() == 0 || replicas[1].Calls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("calls = %d and %d, want 1 and 1", replicas[0].Calls(), replicas[1].Calls())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,465 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.ProjectService = &ProjectService{}
+
+type ProjectService struct {
+	client *Client
+}
+
+// Event payload written to the outbox for membership changes.
+type memberPayload struct {
+	ProjectID todo.ProjectID `json:"projectId"`
+	UserID    todo.UserID    `json:"userId,omitempty"`
+	Email     todo.Email     `json:"email,omitempty"`
+	Role      todo.Role      `json:"role,omitempty"`
+}
+
+func (s *ProjectService) Projects(userID todo.UserID) (*todo.Projects, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`SELECT p.projectID, p.name, p.ownerID, m.role, p.timestamp FROM todo.projects p
+	JOIN todo.projectMembers m ON m.projectID=p.projectID
+	WHERE m.userID=$1 ORDER BY p.timestamp`, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	projects := todo.Projects{}
+	for rows.Next() {
+		var p todo.Project
+		if err := rows.Scan(&p.ID, &p.Name, &p.OwnerID, &p.Role, &p.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return &projects, nil
+}
+
+func (s *ProjectService) CreateProject(name string, userID todo.UserID) (todo.ProjectID, error) {
+	if FormatInput(name) == "" {
+		return "", todo.ErrProjectNameRequired
+	} else if FormatInput(userID) == "" {
+		return "", todo.ErrUserIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	var id todo.ProjectID
+	row := tx.QueryRow("INSERT INTO todo.projects(name, ownerID) VALUES($1, $2) RETURNING projectID", FormatInput(name), userID)
+	if err := row.Scan(&id); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO todo.projectMembers(projectID, userID, role) VALUES($1, $2, $3)", id, userID, todo.RoleOwner)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *ProjectService) Role(id todo.ProjectID, userID todo.UserID) (todo.Role, error) {
+	if FormatInput(id) == "" {
+		return "", todo.ErrProjectIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	return projectRole(tx, id, userID)
+}
+
+func (s *ProjectService) Members(id todo.ProjectID, userID todo.UserID) (*todo.Members, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrProjectIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	if _, err := projectRole(tx, id, userID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT m.userID, u.email, m.role, m.timestamp FROM todo.projectMembers m
+	JOIN todo.users u ON u.userID=m.userID
+	WHERE m.projectID=$1 ORDER BY m.timestamp`, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	members := todo.Members{}
+	for rows.Next() {
+		var m todo.Member
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role, &m.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return &members, nil
+}
+
+func (s *ProjectService) InviteMember(id todo.ProjectID, email todo.Email, role todo.Role, userID todo.UserID) (todo.InvitationID, error) {
+	if FormatInput(id) == "" {
+		return "", todo.ErrProjectIDRequired
+	} else if FormatInput(email) == "" {
+		return "", todo.ErrEmailRequired
+	} else if !role.Valid() || role == todo.RoleOwner {
+		return "", todo.ErrRoleInvalid
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	if err := requireRole(tx, id, userID, todo.RoleOwner); err != nil {
+		return "", err
+	}
+	var member bool
+	row := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM todo.projectMembers m
+	JOIN todo.users u ON u.userID=m.userID
+	WHERE m.projectID=$1 AND u.email=$2)`, id, email)
+	if err := row.Scan(&member); err != nil {
+		tx.Rollback()
+		return "", err
+	} else if member {
+		return "", todo.ErrAlreadyMember
+	}
+	var invitationID todo.InvitationID
+	row = tx.QueryRow("INSERT INTO todo.invitations(projectID, email, role, invitedBy) VALUES($1, $2, $3, $4) RETURNING invitationID", id, email, role, userID)
+	if err := row.Scan(&invitationID); err != nil {
+		tx.Rollback()
+		if isUniqueViolation(err) {
+			return "", todo.ErrInvitationExists
+		}
+		return "", err
+	}
+	// Invitees who already have an account are told about it as well
+	payload := &memberPayload{ProjectID: id, Email: email, Role: role}
+	row = tx.QueryRow("SELECT userID FROM todo.users WHERE email=$1", email)
+	if err := row.Scan(&payload.UserID); err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return "", err
+	}
+	err = writeProjectEvent(tx, todo.EventMemberInvited, id, payload)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if payload.UserID != "" {
+		err = writeEvent(tx, todo.EventMemberInvited, payload.UserID, payload)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+	}
+	return invitationID, nil
+}
+
+func (s *ProjectService) Invitations(userID todo.UserID) (*todo.Invitations, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`SELECT i.invitationID, i.projectID, p.name, i.email, i.role, i.invitedBy, i.timestamp FROM todo.invitations i
+	JOIN todo.projects p ON p.projectID=i.projectID
+	JOIN todo.users u ON u.email=i.email
+	WHERE u.userID=$1 AND u.verifiedAt IS NOT NULL ORDER BY i.timestamp`, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := todo.Invitations{}
+	for rows.Next() {
+		var i todo.Invitation
+		if err := rows.Scan(&i.ID, &i.ProjectID, &i.ProjectName, &i.Email, &i.Role, &i.InvitedBy, &i.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+	return &invitations, nil
+}
+
+func (s *ProjectService) AcceptInvitation(id todo.InvitationID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrInvitationIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	projectID, role, err := takeInvitation(tx, id, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO todo.projectMembers(projectID, userID, role) VALUES($1, $2, $3) ON CONFLICT DO NOTHING", projectID, userID, role)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeProjectEvent(tx, todo.EventMemberJoined, projectID, &memberPayload{ProjectID: projectID, UserID: userID, Role: role})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (s *ProjectService) DeclineInvitation(id todo.InvitationID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrInvitationIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	_, _, err = takeInvitation(tx, id, userID)
+	return err
+}
+
+// takeInvitation deletes an invitation sent to the user's email and
+// returns what it was for. Anyone can sign up with an email address, so
+// it has to have been verified to show the invitation reached the user.
+func takeInvitation(tx *sql.Tx, id todo.InvitationID, userID todo.UserID) (todo.ProjectID, todo.Role, error) {
+	var projectID todo.ProjectID
+	var role todo.Role
+	var verified bool
+	row := tx.QueryRow(`SELECT i.projectID, i.role, u.verifiedAt IS NOT NULL FROM todo.invitations i
+	JOIN todo.users u ON u.email=i.email
+	WHERE i.invitationID=$1 AND u.userID=$2 FOR UPDATE OF i`, id, userID)
+	if err := row.Scan(&projectID, &role, &verified); err == sql.ErrNoRows {
+		return "", "", todo.ErrInvitationNotFound
+	} else if err != nil {
+		return "", "", err
+	} else if !verified {
+		return "", "", todo.ErrEmailNotVerified
+	}
+	if _, err := tx.Exec("DELETE FROM todo.invitations WHERE invitationID=$1", id); err != nil {
+		return "", "", err
+	}
+	return projectID, role, nil
+}
+
+func (s *ProjectService) ChangeRole(id todo.ProjectID, memberID todo.UserID, role todo.Role, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrProjectIDRequired
+	} else if FormatInput(memberID) == "" {
+		return todo.ErrUserIDRequired
+	} else if !role.Valid() || role == todo.RoleOwner {
+		return todo.ErrRoleInvalid
+	} else if memberID == userID {
+		return todo.ErrCannotChangeOwnRole
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	if err := requireRole(tx, id, userID, todo.RoleOwner); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE todo.projectMembers SET role=$3 WHERE projectID=$1 AND userID=$2", id, memberID, role)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := expectRow(res, todo.ErrMemberNotFound); err != nil {
+		return err
+	}
+	err = writeProjectEvent(tx, todo.EventRoleChanged, id, &memberPayload{ProjectID: id, UserID: memberID, Role: role})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (s *ProjectService) LeaveProject(id todo.ProjectID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrProjectIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	role, err := projectRole(tx, id, userID)
+	if err != nil {
+		return err
+	} else if role == todo.RoleOwner {
+		return todo.ErrOwnerCannotLeave
+	}
+	// Tell the member who left as well as the ones who stay
+	err = writeProjectEvent(tx, todo.EventMemberLeft, id, &memberPayload{ProjectID: id, UserID: userID})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM todo.projectMembers WHERE projectID=$1 AND userID=$2", id, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.tasks SET assigneeID=NULL WHERE projectID=$1 AND assigneeID=$2", id, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (s *ProjectService) TransferOwnership(id todo.ProjectID, newOwnerID todo.UserID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrProjectIDRequired
+	} else if FormatInput(newOwnerID) == "" {
+		return todo.ErrUserIDRequired
+	} else if newOwnerID == userID {
+		return todo.ErrCannotChangeOwnRole
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	if err := requireRole(tx, id, userID, todo.RoleOwner); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE todo.projectMembers SET role=$3 WHERE projectID=$1 AND userID=$2", id, newOwnerID, todo.RoleOwner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := expectRow(res, todo.ErrMemberNotFound); err != nil {
+		return err
+	}
+	// The previous owner stays on as an editor
+	_, err = tx.Exec("UPDATE todo.projectMembers SET role=$3 WHERE projectID=$1 AND userID=$2", id, userID, todo.RoleEditor)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.projects SET ownerID=$2 WHERE projectID=$1", id, newOwnerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeProjectEvent(tx, todo.EventOwnershipTransferred, id, &memberPayload{ProjectID: id, UserID: newOwnerID, Role: todo.RoleOwner})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// projectRole returns the user's role in the project. Projects the user is
+// not a member of are reported as not found so their existence isn't leaked.
+func projectRole(tx *sql.Tx, id todo.ProjectID, userID todo.UserID) (todo.Role, error) {
+	var role todo.Role
+	row := tx.QueryRow("SELECT role FROM todo.projectMembers WHERE projectID=$1 AND userID=$2", id, userID)
+	if err := row.Scan(&role); err == sql.ErrNoRows {
+		return "", todo.ErrProjectNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// requireRole checks the user has at least min in the project.
+func requireRole(tx *sql.Tx, id todo.ProjectID, userID todo.UserID, min todo.Role) error {
+	role, err := projectRole(tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !role.AtLeast(min) {
+		return todo.ErrPermissionDenied
+	}
+	return nil
+}
+
+// taskAccess returns the project a task belongs to and the user's role in
+// it. The owner of a task outside of any project has the owner role.
+func taskAccess(tx *sql.Tx, id todo.TaskID, userID todo.UserID) (todo.ProjectID, todo.Role, error) {
+	var ownerID todo.UserID
+	var projectID todo.ProjectID
+	var role todo.Role
+	row := tx.QueryRow(`SELECT t.userID, COALESCE(t.projectID::text, ''), COALESCE(m.role, '') FROM todo.tasks t
+	LEFT JOIN todo.projectMembers m ON m.projectID=t.projectID AND m.userID=$2
+	WHERE t.taskID=$1`, id, userID)
+	if err := row.Scan(&ownerID, &projectID, &role); err == sql.ErrNoRows {
+		return "", "", todo.ErrTaskNotFound
+	} else if err != nil {
+		return "", "", err
+	}
+	if projectID == "" {
+		if ownerID != userID {
+			return "", "", todo.ErrTaskNotFound
+		}
+		return "", todo.RoleOwner, nil
+	}
+	if role == "" {
+		return "", "", todo.ErrTaskNotFound
+	}
+	return projectID, role, nil
+}
+
+// audience returns everyone who should hear about a change in the project,
+// or just the user for changes outside of any project.
+func audience(tx *sql.Tx, projectID todo.ProjectID, userID todo.UserID) ([]todo.UserID, error) {
+	if projectID == "" {
+		return []todo.UserID{userID}, nil
+	}
+	rows, err := tx.Query("SELECT userID FROM todo.projectMembers WHERE projectID=$1", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var userIDs []todo.UserID
+	for rows.Next() {
+		var id todo.UserID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// writeProjectEvent records an event for every member of the project.
+func writeProjectEvent(tx *sql.Tx, event todo.EventType, id todo.ProjectID, data interface{}) error {
+	userIDs, err := audience(tx, id, "")
+	if err != nil {
+		return err
+	}
+	return writeEvents(tx, event, userIDs, data)
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+func nullable(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
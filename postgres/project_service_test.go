@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// roleFixture is a project with a member of each role, another viewer for
+// the owner to manage, and a user outside of it.
+type roleFixture struct {
+	c       *Client
+	project todo.ProjectID
+	owner   todo.UserID
+	member  todo.UserID
+	users   map[string]todo.UserID
+}
+
+func newRoleFixture(t *testing.T, c *Client) *roleFixture {
+	t.Helper()
+	owner, _ := createTestUser(t, c)
+	project, err := c.ProjectService().CreateProject("Roles", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &roleFixture{c: c, project: project, owner: owner, users: map[string]todo.UserID{"owner": owner}}
+	for _, role := range []todo.Role{todo.RoleEditor, todo.RoleCommenter, todo.RoleViewer} {
+		f.users[string(role)] = f.join(t, role)
+	}
+	f.member = f.join(t, todo.RoleViewer)
+	f.users["outsider"], _ = createTestUser(t, c)
+	return f
+}
+
+// join adds a new user to the project with the role, the way users join:
+// by accepting an invitation to their verified email.
+func (f *roleFixture) join(t *testing.T, role todo.Role) todo.UserID {
+	t.Helper()
+	userID, email := createTestUser(t, f.c)
+	if err := f.c.UserService().VerifyEmail(userID, email); err != nil {
+		t.Fatal(err)
+	}
+	id, err := f.c.ProjectService().InviteMember(f.project, email, role, f.owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.c.ProjectService().AcceptInvitation(id, userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// task creates a task in the project.
+func (f *roleFixture) task(t *testing.T) todo.TaskID {
+	t.Helper()
+	id := todo.TaskID(newTestID())
+	if err := f.c.TaskService().CreateTask(id, "Task", f.owner, f.project); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// comment creates a comment by the owner on a task in the project.
+func (f *roleFixture) comment(t *testing.T) todo.CommentID {
+	t.Helper()
+	id, err := f.c.CommentService().CreateComment(f.task(t), "Comment", f.owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// atLeast is what a user should get for an operation that needs the min
+// role. Users outside the project get notFound.
+func atLeast(min todo.Role, notFound error) func(who string) error {
+	return func(who string) error {
+		if who == "outsider" {
+			return notFound
+		} else if !todo.Role(who).AtLeast(min) {
+			return todo.ErrPermissionDenied
+		}
+		return nil
+	}
+}
+
+func TestProjectRoles(t *testing.T) {
+	c := openTestClient(t)
+	tasks := c.TaskService()
+	projects := c.ProjectService()
+	comments := c.CommentService()
+	content := todo.TaskContent("Patched")
+
+	ops := []struct {
+		name string
+		want func(who string) error
+		run  func(t *testing.T, f *roleFixture, userID todo.UserID) error
+	}{
+		// Task operations
+		{"Task", atLeast(todo.RoleViewer, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := tasks.Task(f.task(t), userID)
+			return err
+		}},
+		{"ProjectTasks", atLeast(todo.RoleViewer, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := tasks.ProjectTasks(f.project, userID)
+			return err
+		}},
+		{"CreateTask", atLeast(todo.RoleEditor, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.CreateTask(todo.TaskID(newTestID()), "Task", userID, f.project)
+		}},
+		{"EditTask", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.EditTask(f.task(t), "Edited", userID)
+		}},
+		{"EditTaskStatus", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.EditTaskStatus(f.task(t), true, userID)
+		}},
+		{"AssignTask", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.AssignTask(f.task(t), f.owner, userID)
+		}},
+		{"SetDueDate", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.SetDueDate(f.task(t), "2030-01-01T09:00:00Z", todo.RecurrenceWeekly, userID)
+		}},
+		{"PatchTask", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := tasks.PatchTask(f.task(t), &todo.TaskPatch{Content: &content}, userID)
+			return err
+		}},
+		{"RunBatch", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			results, err := tasks.RunBatch([]todo.BatchOp{{Type: todo.BatchPatch, ID: f.task(t), Patch: &todo.TaskPatch{Content: &content}}}, true, userID)
+			if err != nil {
+				return err
+			}
+			return results[0].Err
+		}},
+		{"DeleteTask", atLeast(todo.RoleEditor, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.DeleteTask(f.task(t), userID)
+		}},
+		{"ToggleAll", atLeast(todo.RoleEditor, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.ToggleAll(true, userID, f.project)
+		}},
+		{"ClearCompleted", atLeast(todo.RoleEditor, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return tasks.ClearCompleted(userID, f.project)
+		}},
+		{"Comments", atLeast(todo.RoleViewer, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := comments.Comments(f.task(t), userID)
+			return err
+		}},
+		{"CreateComment", atLeast(todo.RoleCommenter, todo.ErrTaskNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := comments.CreateComment(f.task(t), "Comment", userID)
+			return err
+		}},
+		// Only the owner can delete other people's comments
+		{"DeleteComment", atLeast(todo.RoleOwner, todo.ErrCommentNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return comments.DeleteComment(f.comment(t), userID)
+		}},
+
+		// Project operations
+		{"Role", atLeast(todo.RoleViewer, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := projects.Role(f.project, userID)
+			return err
+		}},
+		{"Members", atLeast(todo.RoleViewer, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, err := projects.Members(f.project, userID)
+			return err
+		}},
+		{"InviteMember", atLeast(todo.RoleOwner, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			_, email := createTestUser(t, f.c)
+			_, err := projects.InviteMember(f.project, email, todo.RoleViewer, userID)
+			return err
+		}},
+		{"ChangeRole", atLeast(todo.RoleOwner, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return projects.ChangeRole(f.project, f.member, todo.RoleEditor, userID)
+		}},
+		{"TransferOwnership", atLeast(todo.RoleOwner, todo.ErrProjectNotFound), func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return projects.TransferOwnership(f.project, f.member, userID)
+		}},
+		{"LeaveProject", func(who string) error {
+			switch who {
+			case "outsider":
+				return todo.ErrProjectNotFound
+			case "owner":
+				return todo.ErrOwnerCannotLeave
+			}
+			return nil
+		}, func(t *testing.T, f *roleFixture, userID todo.UserID) error {
+			return projects.LeaveProject(f.project, userID)
+		}},
+	}
+	for _, op := range ops {
+		for _, who := range []string{"owner", "editor", "commenter", "viewer", "outsider"} {
+			t.Run(op.name+"/"+who, func(t *testing.T) {
+				f := newRoleFixture(t, c)
+				if got, want := op.run(t, f, f.users[who]), op.want(who); got != want {
+					t.Errorf("%s as %s = %v, want %v", op.name, who, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestAcceptInvitationNeedsVerifiedEmail(t *testing.T) {
+	c := openTestClient(t)
+	projects := c.ProjectService()
+	owner, _ := createTestUser(t, c)
+	project, err := projects.CreateProject("Invitations", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whoever signed up with the address hasn't shown it's theirs
+	userID, email := createTestUser(t, c)
+	id, err := projects.InviteMember(project, email, todo.RoleEditor, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invitations, err := projects.Invitations(userID); err != nil {
+		t.Fatal(err)
+	} else if len(*invitations) != 0 {
+		t.Errorf("unverified user sees %d invitations, want 0", len(*invitations))
+	}
+	if err := projects.AcceptInvitation(id, userID); err != todo.ErrEmailNotVerified {
+		t.Errorf("AcceptInvitation() unverified = %v, want %v", err, todo.ErrEmailNotVerified)
+	}
+	if err := projects.DeclineInvitation(id, userID); err != todo.ErrEmailNotVerified {
+		t.Errorf("DeclineInvitation() unverified = %v, want %v", err, todo.ErrEmailNotVerified)
+	}
+	if _, err := projects.Role(project, userID); err != todo.ErrProjectNotFound {
+		t.Errorf("Role() after refused invitation = %v, want %v", err, todo.ErrProjectNotFound)
+	}
+
+	if err := c.UserService().VerifyEmail(userID, email); err != nil {
+		t.Fatal(err)
+	}
+	if err := projects.AcceptInvitation(id, userID); err != nil {
+		t.Fatalf("AcceptInvitation() verified = %v", err)
+	}
+	if role, err := projects.Role(project, userID); err != nil || role != todo.RoleEditor {
+		t.Errorf("Role() = %s, %v, want %s", role, err, todo.RoleEditor)
+	}
+	// An invitation can only be used once
+	if err := projects.AcceptInvitation(id, userID); err != todo.ErrInvitationNotFound {
+		t.Errorf("AcceptInvitation() again = %v, want %v", err, todo.ErrInvitationNotFound)
+	}
+}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.RefreshTokenService = &RefreshTokenService{}
+
+type RefreshTokenService struct {
+	client *Client
+}
+
+// CreateRefreshToken starts a new family for a login.
+func (s *RefreshTokenService) CreateRefreshToken(userID todo.UserID, tokenHash string, expiresAt time.Time) error {
+	if FormatInput(userID) == "" {
+		return todo.ErrUserIDRequired
+	} else if FormatInput(tokenHash) == "" {
+		return todo.ErrRefreshTokenRequired
+	}
+	_, err := s.client.db.Exec(`INSERT INTO todo.refreshTokens(tokenHash, familyID, userID, expiresAt)
+	VALUES($1, uuid_generate_v4(), $2, $3)`, tokenHash, userID, expiresAt)
+	return err
+}
+
+// RotateRefreshToken uses up a refresh token and replaces it with a new one
+// in the same family. Reusing a token revokes its family, the revocation is
+// committed even though an error is returned.
+func (s *RefreshTokenService) RotateRefreshToken(tokenHash string, newTokenHash string, expiresAt time.Time) (todo.UserID, error) {
+	if FormatInput(tokenHash) == "" || FormatInput(newTokenHash) == "" {
+		return "", todo.ErrRefreshTokenRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	var familyID string
+	var userID todo.UserID
+	var usedAt, revokedAt pq.NullTime
+	var expired bool
+	row := tx.QueryRow(`SELECT familyID, userID, usedAt, revokedAt, expiresAt <= current_timestamp
+	FROM todo.refreshTokens WHERE tokenHash=$1 FOR UPDATE`, tokenHash)
+	err = row.Scan(&familyID, &userID, &usedAt, &revokedAt, &expired)
+	if err == sql.ErrNoRows || (err == nil && (revokedAt.Valid || expired)) {
+		tx.Rollback()
+		return "", todo.ErrRefreshTokenInvalid
+	} else if err != nil {
+		tx.Rollback()
+		return "", err
+	} else if usedAt.Valid {
+		if err := revokeFamily(tx, familyID); err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+		return "", todo.ErrRefreshTokenReused
+	}
+	_, err = tx.Exec("UPDATE todo.refreshTokens SET usedAt=current_timestamp WHERE tokenHash=$1", tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.Exec(`INSERT INTO todo.refreshTokens(tokenHash, familyID, userID, expiresAt)
+	VALUES($1, $2, $3, $4)`, newTokenHash, familyID, userID, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// RevokeRefreshToken logs out the login the token came from by revoking
+// its whole family.
+func (s *RefreshTokenService) RevokeRefreshToken(tokenHash string) error {
+	if FormatInput(tokenHash) == "" {
+		return todo.ErrRefreshTokenRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	var familyID string
+	row := tx.QueryRow("SELECT familyID FROM todo.refreshTokens WHERE tokenHash=$1", tokenHash)
+	if err := row.Scan(&familyID); err == sql.ErrNoRows {
+		tx.Rollback()
+		return todo.ErrRefreshTokenInvalid
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := revokeFamily(tx, familyID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func revokeFamily(tx *sql.Tx, familyID string) error {
+	_, err := tx.Exec("UPDATE todo.refreshTokens SET revokedAt=current_timestamp WHERE familyID=$1 AND revokedAt IS NULL", familyID)
+	return err
+}
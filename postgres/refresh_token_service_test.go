@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	c := openTestClient(t)
+	s := c.RefreshTokenService()
+	userID, _ := createTestUser(t, c)
+	expiresAt := time.Now().Add(time.Hour)
+	first, second, third := newTestID(), newTestID(), newTestID()
+
+	if err := s.CreateRefreshToken(userID, first, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.RotateRefreshToken(first, second, expiresAt); err != nil {
+		t.Fatal(err)
+	} else if id != userID {
+		t.Errorf("rotated for %s, want %s", id, userID)
+	}
+	// Another login's family isn't touched by the reuse
+	other := newTestID()
+	if err := s.CreateRefreshToken(userID, other, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RotateRefreshToken(first, third, expiresAt); err != todo.ErrRefreshTokenReused {
+		t.Fatalf("reusing a token: err = %v, want %v", err, todo.ErrRefreshTokenReused)
+	}
+	// The newest token in the family is revoked and nothing was issued
+	for _, token := range []string{second, third} {
+		if _, err := s.RotateRefreshToken(token, newTestID(), expiresAt); err != todo.ErrRefreshTokenInvalid {
+			t.Errorf("rotating %s after reuse: err = %v, want %v", token, err, todo.ErrRefreshTokenInvalid)
+		}
+	}
+	if _, err := s.RotateRefreshToken(other, newTestID(), expiresAt); err != nil {
+		t.Errorf("other family: %v", err)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	c := openTestClient(t)
+	s := c.RefreshTokenService()
+	userID, _ := createTestUser(t, c)
+	expiresAt := time.Now().Add(time.Hour)
+	first, second := newTestID(), newTestID()
+
+	if err := s.CreateRefreshToken(userID, first, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateRefreshToken(first, second, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	// Logging out with an old token ends the whole login
+	if err := s.RevokeRefreshToken(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RotateRefreshToken(second, newTestID(), expiresAt); err != todo.ErrRefreshTokenInvalid {
+		t.Errorf("err = %v, want %v", err, todo.ErrRefreshTokenInvalid)
+	}
+	if err := s.RevokeRefreshToken(newTestID()); err != todo.ErrRefreshTokenInvalid {
+		t.Errorf("unknown token: err = %v, want %v", err, todo.ErrRefreshTokenInvalid)
+	}
+}
@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.ReminderService = &ReminderService{}
+
+type ReminderService struct {
+	client *Client
+}
+
+func (s *ReminderService) Reminders(taskID todo.TaskID, userID todo.UserID) (*todo.Reminders, error) {
+	if FormatInput(taskID) == "" {
+		return nil, todo.ErrTaskIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := taskAccess(tx, taskID, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT reminderID, taskID, userID, minutesBefore, atTime, timezone, channels,
+	COALESCE(to_char(fireAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), timestamp
+	FROM todo.reminders WHERE taskID=$1 AND userID=$2 ORDER BY timestamp`, taskID, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	reminders := todo.Reminders{}
+	for rows.Next() {
+		var r todo.Reminder
+		if err := rows.Scan(&r.ID, &r.TaskID, &r.UserID, &r.MinutesBefore, &r.At, &r.Timezone, pq.Array(&r.Channels), &r.FireAt, &r.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &reminders, nil
+}
+
+// CreateReminder adds a reminder for the user on a task they can see. It is
+// scheduled straight away if the task has a due date.
+func (s *ReminderService) CreateReminder(taskID todo.TaskID, r *todo.Reminder, userID todo.UserID) (todo.ReminderID, error) {
+	if FormatInput(taskID) == "" {
+		return "", todo.ErrTaskIDRequired
+	} else if err := validateReminder(r); err != nil {
+		return "", err
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := taskAccess(tx, taskID, userID); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	var due pq.NullTime
+	var recurrence todo.Recurrence
+	row := tx.QueryRow("SELECT dueAt, recurrence FROM todo.tasks WHERE taskID=$1", taskID)
+	if err := row.Scan(&due, &recurrence); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	fireAt, occurrence, err := scheduleReminder(r, due, recurrence, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	var id todo.ReminderID
+	row = tx.QueryRow(`INSERT INTO todo.reminders(taskID, userID, minutesBefore, atTime, timezone, channels, fireAt, occurrence)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING reminderID`,
+		taskID, userID, r.MinutesBefore, r.At, r.Timezone, pq.Array(r.Channels), fireAt, occurrence)
+	if err := row.Scan(&id); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *ReminderService) DeleteReminder(id todo.ReminderID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrReminderIDRequired
+	}
+	res, err := s.client.db.Exec("DELETE FROM todo.reminders WHERE reminderID=$1 AND userID=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrReminderNotFound)
+}
+
+// ClaimReminders locks reminders that are due to fire and pushes them back by
+// the lease so other schedulers skip them while they are being sent.
+// Reminders on completed tasks, or on tasks the user can no longer see, are
+// left alone.
+func (s *ReminderService) ClaimReminders(limit int, lease time.Duration) (*todo.DueReminders, error) {
+	rows, err := s.client.db.Query(`UPDATE todo.reminders r SET fireAt=current_timestamp + $2 * interval '1 millisecond'
+	FROM (
+		SELECT r.reminderID, t.content, COALESCE(t.projectID::text, '') AS projectID,
+		COALESCE(t.assigneeID::text, '') AS assigneeID, t.recurrence, u.email
+		FROM todo.reminders r
+		JOIN todo.tasks t ON t.taskID=r.taskID
+		JOIN todo.users u ON u.userID=r.userID
+		WHERE r.fireAt <= current_timestamp AND NOT t.completed
+		AND ((t.projectID IS NULL AND t.userID=r.userID)
+		OR EXISTS(SELECT 1 FROM todo.projectMembers m WHERE m.projectID=t.projectID AND m.userID=r.userID))
+		ORDER BY r.fireAt
+		LIMIT $1
+		FOR UPDATE OF r SKIP LOCKED
+	) due
+	WHERE r.reminderID=due.reminderID
+	RETURNING r.reminderID, r.taskID, r.userID, r.minutesBefore, r.atTime, r.timezone, r.channels, r.occurrence, r.timestamp,
+	due.content, due.projectID, due.assigneeID, due.recurrence, due.email`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reminders := todo.DueReminders{}
+	for rows.Next() {
+		var r todo.DueReminder
+		var occurrence time.Time
+		if err := rows.Scan(&r.ID, &r.TaskID, &r.UserID, &r.MinutesBefore, &r.At, &r.Timezone, pq.Array(&r.Channels), &occurrence, &r.Timestamp,
+			&r.Task.Content, &r.Task.ProjectID, &r.Task.AssigneeID, &r.Task.Recurrence, &r.Email); err != nil {
+			return nil, err
+		}
+		r.Occurrence = occurrence.UTC().Format(time.RFC3339)
+		r.Task.ID = r.TaskID
+		r.Task.DueAt = r.Occurrence
+		reminders = append(reminders, r)
+	}
+	return &reminders, nil
+}
+
+// ReminderSent schedules the reminder for the next occurrence of its task, or
+// stops it if the task doesn't repeat. Nothing happens if the task has been
+// rescheduled since the reminder was claimed.
+func (s *ReminderService) ReminderSent(id todo.ReminderID, occurrence string) error {
+	occ, err := time.Parse(time.RFC3339, occurrence)
+	if err != nil {
+		return err
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	var r todo.Reminder
+	var recurrence todo.Recurrence
+	row := tx.QueryRow(`SELECT r.minutesBefore, r.atTime, r.timezone, t.recurrence FROM todo.reminders r
+	JOIN todo.tasks t ON t.taskID=r.taskID
+	WHERE r.reminderID=$1 AND r.occurrence=$2
+	FOR UPDATE OF r`, id, occ)
+	if err := row.Scan(&r.MinutesBefore, &r.At, &r.Timezone, &recurrence); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	sent, err := r.FireTime(occ)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	fireAt, next, err := scheduleReminder(&r, pq.NullTime{Time: occ, Valid: true}, recurrence, sent)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.reminders SET fireAt=$2, occurrence=$3 WHERE reminderID=$1", id, fireAt, next)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// rescheduleReminders recomputes every reminder on a task after its due date
+// or recurrence has changed.
+func rescheduleReminders(tx *sql.Tx, taskID todo.TaskID, due *time.Time, recurrence todo.Recurrence) error {
+	rows, err := tx.Query("SELECT reminderID, minutesBefore, atTime, timezone FROM todo.reminders WHERE taskID=$1 FOR UPDATE", taskID)
+	if err != nil {
+		return err
+	}
+	var reminders todo.Reminders
+	for rows.Next() {
+		var r todo.Reminder
+		if err := rows.Scan(&r.ID, &r.MinutesBefore, &r.At, &r.Timezone); err != nil {
+			rows.Close()
+			return err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	dueAt := pq.NullTime{}
+	if due != nil {
+		dueAt = pq.NullTime{Time: *due, Valid: true}
+	}
+	now := time.Now()
+	for _, r := range reminders {
+		fireAt, occurrence, err := scheduleReminder(&r, dueAt, recurrence, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE todo.reminders SET fireAt=$2, occurrence=$3 WHERE reminderID=$1", r.ID, fireAt, occurrence)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduleReminder returns the next fire time after after and the occurrence
+// it is for, both nil when the reminder has nothing left to fire for.
+func scheduleReminder(r *todo.Reminder, due pq.NullTime, recurrence todo.Recurrence, after time.Time) (*time.Time, *time.Time, error) {
+	if !due.Valid {
+		return nil, nil, nil
+	}
+	fire, occurrence, ok, err := r.NextFire(due.Time, recurrence, after)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	return &fire, &occurrence, nil
+}
+
+func validateReminder(r *todo.Reminder) error {
+	if r.MinutesBefore < 0 || (r.MinutesBefore > 0) == (r.At != "") {
+		return todo.ErrReminderInvalid
+	} else if r.At != "" {
+		if _, err := time.Parse("15:04", r.At); err != nil {
+			return todo.ErrReminderTimeInvalid
+		}
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return todo.ErrTimezoneInvalid
+	}
+	if len(r.Channels) == 0 {
+		r.Channels = []string{todo.ChannelInApp}
+	}
+	for _, c := range r.Channels {
+		switch c {
+		case todo.ChannelInApp, todo.ChannelEmail, todo.ChannelWebhook:
+		default:
+			return todo.ErrReminderChannelUnknown
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package postgres
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/kennedymj97/todo-api"
 )
 
@@ -10,31 +13,129 @@ type TaskService struct {
 	client *Client
 }
 
+// Event payloads written to the outbox.
+type taskPayload struct {
+	ID        todo.TaskID      `json:"id,omitempty"`
+	ProjectID todo.ProjectID   `json:"projectId,omitempty"`
+	Content   todo.TaskContent `json:"content,omitempty"`
+}
+
+type taskAssignedPayload struct {
+	ID                 todo.TaskID    `json:"id"`
+	ProjectID          todo.ProjectID `json:"projectId,omitempty"`
+	AssigneeID         todo.UserID    `json:"assigneeId,omitempty"`
+	PreviousAssigneeID todo.UserID    `json:"previousAssigneeId,omitempty"`
+	AssignedBy         todo.UserID    `json:"assignedBy"`
+}
+
+type taskDuePayload struct {
+	ID         todo.TaskID     `json:"id"`
+	ProjectID  todo.ProjectID  `json:"projectId,omitempty"`
+	DueAt      string          `json:"dueAt"`
+	Recurrence todo.Recurrence `json:"recurrence"`
+}
+
+type taskStatusPayload struct {
+	ID        todo.TaskID    `json:"id,omitempty"`
+	ProjectID todo.ProjectID `json:"projectId,omitempty"`
+	Val       bool           `json:"val"`
+}
+
+// Tasks returns the user's own tasks and the tasks of every project they
+// are a member of.
 func (s *TaskService) Tasks(id todo.UserID) (*todo.Tasks, error) {
 	tx, err := s.client.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Commit()
-	rows, err := tx.Query("SELECT taskID, content, completed, timestamp FROM todo.tasks WHERE userID=$1", id)
+	rows, err := tx.Query(`SELECT `+taskColumns+` FROM todo.tasks
+	WHERE (projectID IS NULL AND userID=$1)
+	OR projectID IN (SELECT projectID FROM todo.projectMembers WHERE userID=$1)`, id)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
-	defer rows.Close()
-	var todos todo.Tasks
-	for rows.Next() {
-		tempTask := &todo.Task{}
-		if err := rows.Scan(&tempTask.ID, &tempTask.Content, &tempTask.Completed, &tempTask.Timestamp); err != nil {
-			tx.Rollback()
-			return nil, err
-		}
-		todos = append(todos, *tempTask)
+	todos, err := scanTasks(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
-	return &todos, nil
+	return todos, nil
 }
 
-func (s *TaskService) CreateTask(taskID todo.TaskID, content todo.TaskContent, userID todo.UserID) error {
+func (s *TaskService) ProjectTasks(id todo.ProjectID, userID todo.UserID) (*todo.Tasks, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrProjectIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	if _, err := projectRole(tx, id, userID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query("SELECT "+taskColumns+" FROM todo.tasks WHERE projectID=$1", id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	todos, err := scanTasks(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return todos, nil
+}
+
+// AssignedTasks returns the tasks assigned to the user across every project
+// they are still a member of.
+func (s *TaskService) AssignedTasks(userID todo.UserID) (*todo.Tasks, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`SELECT `+taskColumns+` FROM todo.tasks
+	WHERE assigneeID=$1 AND ((projectID IS NULL AND userID=$1)
+	OR projectID IN (SELECT projectID FROM todo.projectMembers WHERE userID=$1))`, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	todos, err := scanTasks(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return todos, nil
+}
+
+// Task returns a task the user can see.
+func (s *TaskService) Task(id todo.TaskID, userID todo.UserID) (*todo.Task, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrTaskIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	if _, _, err := taskAccess(tx, id, userID); err != nil {
+		return nil, err
+	}
+	task, err := loadTask(tx, id, false)
+	if err == todo.ErrTaskNotFound {
+		return nil, err
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return task, nil
+}
+
+func (s *TaskService) CreateTask(taskID todo.TaskID, content todo.TaskContent, userID todo.UserID, projectID todo.ProjectID) error {
 	if FormatInput(content) == "" {
 		return todo.ErrTaskContentRequired
 	}
@@ -43,15 +144,34 @@ func (s *TaskService) CreateTask(taskID todo.TaskID, content todo.TaskContent, u
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("INSERT INTO todo.tasks(taskID, userID, content) VALUES($1, $2, $3)", taskID, userID, content)
-	if err != nil {
+	if err := createTask(tx, taskID, content, userID, projectID); err != nil {
 		tx.Rollback()
 		return err
 	}
 	return nil
 }
 
-func (s *TaskService) EditTaskStatus(id todo.TaskID, val bool) error {
+func createTask(tx *sql.Tx, taskID todo.TaskID, content todo.TaskContent, userID todo.UserID, projectID todo.ProjectID) error {
+	if FormatInput(content) == "" {
+		return todo.ErrTaskContentRequired
+	}
+	if projectID != "" {
+		if err := requireRole(tx, projectID, userID, todo.RoleEditor); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("INSERT INTO todo.tasks(taskID, userID, projectID, content) VALUES($1, $2, $3, $4)", taskID, userID, nullable(string(projectID)), content)
+	if err != nil {
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		return err
+	}
+	return writeEvents(tx, todo.EventTaskCreated, userIDs, &taskPayload{ID: taskID, ProjectID: projectID, Content: content})
+}
+
+func (s *TaskService) EditTaskStatus(id todo.TaskID, val bool, userID todo.UserID) error {
 	if FormatInput(id) == "" {
 		return todo.ErrTaskIDRequired
 	}
@@ -60,21 +180,55 @@ func (s *TaskService) EditTaskStatus(id todo.TaskID, val bool) error {
 		return err
 	}
 	defer tx.Commit()
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	}
 	_, err = tx.Exec("UPDATE todo.tasks SET completed=$1 WHERE taskID=$2", val, id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTaskStatus, userIDs, &taskStatusPayload{ID: id, ProjectID: projectID, Val: val})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	return nil
 }
 
-func (s *TaskService) ToggleAll(val bool) error {
+// ToggleAll sets the status of every task in the project, or of the user's
+// own tasks when no project is given.
+func (s *TaskService) ToggleAll(val bool, userID todo.UserID, projectID todo.ProjectID) error {
 	tx, err := s.client.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("UPDATE todo.tasks SET completed=$1", val)
+	if projectID == "" {
+		_, err = tx.Exec("UPDATE todo.tasks SET completed=$1 WHERE projectID IS NULL AND userID=$2", val, userID)
+	} else if err = requireRole(tx, projectID, userID, todo.RoleEditor); err != nil {
+		return err
+	} else {
+		_, err = tx.Exec("UPDATE todo.tasks SET completed=$1 WHERE projectID=$2", val, projectID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTasksToggled, userIDs, &taskStatusPayload{ProjectID: projectID, Val: val})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -82,7 +236,7 @@ func (s *TaskService) ToggleAll(val bool) error {
 	return nil
 }
 
-func (s *TaskService) EditTask(id todo.TaskID, newContent todo.TaskContent) error {
+func (s *TaskService) EditTask(id todo.TaskID, newContent todo.TaskContent, userID todo.UserID) error {
 	if FormatInput(id) == "" {
 		return todo.ErrTaskIDRequired
 	} else if FormatInput(newContent) == "" {
@@ -93,24 +247,76 @@ func (s *TaskService) EditTask(id todo.TaskID, newContent todo.TaskContent) erro
 		return err
 	}
 	defer tx.Commit()
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	}
 	_, err = tx.Exec("UPDATE todo.tasks SET content=$1 WHERE taskID=$2", newContent, id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTaskUpdated, userIDs, &taskPayload{ID: id, ProjectID: projectID, Content: newContent})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	return nil
 }
 
-func (s *TaskService) DeleteTask(id todo.TaskID) error {
+// SetDueDate sets when the task is due and how often it repeats, an empty
+// dueAt clears both. The task's reminders are rescheduled to match.
+func (s *TaskService) SetDueDate(id todo.TaskID, dueAt string, recurrence todo.Recurrence, userID todo.UserID) error {
+	var due *time.Time
 	if FormatInput(id) == "" {
 		return todo.ErrTaskIDRequired
+	} else if !recurrence.Valid() {
+		return todo.ErrRecurrenceInvalid
+	} else if FormatInput(dueAt) != "" {
+		t, err := time.Parse(time.RFC3339, dueAt)
+		if err != nil {
+			return todo.ErrDueDateInvalid
+		}
+		t = t.UTC()
+		due = &t
+		dueAt = t.Format(time.RFC3339)
+	} else if recurrence != todo.RecurrenceNone {
+		return todo.ErrDueDateRequired
 	}
 	tx, err := s.client.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("DELETE FROM todo.tasks WHERE taskid=$1", id)
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	}
+	_, err = tx.Exec("UPDATE todo.tasks SET dueAt=$1, recurrence=$2 WHERE taskID=$3", due, string(recurrence), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = rescheduleReminders(tx, id, due, recurrence)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTaskUpdated, userIDs, &taskDuePayload{ID: id, ProjectID: projectID, DueAt: dueAt, Recurrence: recurrence})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -118,15 +324,447 @@ func (s *TaskService) DeleteTask(id todo.TaskID) error {
 	return nil
 }
 
-func (s *TaskService) ClearCompleted() error {
+// AssignTask assigns the task to a member of its project, an empty
+// assigneeID unassigns it. Tasks outside of a project can only be assigned
+// to their owner.
+func (s *TaskService) AssignTask(id todo.TaskID, assigneeID todo.UserID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrTaskIDRequired
+	}
 	tx, err := s.client.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("DELETE FROM todo.tasks WHERE completed=true")
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	}
+	if err := checkAssignee(tx, projectID, assigneeID, userID); err == todo.ErrAssigneeNotMember {
+		return err
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var previous todo.UserID
+	row := tx.QueryRow(`UPDATE todo.tasks t SET assigneeID=$2 FROM todo.tasks old
+	WHERE t.taskID=$1 AND old.taskID=t.taskID
+	RETURNING COALESCE(old.assigneeID::text, '')`, id, nullable(string(assigneeID)))
+	if err := row.Scan(&previous); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if previous == assigneeID {
+		return nil
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTaskAssigned, userIDs, &taskAssignedPayload{
+		ID:                 id,
+		ProjectID:          projectID,
+		AssigneeID:         assigneeID,
+		PreviousAssigneeID: previous,
+		AssignedBy:         userID,
+	})
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	return nil
 }
+
+// checkAssignee returns ErrAssigneeNotMember unless the task can be assigned
+// to assigneeID. Tasks outside of a project can only be assigned to their
+// owner.
+func checkAssignee(tx *sql.Tx, projectID todo.ProjectID, assigneeID todo.UserID, userID todo.UserID) error {
+	if assigneeID == "" {
+		return nil
+	} else if projectID == "" {
+		if assigneeID != userID {
+			return todo.ErrAssigneeNotMember
+		}
+		return nil
+	}
+	if _, err := projectRole(tx, projectID, assigneeID); err == todo.ErrProjectNotFound {
+		return todo.ErrAssigneeNotMember
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PatchTask makes every change in the patch or, if any of them fails, none
+// of them. It returns the updated task.
+func (s *TaskService) PatchTask(id todo.TaskID, patch *todo.TaskPatch, userID todo.UserID) (*todo.Task, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrTaskIDRequired
+	} else if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+	return s.PatchTaskFunc(id, func(*todo.Task) (*todo.TaskPatch, error) {
+		return patch, nil
+	}, userID)
+}
+
+func (s *TaskService) PatchTaskFunc(id todo.TaskID, makePatch func(task *todo.Task) (*todo.TaskPatch, error), userID todo.UserID) (*todo.Task, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrTaskIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	task, err := patchTaskFunc(tx, id, makePatch, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// patchTask changes the task in tx and writes an event for each kind of
+// change. The caller rolls back on an error.
+func patchTask(tx *sql.Tx, id todo.TaskID, patch *todo.TaskPatch, userID todo.UserID) (*todo.Task, error) {
+	return patchTaskFunc(tx, id, func(*todo.Task) (*todo.TaskPatch, error) {
+		return patch, nil
+	}, userID)
+}
+
+// patchTaskFunc is patchTask with the patch made from the task once its row
+// is locked.
+func patchTaskFunc(tx *sql.Tx, id todo.TaskID, makePatch func(task *todo.Task) (*todo.TaskPatch, error), userID todo.UserID) (*todo.Task, error) {
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return nil, err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return nil, todo.ErrPermissionDenied
+	}
+	old, err := loadTask(tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := makePatch(old)
+	if err != nil {
+		return nil, err
+	} else if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+	task := *old
+	if patch.Content != nil {
+		task.Content = todo.TaskContent(FormatInput(*patch.Content))
+	}
+	if patch.Completed != nil {
+		task.Completed = *patch.Completed
+	}
+	if patch.AssigneeID != nil {
+		task.AssigneeID = *patch.AssigneeID
+		if err := checkAssignee(tx, projectID, task.AssigneeID, userID); err != nil {
+			return nil, err
+		}
+	}
+	if patch.DueAt != nil {
+		task.DueAt = ""
+		if *patch.DueAt != "" {
+			t, err := time.Parse(time.RFC3339, *patch.DueAt)
+			if err != nil {
+				return nil, todo.ErrDueDateInvalid
+			}
+			task.DueAt = t.UTC().Format(time.RFC3339)
+		}
+	}
+	if patch.Recurrence != nil {
+		task.Recurrence = *patch.Recurrence
+	}
+	if task.Recurrence != todo.RecurrenceNone && task.DueAt == "" {
+		return nil, todo.ErrDueDateRequired
+	}
+	var due *time.Time
+	if task.DueAt != "" {
+		t, _ := time.Parse(time.RFC3339, task.DueAt)
+		due = &t
+	}
+	_, err = tx.Exec(`UPDATE todo.tasks SET content=$2, completed=$3, assigneeID=$4, dueAt=$5, recurrence=$6
+	WHERE taskID=$1`, id, task.Content, task.Completed, nullable(string(task.AssigneeID)), due, string(task.Recurrence))
+	if err != nil {
+		return nil, err
+	}
+	dueChanged := task.DueAt != old.DueAt || task.Recurrence != old.Recurrence
+	if dueChanged {
+		if err := rescheduleReminders(tx, id, due, task.Recurrence); err != nil {
+			return nil, err
+		}
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if task.Content != old.Content {
+		err := writeEvents(tx, todo.EventTaskUpdated, userIDs, &taskPayload{ID: id, ProjectID: projectID, Content: task.Content})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if task.Completed != old.Completed {
+		err := writeEvents(tx, todo.EventTaskStatus, userIDs, &taskStatusPayload{ID: id, ProjectID: projectID, Val: task.Completed})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if task.AssigneeID != old.AssigneeID {
+		err := writeEvents(tx, todo.EventTaskAssigned, userIDs, &taskAssignedPayload{
+			ID:                 id,
+			ProjectID:          projectID,
+			AssigneeID:         task.AssigneeID,
+			PreviousAssigneeID: old.AssigneeID,
+			AssignedBy:         userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dueChanged {
+		err := writeEvents(tx, todo.EventTaskUpdated, userIDs, &taskDuePayload{ID: id, ProjectID: projectID, DueAt: task.DueAt, Recurrence: task.Recurrence})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return loadTask(tx, id, false)
+}
+
+// loadTask reads a task without checking who can see it, locking its row
+// if forUpdate is set.
+func loadTask(tx *sql.Tx, id todo.TaskID, forUpdate bool) (*todo.Task, error) {
+	query := "SELECT " + taskColumns + " FROM todo.tasks WHERE taskID=$1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		return nil, err
+	} else if len(*tasks) == 0 {
+		return nil, todo.ErrTaskNotFound
+	}
+	return &(*tasks)[0], nil
+}
+
+func (s *TaskService) DeleteTask(id todo.TaskID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrTaskIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	if err := deleteTask(tx, id, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func deleteTask(tx *sql.Tx, id todo.TaskID, userID todo.UserID) error {
+	projectID, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	}
+	_, err = tx.Exec("DELETE FROM todo.tasks WHERE taskid=$1", id)
+	if err != nil {
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		return err
+	}
+	return writeEvents(tx, todo.EventTaskDeleted, userIDs, &taskPayload{ID: id, ProjectID: projectID})
+}
+
+// moveTask moves the task into another project, or out of its project when
+// projectID is empty, which makes the user its owner. The assignee is
+// cleared if they can't be assigned to it any more. Members of the old
+// project see the task deleted and members of the new one see it created.
+func moveTask(tx *sql.Tx, id todo.TaskID, projectID todo.ProjectID, userID todo.UserID) error {
+	from, role, err := taskAccess(tx, id, userID)
+	if err != nil {
+		return err
+	} else if !role.AtLeast(todo.RoleEditor) {
+		return todo.ErrPermissionDenied
+	} else if from == projectID {
+		return nil
+	}
+	if projectID != "" {
+		if err := requireRole(tx, projectID, userID, todo.RoleEditor); err != nil {
+			return err
+		}
+	}
+	task, err := loadTask(tx, id, true)
+	if err != nil {
+		return err
+	}
+	assigneeID := task.AssigneeID
+	if err := checkAssignee(tx, projectID, assigneeID, userID); err == todo.ErrAssigneeNotMember {
+		assigneeID = ""
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE todo.tasks SET projectID=$2, assigneeID=$3,
+	userID=CASE WHEN $2::uuid IS NULL THEN $4 ELSE userID END
+	WHERE taskID=$1`, id, nullable(string(projectID)), nullable(string(assigneeID)), userID)
+	if err != nil {
+		return err
+	}
+	userIDs, err := audience(tx, from, userID)
+	if err != nil {
+		return err
+	}
+	err = writeEvents(tx, todo.EventTaskDeleted, userIDs, &taskPayload{ID: id, ProjectID: from})
+	if err != nil {
+		return err
+	}
+	userIDs, err = audience(tx, projectID, userID)
+	if err != nil {
+		return err
+	}
+	return writeEvents(tx, todo.EventTaskCreated, userIDs, &taskPayload{ID: id, ProjectID: projectID, Content: task.Content})
+}
+
+// RunBatch runs the operations in order in one transaction. An atomic batch
+// stops at the first operation that fails and undoes the rest, whose
+// results are ErrBatchRolledBack. Otherwise each failed operation is undone
+// on its own and the others still run.
+func (s *TaskService) RunBatch(ops []todo.BatchOp, atomic bool, userID todo.UserID) ([]todo.BatchResult, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]todo.BatchResult, len(ops))
+	for i := range ops {
+		if !atomic {
+			if _, err := tx.Exec("SAVEPOINT batch_op"); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+		task, err := runBatchOp(tx, &ops[i], userID)
+		results[i] = todo.BatchResult{Task: task, Err: err}
+		if err == nil && atomic {
+			continue
+		} else if err == nil {
+			if _, err := tx.Exec("RELEASE SAVEPOINT batch_op"); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			continue
+		} else if atomic {
+			tx.Rollback()
+			for j := range results {
+				if j != i {
+					results[j] = todo.BatchResult{Err: todo.ErrBatchRolledBack}
+				}
+			}
+			return results, nil
+		}
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT batch_op"); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runBatchOp returns the task as the operation left it, or nil if it was
+// deleted.
+func runBatchOp(tx *sql.Tx, op *todo.BatchOp, userID todo.UserID) (*todo.Task, error) {
+	if FormatInput(op.ID) == "" {
+		return nil, todo.ErrTaskIDRequired
+	}
+	switch op.Type {
+	case todo.BatchCreate:
+		if err := createTask(tx, op.ID, op.Content, userID, op.ProjectID); err != nil {
+			return nil, err
+		}
+		return loadTask(tx, op.ID, false)
+	case todo.BatchPatch:
+		if op.Patch == nil {
+			return nil, todo.ErrBatchOpInvalid
+		} else if err := op.Patch.Validate(); err != nil {
+			return nil, err
+		}
+		return patchTask(tx, op.ID, op.Patch, userID)
+	case todo.BatchDelete:
+		return nil, deleteTask(tx, op.ID, userID)
+	case todo.BatchMove:
+		if err := moveTask(tx, op.ID, op.ProjectID, userID); err != nil {
+			return nil, err
+		}
+		return loadTask(tx, op.ID, false)
+	}
+	return nil, todo.ErrBatchOpInvalid
+}
+
+// ClearCompleted deletes the completed tasks in the project, or the user's
+// own completed tasks when no project is given.
+func (s *TaskService) ClearCompleted(userID todo.UserID, projectID todo.ProjectID) error {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	if projectID == "" {
+		_, err = tx.Exec("DELETE FROM todo.tasks WHERE completed=true AND projectID IS NULL AND userID=$1", userID)
+	} else if err = requireRole(tx, projectID, userID, todo.RoleEditor); err != nil {
+		return err
+	} else {
+		_, err = tx.Exec("DELETE FROM todo.tasks WHERE completed=true AND projectID=$1", projectID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	userIDs, err := audience(tx, projectID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = writeEvents(tx, todo.EventTasksCleared, userIDs, &taskPayload{ProjectID: projectID})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// taskColumns are the columns scanTasks reads.
+const taskColumns = `taskID, COALESCE(projectID::text, ''), COALESCE(assigneeID::text, ''), content, completed,
+	(SELECT COUNT(*) FROM todo.comments c WHERE c.taskID=todo.tasks.taskID),
+	COALESCE(to_char(dueAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''), recurrence, timestamp`
+
+func scanTasks(rows *sql.Rows) (*todo.Tasks, error) {
+	defer rows.Close()
+	var todos todo.Tasks
+	for rows.Next() {
+		tempTask := &todo.Task{}
+		if err := rows.Scan(&tempTask.ID, &tempTask.ProjectID, &tempTask.AssigneeID, &tempTask.Content, &tempTask.Completed, &tempTask.CommentCount, &tempTask.DueAt, &tempTask.Recurrence, &tempTask.Timestamp); err != nil {
+			return nil, err
+		}
+		todos = append(todos, *tempTask)
+	}
+	return &todos, rows.Err()
+}
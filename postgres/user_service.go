@@ -1,8 +1,10 @@
 package postgres
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/kennedymj97/todo-api"
-	"github.com/lib/pq"
 )
 
 var _ todo.UserService = &UserService{}
@@ -25,10 +27,10 @@ func (s *UserService) CreateUser(email todo.Email, password string) error {
 	_, err = tx.Exec("INSERT INTO todo.users(email, password) VALUES($1, $2)", email, password)
 	if err != nil {
 		tx.Rollback()
-		switch err.(*pq.Error).Message {
-		case `duplicate key value violates unique constraint "users_email_key"`:
+		if isUniqueViolation(err) {
 			return todo.ErrEmailExists
 		}
+		return err
 	}
 	return nil
 }
@@ -42,18 +44,20 @@ func (s *UserService) User(email todo.Email) (todo.UserID, string, error) {
 		return "", "", err
 	}
 	defer tx.Commit()
-	row := tx.QueryRow("SELECT userID, password FROM todo.users WHERE email=$1", email)
+	row := tx.QueryRow("SELECT userID, password FROM todo.users WHERE lower(email)=lower($1)", email)
 	var userId todo.UserID
 	var pword string
-	if err := row.Scan(&userId, &pword); err != nil {
+	if err := row.Scan(&userId, &pword); err == sql.ErrNoRows {
+		return "", "", todo.ErrUserNotFound
+	} else if err != nil {
 		tx.Rollback()
 		return "", "", err
 	}
 	return userId, pword, nil
 }
 
-func (s *UserService) CreateUserSession(sessionID todo.SessionID, userID todo.UserID, expiryTime todo.ExpiryTime) error {
-	if FormatInput(sessionID) == "" {
+func (s *UserService) CreateUserSession(tokenHash string, userID todo.UserID, expiryTime todo.ExpiryTime, userAgent string, ip string) error {
+	if FormatInput(tokenHash) == "" {
 		return todo.ErrSessionRequired
 	} else if FormatInput(userID) == "" {
 		return todo.ErrUserIDRequired
@@ -65,15 +69,18 @@ func (s *UserService) CreateUserSession(sessionID todo.SessionID, userID todo.Us
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("INSERT INTO todo.userSessions(sessionID, userID, expiryTime) VALUES($1, $2, $3)", sessionID, userID, expiryTime)
+	_, err = tx.Exec(`INSERT INTO todo.userSessions(sessionID, tokenHash, userID, expiryTime, userAgent, ip)
+	VALUES(uuid_generate_v4(), $1, $2, $3, $4, $5)`, tokenHash, userID, expiryTime, userAgent, ip)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *UserService) AuthenticateUser(id todo.SessionID) (todo.UserID, error) {
-	if FormatInput(id) == "" {
+// AuthenticateUser returns the user the session belongs to. Last seen is
+// only moved on once a minute so most requests don't write.
+func (s *UserService) AuthenticateUser(tokenHash string) (todo.UserID, error) {
+	if FormatInput(tokenHash) == "" {
 		return "", todo.ErrSessionRequired
 	}
 	tx, err := s.client.db.Begin()
@@ -81,30 +88,265 @@ func (s *UserService) AuthenticateUser(id todo.SessionID) (todo.UserID, error) {
 		return "", err
 	}
 	defer tx.Commit()
-	row := tx.QueryRow("SELECT userID FROM todo.userSessions WHERE sessionID=$1", id)
+	row := tx.QueryRow("SELECT userID FROM todo.userSessions WHERE tokenHash=$1", tokenHash)
 	var userID todo.UserID
 	if err := row.Scan(&userID); err != nil {
 		return "", err
 	}
+	_, err = tx.Exec(`UPDATE todo.userSessions SET lastSeen=current_timestamp
+	WHERE tokenHash=$1 AND lastSeen < current_timestamp - interval '1 minute'`, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
 	return userID, nil
 }
 
-func (s *UserService) LogoutUser(id todo.SessionID) error {
+func (s *UserService) LogoutUser(tokenHash string) error {
+	if FormatInput(tokenHash) == "" {
+		return todo.ErrSessionRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	_, err = tx.Exec("DELETE FROM todo.userSessions WHERE tokenHash=$1", tokenHash)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sessions returns the user's sessions, most recently used first.
+func (s *UserService) Sessions(userID todo.UserID, currentTokenHash string) (*todo.Sessions, error) {
+	rows, err := s.client.db.Query(`SELECT sessionID, userAgent, ip,
+	to_char(createdAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+	to_char(lastSeen AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+	tokenHash=$2
+	FROM todo.userSessions WHERE userID=$1 ORDER BY lastSeen DESC`, userID, currentTokenHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := todo.Sessions{}
+	for rows.Next() {
+		var session todo.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeen, &session.Current); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return &sessions, rows.Err()
+}
+
+func (s *UserService) RevokeSession(id todo.SessionID, userID todo.UserID) error {
 	if FormatInput(id) == "" {
+		return todo.ErrSessionIDRequired
+	}
+	res, err := s.client.db.Exec("DELETE FROM todo.userSessions WHERE sessionID::text=$1 AND userID=$2", id, userID)
+	if err != nil {
+		return err
+	}
+	return expectRow(res, todo.ErrSessionNotFound)
+}
+
+// RevokeOtherSessions logs the user out everywhere except the session with
+// keepTokenHash.
+func (s *UserService) RevokeOtherSessions(userID todo.UserID, keepTokenHash string) error {
+	if FormatInput(keepTokenHash) == "" {
 		return todo.ErrSessionRequired
 	}
+	_, err := s.client.db.Exec("DELETE FROM todo.userSessions WHERE userID=$1 AND tokenHash IS DISTINCT FROM $2", userID, keepTokenHash)
+	return err
+}
+
+// CreatePasswordReset stores the hash of a reset token for the user with the
+// email. Only the hash is stored so a leaked table can't be used to reset
+// passwords.
+func (s *UserService) CreatePasswordReset(email todo.Email, tokenHash string, expiresAt time.Time) (todo.UserID, error) {
+	if FormatInput(email) == "" {
+		return "", todo.ErrEmailRequired
+	} else if FormatInput(tokenHash) == "" {
+		return "", todo.ErrResetTokenRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	var userID todo.UserID
+	row := tx.QueryRow("SELECT userID FROM todo.users WHERE lower(email)=lower($1)", email)
+	if err := row.Scan(&userID); err == sql.ErrNoRows {
+		return "", todo.ErrUserNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO todo.passwordResets(tokenHash, userID, expiresAt) VALUES($1, $2, $3)", tokenHash, userID, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return userID, nil
+}
+
+// ResetPassword sets a new password hash for the user the reset token was
+// issued to. Every outstanding reset token of the user is used up and all
+// of their sessions are revoked.
+func (s *UserService) ResetPassword(tokenHash string, password string) error {
+	if FormatInput(tokenHash) == "" {
+		return todo.ErrResetTokenRequired
+	} else if FormatInput(password) == "" {
+		return todo.ErrPasswordRequired
+	}
 	tx, err := s.client.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Commit()
-	_, err = tx.Exec("DELETE FROM todo.userSessions WHERE sessionID=$1", string(id))
+	var userID todo.UserID
+	row := tx.QueryRow(`SELECT userID FROM todo.passwordResets
+	WHERE tokenHash=$1 AND usedAt IS NULL AND expiresAt > current_timestamp
+	FOR UPDATE`, tokenHash)
+	if err := row.Scan(&userID); err == sql.ErrNoRows {
+		return todo.ErrResetTokenInvalid
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.users SET password=$1 WHERE userID=$2", password, userID)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
+	for _, query := range []string{
+		"UPDATE todo.passwordResets SET usedAt=current_timestamp WHERE userID=$1 AND usedAt IS NULL",
+		"DELETE FROM todo.userSessions WHERE userID=$1",
+		"UPDATE todo.refreshTokens SET revokedAt=current_timestamp WHERE userID=$1 AND revokedAt IS NULL",
+	} {
+		_, err = tx.Exec(query, userID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *UserService) Account(id todo.UserID) (*todo.User, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrUserIDRequired
+	}
+	var u todo.User
+	row := s.client.db.QueryRow(`SELECT userID, email, COALESCE(to_char(verifiedAt AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), ''),
+	COALESCE(pendingEmail, '') FROM todo.users WHERE userID=$1`, id)
+	if err := row.Scan(&u.ID, &u.Email, &u.VerifiedAt, &u.PendingEmail); err == sql.ErrNoRows {
+		return nil, todo.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// IsAdmin reports whether the user can use the admin API. Admins are made
+// by setting todo.users.admin in the database.
+func (s *UserService) IsAdmin(id todo.UserID) (bool, error) {
+	var admin bool
+	row := s.client.db.QueryRow("SELECT admin FROM todo.users WHERE userID=$1", id)
+	if err := row.Scan(&admin); err == sql.ErrNoRows {
+		return false, todo.ErrUserNotFound
+	} else if err != nil {
+		return false, err
+	}
+	return admin, nil
+}
+
+// VerifyEmail marks the user's email as verified, as long as it is still the
+// email the verification was sent to. Verifying a pending email swaps it in
+// for the current one.
+func (s *UserService) VerifyEmail(id todo.UserID, email todo.Email) error {
+	if FormatInput(id) == "" {
+		return todo.ErrUserIDRequired
+	} else if FormatInput(email) == "" {
+		return todo.ErrEmailRequired
+	}
+	res, err := s.client.db.Exec(`UPDATE todo.users SET email=$2, pendingEmail=NULL,
+	verifiedAt=CASE WHEN email=$2 THEN COALESCE(verifiedAt, current_timestamp) ELSE current_timestamp END
+	WHERE userID=$1 AND (email=$2 OR pendingEmail=$2)`, id, email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return todo.ErrEmailExists
+		}
+		return err
+	}
+	return expectRow(res, todo.ErrVerificationInvalid)
+}
+
+// UpdateUser changes the user's password and/or email and returns the user
+// as they were before. A new password signs out every session other than
+// keepTokenHash, and every refresh token. A new email is only pending until
+// it has been verified.
+func (s *UserService) UpdateUser(id todo.UserID, update todo.UserUpdate, keepTokenHash string) (*todo.User, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrUserIDRequired
+	} else if FormatInput(update.Email) == "" && FormatInput(update.Password) == "" {
+		return nil, todo.ErrUserUpdateRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	var u todo.User
+	row := tx.QueryRow("SELECT userID, email, COALESCE(pendingEmail, '') FROM todo.users WHERE userID=$1 FOR UPDATE", id)
+	if err := row.Scan(&u.ID, &u.Email, &u.PendingEmail); err == sql.ErrNoRows {
+		return nil, todo.ErrUserNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if update.Password != "" {
+		_, err = tx.Exec("UPDATE todo.users SET password=$1 WHERE userID=$2", update.Password, id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		_, err = tx.Exec("UPDATE todo.passwordResets SET usedAt=current_timestamp WHERE userID=$1 AND usedAt IS NULL", id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		_, err = tx.Exec("DELETE FROM todo.userSessions WHERE userID=$1 AND tokenHash IS DISTINCT FROM $2", id, keepTokenHash)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		_, err = tx.Exec("UPDATE todo.refreshTokens SET revokedAt=current_timestamp WHERE userID=$1 AND revokedAt IS NULL", id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if update.Email != "" && update.Email != u.Email {
+		var taken bool
+		row = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM todo.users WHERE lower(email)=lower($1))", update.Email)
+		if err := row.Scan(&taken); err != nil {
+			tx.Rollback()
+			return nil, err
+		} else if taken {
+			tx.Rollback()
+			return nil, todo.ErrEmailExists
+		}
+		_, err = tx.Exec("UPDATE todo.users SET pendingEmail=$1 WHERE userID=$2", update.Email, id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
 func (s *UserService) DeleteUser(id todo.UserID) error {
 	if FormatInput(id) == "" {
 		return todo.ErrUserIDRequired
@@ -114,6 +356,11 @@ func (s *UserService) DeleteUser(id todo.UserID) error {
 		return err
 	}
 	defer tx.Commit()
+	_, err = tx.Exec("DELETE FROM todo.invitations WHERE email=(SELECT email FROM todo.users WHERE userID=$1)", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	_, err = tx.Exec("DELETE FROM todo.users WHERE userID=$1", id)
 	if err != nil {
 		tx.Rollback()
@@ -124,11 +371,42 @@ func (s *UserService) DeleteUser(id todo.UserID) error {
 		tx.Rollback()
 		return err
 	}
-	_, err = tx.Exec("DELETE FROM todo.tasks WHERE userID=$1", id)
+	_, err = tx.Exec("DELETE FROM todo.tasks WHERE projectID IS NULL AND userID=$1", id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	// Projects go with their owner, shared projects owned by someone else
+	// only lose the member
+	for _, query := range []string{
+		"DELETE FROM todo.tasks WHERE projectID IN (SELECT projectID FROM todo.projects WHERE ownerID=$1)",
+		"DELETE FROM todo.invitations WHERE projectID IN (SELECT projectID FROM todo.projects WHERE ownerID=$1)",
+		"DELETE FROM todo.projectMembers WHERE projectID IN (SELECT projectID FROM todo.projects WHERE ownerID=$1)",
+		"DELETE FROM todo.projects WHERE ownerID=$1",
+		"DELETE FROM todo.projectMembers WHERE userID=$1",
+		"UPDATE todo.tasks SET assigneeID=NULL WHERE assigneeID=$1",
+		"DELETE FROM todo.notifications WHERE userID=$1",
+		"DELETE FROM todo.notificationPreferences WHERE userID=$1",
+		"DELETE FROM todo.reminders WHERE userID=$1",
+		// Queued deliveries would otherwise still be sent to the user's urls
+		"DELETE FROM todo.webhookDeliveries WHERE webhookID IN (SELECT webhookID FROM todo.webhooks WHERE userID=$1)",
+		"DELETE FROM todo.webhooks WHERE userID=$1",
+		"DELETE FROM todo.passwordResets WHERE userID=$1",
+		"DELETE FROM todo.recoveryCodes WHERE userID=$1",
+		"DELETE FROM todo.mfaChallenges WHERE userID=$1",
+		"DELETE FROM todo.apiTokens WHERE userID=$1",
+		"DELETE FROM todo.refreshTokens WHERE userID=$1",
+		"DELETE FROM todo.oauthCodes WHERE userID=$1",
+		"DELETE FROM todo.oauthTokens WHERE userID=$1",
+		"DELETE FROM todo.oauthClients WHERE ownerID=$1",
+		"DELETE FROM todo.idempotencyKeys WHERE userID=$1",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"database/sql"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+	"github.com/lib/pq"
+)
+
+var _ todo.WebhookService = &WebhookService{}
+
+type WebhookService struct {
+	client *Client
+}
+
+func (s *WebhookService) Webhooks(userID todo.UserID) (*todo.Webhooks, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query("SELECT webhookID, url, events, active, failures, timestamp FROM todo.webhooks WHERE userID=$1 ORDER BY timestamp", userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := todo.Webhooks{}
+	for rows.Next() {
+		var hook todo.Webhook
+		var events []string
+		if err := rows.Scan(&hook.ID, &hook.URL, pq.Array(&events), &hook.Active, &hook.Failures, &hook.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for _, e := range events {
+			hook.Events = append(hook.Events, todo.EventType(e))
+		}
+		webhooks = append(webhooks, hook)
+	}
+	return &webhooks, nil
+}
+
+func (s *WebhookService) CreateWebhook(userID todo.UserID, rawURL string, events []todo.EventType, secret string) (todo.WebhookID, error) {
+	if FormatInput(userID) == "" {
+		return "", todo.ErrUserIDRequired
+	}
+	u, err := url.Parse(FormatInput(rawURL))
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", todo.ErrWebhookURLInvalid
+	} else if !publicHost(u.Hostname()) {
+		return "", todo.ErrWebhookAddressBlocked
+	} else if len(events) == 0 {
+		return "", todo.ErrWebhookEventsRequired
+	} else if FormatInput(secret) == "" {
+		return "", todo.ErrWebhookSecretRequired
+	}
+	names := make([]string, len(events))
+	for i, e := range events {
+		if !knownEvent(e) {
+			return "", todo.ErrWebhookEventUnknown
+		}
+		names[i] = string(e)
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Commit()
+	row := tx.QueryRow("INSERT INTO todo.webhooks(userID, url, events, secret) VALUES($1, $2, $3, $4) RETURNING webhookID", userID, FormatInput(rawURL), pq.Array(names), secret)
+	var id todo.WebhookID
+	if err := row.Scan(&id); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *WebhookService) EnableWebhook(id todo.WebhookID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrWebhookIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	res, err := tx.Exec("UPDATE todo.webhooks SET active=true, failures=0 WHERE webhookID=$1 AND userID=$2", id, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return expectRow(res, todo.ErrWebhookNotFound)
+}
+
+func (s *WebhookService) DeleteWebhook(id todo.WebhookID, userID todo.UserID) error {
+	if FormatInput(id) == "" {
+		return todo.ErrWebhookIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	res, err := tx.Exec("DELETE FROM todo.webhooks WHERE webhookID=$1 AND userID=$2", id, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := expectRow(res, todo.ErrWebhookNotFound); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM todo.webhookDeliveries WHERE webhookID=$1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (s *WebhookService) Deliveries(id todo.WebhookID, userID todo.UserID) (*todo.WebhookDeliveries, error) {
+	if FormatInput(id) == "" {
+		return nil, todo.ErrWebhookIDRequired
+	}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM todo.webhooks WHERE webhookID=$1 AND userID=$2)", id, userID).Scan(&exists); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if !exists {
+		return nil, todo.ErrWebhookNotFound
+	}
+	rows, err := tx.Query(`SELECT deliveryID, webhookID, event, payload, status, attempts, COALESCE(responseCode, 0), COALESCE(lastError, ''), nextAttempt, timestamp
+	FROM todo.webhookDeliveries WHERE webhookID=$1 ORDER BY timestamp DESC LIMIT 100`, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := todo.WebhookDeliveries{}
+	for rows.Next() {
+		var d todo.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.NextAttempt, &d.Timestamp); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return &deliveries, nil
+}
+
+// EnqueueDelivery queues a delivery for each of the user's active webhooks
+// subscribed to the event.
+func (s *WebhookService) EnqueueDelivery(userID todo.UserID, event todo.EventType, payload string) error {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	_, err = tx.Exec(`INSERT INTO todo.webhookDeliveries(webhookID, event, payload)
+	SELECT webhookID, $2, $3 FROM todo.webhooks WHERE userID=$1 AND active AND $2=ANY(events)`, userID, string(event), payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// ClaimDeliveries locks due deliveries and pushes their next attempt back by
+// the lease so other dispatchers skip them while they are being sent.
+func (s *WebhookService) ClaimDeliveries(limit int, lease time.Duration) (*todo.WebhookDeliveries, error) {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+	rows, err := tx.Query(`UPDATE todo.webhookDeliveries d SET nextAttempt=current_timestamp + $2 * interval '1 millisecond'
+	FROM (
+		SELECT d.deliveryID, w.url, w.secret FROM todo.webhookDeliveries d
+		JOIN todo.webhooks w ON w.webhookID=d.webhookID
+		WHERE d.status='pending' AND w.active AND d.nextAttempt <= current_timestamp
+		ORDER BY d.nextAttempt
+		LIMIT $1
+		FOR UPDATE OF d SKIP LOCKED
+	) due
+	WHERE d.deliveryID=due.deliveryID
+	RETURNING d.deliveryID, d.webhookID, d.event, d.payload, d.status, d.attempts, d.timestamp, due.url, due.secret`, limit, lease.Milliseconds())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := todo.WebhookDeliveries{}
+	for rows.Next() {
+		var d todo.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.Timestamp, &d.URL, &d.Secret); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return &deliveries, nil
+}
+
+func (s *WebhookService) DeliverySucceeded(id todo.DeliveryID, responseCode int) error {
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	var webhookID todo.WebhookID
+	row := tx.QueryRow(`UPDATE todo.webhookDeliveries SET status=$2, attempts=attempts+1, responseCode=$3, lastError=NULL
+	WHERE deliveryID=$1 RETURNING webhookID`, id, todo.DeliveryDelivered, responseCode)
+	if err := row.Scan(&webhookID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.webhooks SET failures=0 WHERE webhookID=$1", webhookID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// DeliveryFailed records a failed attempt. The webhook is disabled once it
+// has failed disableAfter times in a row.
+func (s *WebhookService) DeliveryFailed(id todo.DeliveryID, responseCode int, deliveryErr string, retryAt time.Time, giveUp bool, disableAfter int) error {
+	status := todo.DeliveryPending
+	if giveUp {
+		status = todo.DeliveryFailed
+	}
+	code := sql.NullInt64{Int64: int64(responseCode), Valid: responseCode != 0}
+	tx, err := s.client.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+	var webhookID todo.WebhookID
+	row := tx.QueryRow(`UPDATE todo.webhookDeliveries SET status=$2, attempts=attempts+1, responseCode=$3, lastError=$4, nextAttempt=$5
+	WHERE deliveryID=$1 RETURNING webhookID`, id, status, code, deliveryErr, retryAt)
+	if err := row.Scan(&webhookID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE todo.webhooks SET failures=failures+1, active=(failures+1 < $2) WHERE webhookID=$1", webhookID, disableAfter)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// publicHost reports whether a webhook url's host can be public. Names are
+// checked again when they are resolved for each delivery, since what they
+// resolve to can change.
+func publicHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return todo.PublicIP(ip)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+func knownEvent(e todo.EventType) bool {
+	for _, known := range todo.EventTypes {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// expectRow returns notFound when a statement did not touch any rows.
+func expectRow(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
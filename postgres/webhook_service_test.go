@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+func TestCreateWebhookInternalAddress(t *testing.T) {
+	s := &WebhookService{}
+	for _, url := range []string{
+		"http://localhost:8080/hook",
+		"http://api.localhost/hook",
+		"http://127.0.0.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]:8080/hook",
+	} {
+		if _, err := s.CreateWebhook("user", url, []todo.EventType{todo.EventTaskCreated}, "shh"); err != todo.ErrWebhookAddressBlocked {
+			t.Errorf("CreateWebhook(%s) = %v, want %v", url, err, todo.ErrWebhookAddressBlocked)
+		}
+	}
+}
+
+// createTestWebhook creates a webhook for task.created events owned by a
+// new user.
+func createTestWebhook(t *testing.T, c *Client) (todo.WebhookID, todo.UserID) {
+	t.Helper()
+	userID, _ := createTestUser(t, c)
+	id, err := c.WebhookService().CreateWebhook(userID, "https://example.com/hook", []todo.EventType{todo.EventTaskCreated}, "shh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, userID
+}
+
+// claimTestDelivery claims the due deliveries and returns the webhook's.
+func claimTestDelivery(t *testing.T, s todo.WebhookService, id todo.WebhookID) *todo.WebhookDelivery {
+	t.Helper()
+	deliveries, err := s.ClaimDeliveries(100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range *deliveries {
+		if d.WebhookID == id {
+			return &d
+		}
+	}
+	return nil
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	c := openTestClient(t)
+	s := c.WebhookService()
+	id, userID := createTestWebhook(t, c)
+
+	if err := s.EnqueueDelivery(userID, todo.EventTaskCreated, `{"id":"task"}`); err != nil {
+		t.Fatal(err)
+	}
+	// Only subscribed events are queued
+	if err := s.EnqueueDelivery(userID, todo.EventTaskDeleted, `{}`); err != nil {
+		t.Fatal(err)
+	}
+	delivery := claimTestDelivery(t, s, id)
+	if delivery == nil {
+		t.Fatal("delivery wasn't claimed")
+	} else if delivery.URL != "https://example.com/hook" || delivery.Secret != "shh" {
+		t.Errorf("claimed delivery = %+v", delivery)
+	}
+	// A claimed delivery is leased to the dispatcher that claimed it
+	if claimTestDelivery(t, s, id) != nil {
+		t.Error("delivery claimed twice")
+	}
+
+	if err := s.DeliveryFailed(delivery.ID, 503, "unexpected response status 503", time.Now(), false, 20); err != nil {
+		t.Fatal(err)
+	}
+	log, err := s.Deliveries(id, userID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(*log) != 1 {
+		t.Fatalf("%d deliveries logged, want 1", len(*log))
+	}
+	if got := (*log)[0]; got.Status != todo.DeliveryPending || got.Attempts != 1 || got.ResponseCode != 503 || got.LastError != "unexpected response status 503" {
+		t.Errorf("after failure, delivery = %+v", got)
+	}
+
+	if err := s.DeliverySucceeded(delivery.ID, 200); err != nil {
+		t.Fatal(err)
+	}
+	log, _ = s.Deliveries(id, userID)
+	if got := (*log)[0]; got.Status != todo.DeliveryDelivered || got.Attempts != 2 || got.ResponseCode != 200 || got.LastError != "" {
+		t.Errorf("after success, delivery = %+v", got)
+	}
+
+	// Other users can't read the log
+	if _, err := s.Deliveries(id, "00000000-0000-0000-0000-000000000000"); err != todo.ErrWebhookNotFound {
+		t.Errorf("Deliveries() for another user = %v, want %v", err, todo.ErrWebhookNotFound)
+	}
+}
+
+func TestWebhookDisabledAfterFailures(t *testing.T) {
+	c := openTestClient(t)
+	s := c.WebhookService()
+	id, userID := createTestWebhook(t, c)
+
+	for i := 0; i < 2; i++ {
+		s.EnqueueDelivery(userID, todo.EventTaskCreated, `{}`)
+	}
+	deliveries, _ := s.ClaimDeliveries(100, time.Minute)
+	for _, d := range *deliveries {
+		if d.WebhookID == id {
+			if err := s.DeliveryFailed(d.ID, 500, "unexpected response status 500", time.Now(), false, 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	webhook := func() todo.Webhook {
+		hooks, err := s.Webhooks(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (*hooks)[0]
+	}
+	if hook := webhook(); hook.Active || hook.Failures != 2 {
+		t.Fatalf("webhook = %+v, want it disabled after 2 failures", hook)
+	}
+	// Nothing more is queued for it until it's enabled again
+	s.EnqueueDelivery(userID, todo.EventTaskCreated, `{}`)
+	log, _ := s.Deliveries(id, userID)
+	if len(*log) != 2 {
+		t.Errorf("%d deliveries logged for a disabled webhook, want 2", len(*log))
+	}
+
+	if err := s.EnableWebhook(id, userID); err != nil {
+		t.Fatal(err)
+	}
+	if hook := webhook(); !hook.Active || hook.Failures != 0 {
+		t.Errorf("webhook = %+v, want it enabled with no failures", hook)
+	}
+}
+
+func TestDeleteUserDeletesWebhooks(t *testing.T) {
+	c := openTestClient(t)
+	s := c.WebhookService()
+	id, userID := createTestWebhook(t, c)
+	if err := s.EnqueueDelivery(userID, todo.EventTaskCreated, `{"id":"task"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.UserService().DeleteUser(userID); err != nil {
+		t.Fatal(err)
+	}
+	if claimTestDelivery(t, s, id) != nil {
+		t.Error("delivery claimed for a deleted user's webhook")
+	}
+	if hooks, err := s.Webhooks(userID); err != nil {
+		t.Fatal(err)
+	} else if len(*hooks) != 0 {
+		t.Errorf("%d webhooks left after deleting the user, want 0", len(*hooks))
+	}
+}
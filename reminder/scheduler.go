@@ -0,0 +1,85 @@
+package reminder
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// Scheduler polls for reminders that are due and sends each of them over
+// the channels it asked for. Reminders are claimed with row locks and a
+// lease, so several api replicas can run a scheduler without a reminder
+// firing twice.
+type Scheduler struct {
+	ReminderService todo.ReminderService
+	// Notifiers by channel, see todo.ChannelInApp etc.
+	Notifiers map[string]todo.Notifier
+	Logger    *log.Logger
+	// How often reminders are polled when none are due.
+	Interval time.Duration
+	// Reminders claimed per poll.
+	BatchSize int
+	// How long a claimed reminder is hidden from other schedulers. A
+	// reminder that couldn't be sent at all is retried once it expires.
+	Lease time.Duration
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		Notifiers: make(map[string]todo.Notifier),
+		Logger:    log.New(os.Stderr, "", log.LstdFlags),
+		Interval:  15 * time.Second,
+		BatchSize: 50,
+		Lease:     5 * time.Minute,
+	}
+}
+
+// Run sends due reminders until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	todo.Poll(stop, s.Interval, s.BatchSize, s.Flush, func(err error) {
+		s.Logger.Printf("reminder error: %s", err)
+	})
+}
+
+// Flush claims one batch of due reminders and sends each of them. It
+// returns how many reminders were claimed.
+func (s *Scheduler) Flush() (int, error) {
+	reminders, err := s.ReminderService.ClaimReminders(s.BatchSize, s.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for i := range *reminders {
+		r := &(*reminders)[i]
+		if !s.send(r) {
+			continue
+		}
+		if err := s.ReminderService.ReminderSent(r.ID, r.Occurrence); err != nil {
+			s.Logger.Printf("reminder error: %s (reminder=%s)", err, r.ID)
+		}
+	}
+	return len(*reminders), nil
+}
+
+// send notifies every channel of the reminder and reports whether it is
+// done with. Channels that failed aren't retried once another has
+// succeeded, so the user isn't reminded twice. Channels without a notifier
+// are skipped.
+func (s *Scheduler) send(r *todo.DueReminder) bool {
+	sent, attempted := false, false
+	for _, channel := range r.Channels {
+		n, ok := s.Notifiers[channel]
+		if !ok {
+			s.Logger.Printf("reminder: no notifier for channel %s (reminder=%s)", channel, r.ID)
+			continue
+		}
+		attempted = true
+		if err := n.Notify(r); err != nil {
+			s.Logger.Printf("reminder error: %s (reminder=%s channel=%s)", err, r.ID, channel)
+			continue
+		}
+		sent = true
+	}
+	return sent || !attempted
+}
@@ -1,39 +1,878 @@
 package todo
 
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+)
+
 type UserID string
 type Email string
 type SessionID string
 type ExpiryTime string
 
+// NormalizeEmail trims and lower cases an email address, and checks it is a
+// bare address without a display name.
+func NormalizeEmail(e Email) (Email, error) {
+	s := strings.ToLower(strings.TrimSpace(string(e)))
+	if s == "" {
+		return "", ErrEmailRequired
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return "", ErrEmailInvalid
+	}
+	return Email(s), nil
+}
+
+type User struct {
+	ID         UserID `json:"id"`
+	Email      Email  `json:"email"`
+	VerifiedAt string `json:"verifiedAt,omitempty"`
+	// PendingEmail replaces Email once it has been verified.
+	PendingEmail Email `json:"pendingEmail,omitempty"`
+}
+
+// UserUpdate changes a user's credentials, empty fields are left alone.
+// Password is the hash of the new password.
+type UserUpdate struct {
+	Email    Email
+	Password string
+}
+
+// Session is a device the user is logged in on. The session cookie isn't
+// its ID, only the cookie's hash is stored.
+type Session struct {
+	ID        SessionID `json:"id"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+	CreatedAt string    `json:"createdAt"`
+	LastSeen  string    `json:"lastSeen"`
+	// Current is true for the session the request was made with.
+	Current bool `json:"current"`
+}
+
+type Sessions []Session
+
+// UserService methods that take a tokenHash take the hash of a session
+// cookie, the cookie itself is never stored.
 type UserService interface {
 	CreateUser(email Email, password string) error
 	User(email Email) (UserID, string, error)
-	CreateUserSession(sessionId SessionID, userId UserID, expiryTime ExpiryTime) error
-	AuthenticateUser(id SessionID) (UserID, error)
-	LogoutUser(id SessionID) error
+	CreateUserSession(tokenHash string, userId UserID, expiryTime ExpiryTime, userAgent string, ip string) error
+	AuthenticateUser(tokenHash string) (UserID, error)
+	LogoutUser(tokenHash string) error
 	//RefreshExpiryTime(id SessionID, newId SessionID, newExpiryTime ExpiryTime) error
+	Sessions(userID UserID, currentTokenHash string) (*Sessions, error)
+	RevokeSession(id SessionID, userID UserID) error
+	RevokeOtherSessions(userID UserID, keepTokenHash string) error
 	DeleteUser(id UserID) error
-	//UpdateUser(username Username, email Email, password Password) error
+	UpdateUser(id UserID, update UserUpdate, keepTokenHash string) (*User, error)
+	CreatePasswordReset(email Email, tokenHash string, expiresAt time.Time) (UserID, error)
+	ResetPassword(tokenHash string, password string) error
+	Account(id UserID) (*User, error)
+	VerifyEmail(id UserID, email Email) error
+	IsAdmin(id UserID) (bool, error)
+}
+
+// MFAService stores TOTP second factors. Enrolment is pending until it is
+// confirmed with a code, and recovery codes are only stored hashed. Login
+// challenges are issued once the password has been checked and must be
+// answered with a code before a session is created.
+type MFAService interface {
+	MFAEnabled(userID UserID) (bool, error)
+	EnrollMFA(userID UserID, secret string) error
+	ConfirmMFA(userID UserID, code string, recoveryCodeHashes []string) error
+	VerifyMFA(userID UserID, code string, codeHash string) error
+	DisableMFA(userID UserID) error
+	CreateMFAChallenge(userID UserID, challengeHash string, expiresAt time.Time) error
+	MFAChallenge(challengeHash string) (UserID, error)
+	DeleteMFAChallenge(challengeHash string) error
+}
+
+// RefreshTokenService stores the refresh tokens of the JWT login mode. Each
+// refresh token can be used once and is replaced by a new one in the same
+// family. Using one twice means it was stolen, so the whole family is
+// revoked.
+type RefreshTokenService interface {
+	CreateRefreshToken(userID UserID, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(tokenHash string, newTokenHash string, expiresAt time.Time) (UserID, error)
+	RevokeRefreshToken(tokenHash string) error
+}
+
+// IdempotentResponse is the response to a request sent with an
+// Idempotency-Key, sent again when the request is retried.
+type IdempotentResponse struct {
+	Status      int
+	Location    string
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyService remembers the requests sent with an Idempotency-Key
+// and their responses. Keys belong to a user and expire.
+type IdempotencyService interface {
+	// StartIdempotentRequest claims the key for a request, identified by
+	// its fingerprint. If the request was already made its response is
+	// returned. A different request with the same key gets
+	// ErrIdempotencyKeyReused, and a retry before the first request has
+	// finished gets ErrIdempotencyKeyInProgress.
+	StartIdempotentRequest(userID UserID, key string, fingerprint string, expiresAt time.Time) (*IdempotentResponse, error)
+	SaveIdempotentResponse(userID UserID, key string, res *IdempotentResponse) error
+	// ReleaseIdempotencyKey forgets a key whose request failed, so the
+	// request can be retried.
+	ReleaseIdempotencyKey(userID UserID, key string) error
+}
+
+type APITokenID string
+
+// Scopes an API token can be given. Reading is GET requests, writing is
+// everything else.
+const (
+	ScopeTasksRead          = "tasks:read"
+	ScopeTasksWrite         = "tasks:write"
+	ScopeProjectsRead       = "projects:read"
+	ScopeProjectsWrite      = "projects:write"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+)
+
+var Scopes = []string{
+	ScopeTasksRead,
+	ScopeTasksWrite,
+	ScopeProjectsRead,
+	ScopeProjectsWrite,
+	ScopeNotificationsRead,
+	ScopeNotificationsWrite,
+}
+
+// APIToken is a personal access token for scripts that can't log in with a
+// cookie. Only the token's hash is stored, it is shown once when created.
+type APIToken struct {
+	ID     APITokenID `json:"id"`
+	Name   string     `json:"name"`
+	Scopes []string   `json:"scopes"`
+	// ExpiresAt is empty for tokens that don't expire.
+	ExpiresAt  string `json:"expiresAt,omitempty"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+	Timestamp  string `json:"timestamp"`
+}
+
+type APITokens []APIToken
+
+type APITokenService interface {
+	APITokens(userID UserID) (*APITokens, error)
+	CreateAPIToken(t *APIToken, tokenHash string, userID UserID) (APITokenID, error)
+	DeleteAPIToken(id APITokenID, userID UserID) error
+	// AuthenticateAPIToken returns who an unexpired token belongs to and
+	// what it can do.
+	AuthenticateAPIToken(tokenHash string) (UserID, []string, error)
+}
+
+type OAuthClientID string
+
+// OAuthClient is a third party app that can ask users for access to their
+// account. Clients without a secret are public, like native and browser
+// apps, and can only prove who they are with PKCE.
+type OAuthClient struct {
+	ID           OAuthClientID `json:"id"`
+	Name         string        `json:"name"`
+	RedirectURIs []string      `json:"redirectUris"`
+	Confidential bool          `json:"confidential"`
+	OwnerID      UserID        `json:"ownerId"`
+	SecretHash   string        `json:"-"`
+	Timestamp    string        `json:"timestamp"`
+}
+
+type OAuthClients []OAuthClient
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens.
+type OAuthCode struct {
+	ClientID    OAuthClientID
+	UserID      UserID
+	RedirectURI string
+	// RedirectURIExplicit is whether the authorization request had the
+	// redirect URI, rather than it being the client's only registered one.
+	// Only then must the token request have it too.
+	RedirectURIExplicit bool
+	Scopes              []string
+	CodeChallenge       string
+	ExpiresAt           time.Time
+}
+
+// OAuthGrant is what a client's tokens let it do for a user.
+type OAuthGrant struct {
+	ClientID OAuthClientID
+	UserID   UserID
+	Scopes   []string
+}
+
+// OAuthTokenHashes are the hashes of a new access and refresh token pair,
+// the tokens themselves are only given to the client.
+type OAuthTokenHashes struct {
+	AccessHash       string
+	AccessExpiresAt  time.Time
+	RefreshHash      string
+	RefreshExpiresAt time.Time
+}
+
+// OAuthService backs the OAuth2 authorization server. Codes and tokens are
+// stored hashed, and codes and refresh tokens can only be used once.
+type OAuthService interface {
+	CreateOAuthClient(c *OAuthClient) (OAuthClientID, error)
+	OAuthClients(ownerID UserID) (*OAuthClients, error)
+	OAuthClient(id OAuthClientID) (*OAuthClient, error)
+	DeleteOAuthClient(id OAuthClientID, ownerID UserID) error
+	CreateOAuthCode(codeHash string, code *OAuthCode) error
+	// ExchangeOAuthCode checks the code was issued to the client for the
+	// redirect URI and matches the PKCE verifier, then stores the tokens.
+	ExchangeOAuthCode(codeHash string, clientID OAuthClientID, redirectURI string, verifier string, tokens *OAuthTokenHashes) (*OAuthGrant, error)
+	RefreshOAuthToken(refreshHash string, clientID OAuthClientID, tokens *OAuthTokenHashes) (*OAuthGrant, error)
+	AuthenticateOAuthToken(accessHash string) (UserID, []string, error)
+	// RevokeOAuthToken revokes the token pair either hash belongs to.
+	RevokeOAuthToken(tokenHash string, clientID OAuthClientID) error
+}
+
+// VerifyPKCE checks an RFC 7636 code verifier against an S256 challenge.
+func VerifyPKCE(challenge string, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(challenge)) == 1
+}
+
+// LockoutKind is what failed logins are counted against.
+type LockoutKind string
+
+const (
+	LockoutAccount LockoutKind = "account"
+	LockoutIP      LockoutKind = "ip"
+)
+
+// LoginPolicy decides how long logins are locked out for after repeated
+// failures. The first Attempts failures are free, after that each failure
+// doubles the lockout starting from Backoff, up to MaxBackoff. Failures are
+// forgotten once none have happened for Window.
+type LoginPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Window     time.Duration
+}
+
+// Lockout returns how long logins are locked out for after failures
+// consecutive failed attempts.
+func (p LoginPolicy) Lockout(failures int) time.Duration {
+	if failures < p.Attempts {
+		return 0
+	}
+	lockout := p.Backoff
+	for i := p.Attempts; i < failures && lockout < p.MaxBackoff; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return lockout
+}
+
+type Lockout struct {
+	Kind        LockoutKind `json:"kind"`
+	Subject     string      `json:"subject"`
+	Failures    int         `json:"failures"`
+	LockedUntil string      `json:"lockedUntil"`
+}
+
+type Lockouts []Lockout
+
+// LoginAttemptService counts failed logins per account and per IP address.
+// Accounts are tracked by email whether or not they exist, so lockouts
+// don't reveal who has an account.
+type LoginAttemptService interface {
+	// LoginAttempt returns when logins for the email or IP are allowed
+	// again, or the zero time if they aren't locked, in which case the
+	// attempt is counted as a failure until LoginSucceeded takes it back.
+	LoginAttempt(email Email, ip string) (time.Time, error)
+	LoginSucceeded(email Email, ip string) error
+	Lockouts() (*Lockouts, error)
+	Unlock(kind LockoutKind, subject string, adminID UserID) error
+}
+
+type AuditAction string
+
+const (
+	AuditLoginLocked   AuditAction = "login.locked"
+	AuditLoginUnlocked AuditAction = "login.unlocked"
+)
+
+// AuditEntry records a security relevant action. ActorID is empty for
+// actions the system took by itself.
+type AuditEntry struct {
+	ID        int64       `json:"id"`
+	Action    AuditAction `json:"action"`
+	ActorID   UserID      `json:"actorId,omitempty"`
+	Subject   string      `json:"subject"`
+	IP        string      `json:"ip,omitempty"`
+	Timestamp string      `json:"timestamp"`
+}
+
+type AuditLog []AuditEntry
+
+type AuditService interface {
+	AuditLog(before int64, limit int) (*AuditLog, error)
 }
 
 type TaskID string
 type TaskContent string
 
 type Task struct {
-	ID        TaskID      `json:"id"`
-	Content   TaskContent `json:"content"`
-	Completed bool        `json:"completed"`
-	Timestamp string      `json:"timestamp"`
+	ID           TaskID      `json:"id"`
+	ProjectID    ProjectID   `json:"projectId,omitempty"`
+	AssigneeID   UserID      `json:"assigneeId,omitempty"`
+	Content      TaskContent `json:"content"`
+	Completed    bool        `json:"completed"`
+	CommentCount int         `json:"commentCount"`
+	DueAt        string      `json:"dueAt,omitempty"`
+	Recurrence   Recurrence  `json:"recurrence,omitempty"`
+	Timestamp    string      `json:"timestamp"`
 }
 
 type Tasks []Task
 
+// TaskPatch changes the fields of a task that aren't nil. An empty
+// AssigneeID unassigns the task and an empty DueAt clears the due date.
+type TaskPatch struct {
+	Content    *TaskContent
+	Completed  *bool
+	AssigneeID *UserID
+	DueAt      *string
+	Recurrence *Recurrence
+}
+
+// Validate checks the fields the patch sets, without looking at the task.
+// It returns a ValidationError with every field that's wrong.
+func (p *TaskPatch) Validate() error {
+	var errs []*FieldError
+	if p.Content != nil && strings.TrimSpace(string(*p.Content)) == "" {
+		errs = append(errs, &FieldError{"content", ErrTaskContentRequired})
+	}
+	if p.DueAt != nil && *p.DueAt != "" {
+		if _, err := time.Parse(time.RFC3339, *p.DueAt); err != nil {
+			errs = append(errs, &FieldError{"dueAt", ErrDueDateInvalid})
+		}
+	}
+	if p.Recurrence != nil && !p.Recurrence.Valid() {
+		errs = append(errs, &FieldError{"recurrence", ErrRecurrenceInvalid})
+	}
+	return Validation(errs)
+}
+
+// TaskService methods take the acting user and check their role in the
+// task's project. Tasks without a project can only be used by their owner.
 type TaskService interface {
 	Tasks(id UserID) (*Tasks, error)
-	CreateTask(taskID TaskID, content TaskContent, userID UserID) error
-	EditTaskStatus(id TaskID, val bool) error
-	ToggleAll(val bool) error
-	EditTask(id TaskID, newContent TaskContent) error
-	DeleteTask(id TaskID) error
-	ClearCompleted() error
+	ProjectTasks(id ProjectID, userID UserID) (*Tasks, error)
+	AssignedTasks(userID UserID) (*Tasks, error)
+	Task(id TaskID, userID UserID) (*Task, error)
+	CreateTask(taskID TaskID, content TaskContent, userID UserID, projectID ProjectID) error
+	EditTaskStatus(id TaskID, val bool, userID UserID) error
+	ToggleAll(val bool, userID UserID, projectID ProjectID) error
+	EditTask(id TaskID, newContent TaskContent, userID UserID) error
+	AssignTask(id TaskID, assigneeID UserID, userID UserID) error
+	SetDueDate(id TaskID, dueAt string, recurrence Recurrence, userID UserID) error
+	PatchTask(id TaskID, patch *TaskPatch, userID UserID) (*Task, error)
+	// PatchTaskFunc makes the patch from the task with makePatch while the
+	// task is locked, so nothing can change it in between.
+	PatchTaskFunc(id TaskID, makePatch func(task *Task) (*TaskPatch, error), userID UserID) (*Task, error)
+	RunBatch(ops []BatchOp, atomic bool, userID UserID) ([]BatchResult, error)
+	DeleteTask(id TaskID, userID UserID) error
+	ClearCompleted(userID UserID, projectID ProjectID) error
+}
+
+// BatchOpType is what an operation in a batch does to its task.
+type BatchOpType string
+
+const (
+	BatchCreate BatchOpType = "create"
+	BatchPatch  BatchOpType = "patch"
+	BatchDelete BatchOpType = "delete"
+	// BatchMove moves a task into ProjectID, or out of its project when
+	// ProjectID is empty.
+	BatchMove BatchOpType = "move"
+)
+
+// BatchOp is one operation of a batch. Content is only used to create a
+// task and Patch to patch one.
+type BatchOp struct {
+	Type      BatchOpType
+	ID        TaskID
+	Content   TaskContent
+	ProjectID ProjectID
+	Patch     *TaskPatch
+}
+
+// BatchResult is what happened to one operation of a batch. Task is the
+// task after the operation, nil if it failed or deleted the task.
+type BatchResult struct {
+	Task *Task
+	Err  error
+}
+
+// Recurrence is how often a task with a due date repeats. After each due
+// date passes the task is due again one period later.
+type Recurrence string
+
+const (
+	RecurrenceNone    Recurrence = ""
+	RecurrenceDaily   Recurrence = "daily"
+	RecurrenceWeekly  Recurrence = "weekly"
+	RecurrenceMonthly Recurrence = "monthly"
+)
+
+func (r Recurrence) Valid() bool {
+	switch r {
+	case RecurrenceNone, RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly:
+		return true
+	}
+	return false
+}
+
+// Next returns the occurrence after due. A task that doesn't repeat has no
+// next occurrence and due is returned unchanged.
+func (r Recurrence) Next(due time.Time) time.Time {
+	switch r {
+	case RecurrenceDaily:
+		return due.AddDate(0, 0, 1)
+	case RecurrenceWeekly:
+		return due.AddDate(0, 0, 7)
+	case RecurrenceMonthly:
+		return due.AddDate(0, 1, 0)
+	}
+	return due
+}
+
+type ReminderID string
+
+// Channels a reminder can be sent over.
+const (
+	ChannelInApp   = "inapp"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Reminder fires either MinutesBefore the task is due, or at a time of day
+// ("09:00") on the day it is due in the reminder's timezone. A user can set
+// several reminders on each task they can see.
+type Reminder struct {
+	ID            ReminderID `json:"id"`
+	TaskID        TaskID     `json:"taskId"`
+	UserID        UserID     `json:"userId"`
+	MinutesBefore int        `json:"minutesBefore,omitempty"`
+	At            string     `json:"at,omitempty"`
+	Timezone      string     `json:"timezone,omitempty"`
+	Channels      []string   `json:"channels"`
+	FireAt        string     `json:"fireAt,omitempty"`
+	Timestamp     string     `json:"timestamp"`
+}
+
+type Reminders []Reminder
+
+// FireTime returns when the reminder fires for a task due at due.
+func (r *Reminder) FireTime(due time.Time) (time.Time, error) {
+	if r.At == "" {
+		return due.Add(-time.Duration(r.MinutesBefore) * time.Minute), nil
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return time.Time{}, ErrTimezoneInvalid
+	}
+	at, err := time.Parse("15:04", r.At)
+	if err != nil {
+		return time.Time{}, ErrReminderTimeInvalid
+	}
+	day := due.In(loc)
+	return time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, loc), nil
+}
+
+// maxOccurrences bounds the search for the next occurrence of a task whose
+// first due date is far in the past.
+const maxOccurrences = 10000
+
+// NextFire returns the first time after after that the reminder fires for a
+// task first due at due, and the occurrence it fires for. ok is false when
+// the reminder won't fire again.
+func (r *Reminder) NextFire(due time.Time, recurrence Recurrence, after time.Time) (fire time.Time, occurrence time.Time, ok bool, err error) {
+	for i := 0; i < maxOccurrences; i++ {
+		fire, err = r.FireTime(due)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		if fire.After(after) {
+			return fire, due, true, nil
+		}
+		if recurrence == RecurrenceNone {
+			break
+		}
+		due = recurrence.Next(due)
+	}
+	return time.Time{}, time.Time{}, false, nil
+}
+
+// DueReminder is a reminder that has fired, along with the task and the
+// occurrence it is for.
+type DueReminder struct {
+	Reminder
+	Task       Task   `json:"task"`
+	Occurrence string `json:"occurrence"`
+	Email      Email  `json:"-"`
+}
+
+type DueReminders []DueReminder
+
+// Notifier sends due reminders over one channel.
+type Notifier interface {
+	Notify(r *DueReminder) error
+}
+
+// ReminderService methods take the acting user last. ClaimReminders and
+// ReminderSent are used by the scheduler.
+type ReminderService interface {
+	Reminders(taskID TaskID, userID UserID) (*Reminders, error)
+	CreateReminder(taskID TaskID, r *Reminder, userID UserID) (ReminderID, error)
+	DeleteReminder(id ReminderID, userID UserID) error
+	ClaimReminders(limit int, lease time.Duration) (*DueReminders, error)
+	ReminderSent(id ReminderID, occurrence string) error
+}
+
+type CommentID string
+
+// Comment bodies are Markdown, they are stored as written and rendered by
+// clients.
+type Comment struct {
+	ID        CommentID `json:"id"`
+	TaskID    TaskID    `json:"taskId"`
+	AuthorID  UserID    `json:"authorId"`
+	Body      string    `json:"body"`
+	Mentions  []UserID  `json:"mentions"`
+	Timestamp string    `json:"timestamp"`
+	EditedAt  string    `json:"editedAt,omitempty"`
+}
+
+type Comments []Comment
+
+// CommentService methods take the acting user last. Anyone who can see a
+// task can read its comments, commenters and above can write them.
+type CommentService interface {
+	Comments(taskID TaskID, userID UserID) (*Comments, error)
+	CreateComment(taskID TaskID, body string, userID UserID) (CommentID, error)
+	EditComment(id CommentID, body string, userID UserID) error
+	DeleteComment(id CommentID, userID UserID) error
+}
+
+type ProjectID string
+type InvitationID string
+type Role string
+
+// Project roles, each role can do everything the roles below it can.
+const (
+	RoleOwner     Role = "owner"
+	RoleEditor    Role = "editor"
+	RoleCommenter Role = "commenter"
+	RoleViewer    Role = "viewer"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool { return roleRank[r] > 0 }
+
+// AtLeast reports whether r grants everything min does.
+func (r Role) AtLeast(min Role) bool { return r.Valid() && roleRank[r] >= roleRank[min] }
+
+type Project struct {
+	ID        ProjectID `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   UserID    `json:"ownerId"`
+	Role      Role      `json:"role"`
+	Timestamp string    `json:"timestamp"`
+}
+
+type Projects []Project
+
+type Member struct {
+	UserID    UserID `json:"userId"`
+	Email     Email  `json:"email"`
+	Role      Role   `json:"role"`
+	Timestamp string `json:"timestamp"`
+}
+
+type Members []Member
+
+type Invitation struct {
+	ID          InvitationID `json:"id"`
+	ProjectID   ProjectID    `json:"projectId"`
+	ProjectName string       `json:"projectName"`
+	Email       Email        `json:"email"`
+	Role        Role         `json:"role"`
+	InvitedBy   UserID       `json:"invitedBy"`
+	Timestamp   string       `json:"timestamp"`
+}
+
+type Invitations []Invitation
+
+// ProjectService methods take the acting user last and check their role
+// in the project.
+type ProjectService interface {
+	Projects(userID UserID) (*Projects, error)
+	CreateProject(name string, userID UserID) (ProjectID, error)
+	Role(id ProjectID, userID UserID) (Role, error)
+	Members(id ProjectID, userID UserID) (*Members, error)
+	InviteMember(id ProjectID, email Email, role Role, userID UserID) (InvitationID, error)
+	Invitations(userID UserID) (*Invitations, error)
+	AcceptInvitation(id InvitationID, userID UserID) error
+	DeclineInvitation(id InvitationID, userID UserID) error
+	ChangeRole(id ProjectID, memberID UserID, role Role, userID UserID) error
+	LeaveProject(id ProjectID, userID UserID) error
+	TransferOwnership(id ProjectID, newOwnerID UserID, userID UserID) error
+}
+
+type EventType string
+
+// Task event types, sent to the owner of the task or every member of its
+// project.
+const (
+	EventTaskCreated  EventType = "task.created"
+	EventTaskUpdated  EventType = "task.updated"
+	EventTaskStatus   EventType = "task.status"
+	EventTasksToggled EventType = "tasks.toggled"
+	EventTaskDeleted  EventType = "task.deleted"
+	EventTasksCleared EventType = "tasks.cleared"
+	EventTaskAssigned EventType = "task.assigned"
+)
+
+// EventTaskDueSoon is sent by reminders to the user who set them.
+const EventTaskDueSoon EventType = "task.due_soon"
+
+// Comment event types. Mentions are only sent to the user mentioned.
+const (
+	EventCommentCreated EventType = "comment.created"
+	EventCommentEdited  EventType = "comment.edited"
+	EventCommentDeleted EventType = "comment.deleted"
+	EventMentioned      EventType = "comment.mentioned"
+)
+
+// Project event types, sent to every member of the project.
+const (
+	EventMemberInvited        EventType = "project.member_invited"
+	EventMemberJoined         EventType = "project.member_joined"
+	EventMemberLeft           EventType = "project.member_left"
+	EventRoleChanged          EventType = "project.role_changed"
+	EventOwnershipTransferred EventType = "project.ownership_transferred"
+)
+
+type EventID string
+
+// Event is a domain event recorded in the same transaction as the change
+// that caused it.
+type Event struct {
+	ID        EventID         `json:"id"`
+	Type      EventType       `json:"type"`
+	UserID    UserID          `json:"userId"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// EventHandler is called for every published event. Returning an error
+// from an outbox handler leaves the event to be published to it again, so
+// handlers must cope with seeing an event more than once.
+type EventHandler func(e Event) error
+
+// EventTypes lists every event type a webhook can subscribe to.
+var EventTypes = []EventType{
+	EventTaskCreated,
+	EventTaskUpdated,
+	EventTaskStatus,
+	EventTasksToggled,
+	EventTaskDeleted,
+	EventTasksCleared,
+	EventTaskAssigned,
+	EventTaskDueSoon,
+	EventCommentCreated,
+	EventCommentEdited,
+	EventCommentDeleted,
+	EventMentioned,
+	EventMemberInvited,
+	EventMemberJoined,
+	EventMemberLeft,
+	EventRoleChanged,
+	EventOwnershipTransferred,
+}
+
+type WebhookID string
+type DeliveryID string
+
+type Webhook struct {
+	ID        WebhookID   `json:"id"`
+	URL       string      `json:"url"`
+	Events    []EventType `json:"events"`
+	Active    bool        `json:"active"`
+	Failures  int         `json:"failures"`
+	Timestamp string      `json:"timestamp"`
+}
+
+type Webhooks []Webhook
+
+// internalNets are private address ranges, webhooks mustn't reach into the
+// network the server runs in.
+var internalNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// PublicIP reports whether webhooks may be delivered to ip. Loopback,
+// private and link-local addresses are refused, the last include cloud
+// metadata services such as 169.254.169.254.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+type WebhookDelivery struct {
+	ID           DeliveryID `json:"id"`
+	WebhookID    WebhookID  `json:"webhookId"`
+	Event        EventType  `json:"event"`
+	Payload      string     `json:"payload"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	ResponseCode int        `json:"responseCode,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	NextAttempt  string     `json:"nextAttempt"`
+	Timestamp    string     `json:"timestamp"`
+	URL          string     `json:"-"`
+	Secret       string     `json:"-"`
+}
+
+type WebhookDeliveries []WebhookDelivery
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookService interface {
+	Webhooks(userID UserID) (*Webhooks, error)
+	CreateWebhook(userID UserID, url string, events []EventType, secret string) (WebhookID, error)
+	EnableWebhook(id WebhookID, userID UserID) error
+	DeleteWebhook(id WebhookID, userID UserID) error
+	Deliveries(id WebhookID, userID UserID) (*WebhookDeliveries, error)
+	EnqueueDelivery(userID UserID, event EventType, payload string) error
+	ClaimDeliveries(limit int, lease time.Duration) (*WebhookDeliveries, error)
+	DeliverySucceeded(id DeliveryID, responseCode int) error
+	DeliveryFailed(id DeliveryID, responseCode int, deliveryErr string, retryAt time.Time, giveUp bool, disableAfter int) error
+}
+
+type NotificationID string
+
+// Notification is an entry in a user's inbox, created from an event that
+// concerns them.
+type Notification struct {
+	ID        NotificationID  `json:"id"`
+	Type      EventType       `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	Read      bool            `json:"read"`
+	Timestamp string          `json:"timestamp"`
+}
+
+type Notifications []Notification
+
+// NotificationTypes lists the event types that can create a notification.
+var NotificationTypes = []EventType{
+	EventTaskAssigned,
+	EventTaskDueSoon,
+	EventMentioned,
+	EventMemberInvited,
+	EventMemberJoined,
+	EventMemberLeft,
+	EventRoleChanged,
+	EventOwnershipTransferred,
+}
+
+// NotificationPreferences says whether each notification type is wanted.
+// Types that are missing are wanted.
+type NotificationPreferences map[EventType]bool
+
+type NotificationService interface {
+	Notifications(userID UserID, unreadOnly bool) (*Notifications, error)
+	UnreadCount(userID UserID) (int, error)
+	MarkRead(id NotificationID, userID UserID) error
+	MarkAllRead(userID UserID) error
+	Preferences(userID UserID) (NotificationPreferences, error)
+	SetPreference(userID UserID, event EventType, enabled bool) error
+	Notify(e Event) error
+}
+
+// Backoff doubles base for every attempt after the first, up to max.
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	wait := base
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	return wait
+}
+
+// Poll calls flush until stop is closed, for background workers that
+// process a queue in batches. A full batch means there is a backlog, so
+// flush is called again straight away, otherwise it waits for interval.
+// Errors are passed to logError.
+func Poll(stop <-chan struct{}, interval time.Duration, batchSize int, flush func() (int, error), logError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := flush()
+		if err != nil {
+			logError(err)
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if n == batchSize {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,68 @@
+package todo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	for attempt, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		5: 8 * time.Minute,
+		6: 10 * time.Minute,
+		9: 10 * time.Minute,
+	} {
+		if got := Backoff(base, max, attempt); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var calls int
+	batches := []int{3, 3, 1}
+	go func() {
+		defer close(done)
+		Poll(stop, time.Hour, 3, func() (int, error) {
+			calls++
+			n := batches[0]
+			if len(batches) > 1 {
+				batches = batches[1:]
+			} else {
+				close(stop)
+			}
+			return n, nil
+		}, func(err error) { t.Error(err) })
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Poll waited for the interval with a backlog, or didn't stop")
+	}
+	// Full batches are followed by another flush without waiting
+	if calls != 3 {
+		t.Errorf("flush called %d times, want 3", calls)
+	}
+}
+
+func TestLoginPolicyLockout(t *testing.T) {
+	p := LoginPolicy{Attempts: 3, Backoff: time.Minute, MaxBackoff: 10 * time.Minute}
+	for failures, want := range map[int]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Minute,
+		4:  2 * time.Minute,
+		6:  8 * time.Minute,
+		7:  10 * time.Minute,
+		20: 10 * time.Minute,
+	} {
+		if got := p.Lockout(failures); got != want {
+			t.Errorf("Lockout(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
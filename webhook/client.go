@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// NewClient returns the client deliveries are posted with. It only
+// connects to public addresses, checking what each url resolves to as it
+// dials so a name can't be pointed at the server's own network after the
+// webhook was created, and it doesn't follow redirects.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// A proxy would make the connection on our behalf, unchecked
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialPublic(ctx, dialer, network, addr)
+			},
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return todo.ErrWebhookRedirect
+		},
+	}
+}
+
+// dialPublic resolves addr and dials the first of its addresses, as long
+// as none of them are internal.
+func dialPublic(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if !todo.PublicIP(a.IP) {
+			return nil, todo.ErrWebhookAddressBlocked
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host}
+	}
+	// Dial the address that was checked rather than resolving the name again
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
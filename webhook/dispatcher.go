@@ -0,0 +1,183 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Todo-Signature"
+	TimestampHeader = "X-Todo-Timestamp"
+	EventHeader     = "X-Todo-Event"
+	DeliveryHeader  = "X-Todo-Delivery"
+)
+
+// MaxSignatureAge is how old a signed timestamp Verify accepts, so a
+// captured delivery can't be replayed later.
+const MaxSignatureAge = 5 * time.Minute
+
+// Dispatcher polls the delivery queue and posts signed payloads to webhook
+// urls, retrying failures with exponential backoff.
+type Dispatcher struct {
+	WebhookService todo.WebhookService
+	Client         *http.Client
+	Logger         *log.Logger
+	// How often the queue is polled when it is empty.
+	Interval time.Duration
+	// Deliveries claimed per poll.
+	BatchSize int
+	// How long a claimed delivery is hidden from other dispatchers.
+	Lease time.Duration
+	// Attempts before a delivery is marked as failed.
+	MaxAttempts int
+	// Consecutive failures before a webhook is disabled.
+	DisableAfter int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:       NewClient(),
+		Logger:       log.New(os.Stderr, "", log.LstdFlags),
+		Interval:     5 * time.Second,
+		BatchSize:    20,
+		Lease:        time.Minute,
+		MaxAttempts:  8,
+		DisableAfter: 20,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+	}
+}
+
+// Run delivers queued webhooks until stop is closed.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	todo.Poll(stop, d.Interval, d.BatchSize, d.Flush, func(err error) {
+		d.Logger.Printf("webhook error: %s", err)
+	})
+}
+
+// Flush claims one batch of due deliveries and attempts each of them. It
+// returns how many deliveries were attempted.
+func (d *Dispatcher) Flush() (int, error) {
+	deliveries, err := d.WebhookService.ClaimDeliveries(d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range *deliveries {
+		code, err := d.Deliver(&delivery)
+		if err == nil {
+			err = d.WebhookService.DeliverySucceeded(delivery.ID, code)
+		} else {
+			attempt := delivery.Attempts + 1
+			retryAt := time.Now().Add(todo.Backoff(d.BaseBackoff, d.MaxBackoff, attempt))
+			err = d.WebhookService.DeliveryFailed(delivery.ID, code, err.Error(), retryAt, attempt >= d.MaxAttempts, d.DisableAfter)
+		}
+		if err != nil {
+			d.Logger.Printf("webhook error: %s (delivery=%s)", err, delivery.ID)
+		}
+	}
+	return len(*deliveries), nil
+}
+
+// HandleEvent queues a delivery of the event for each webhook subscribed to
+// it.
+func (d *Dispatcher) HandleEvent(e todo.Event) error {
+	return d.WebhookService.EnqueueDelivery(e.UserID, e.Type, string(e.Payload))
+}
+
+// Notify queues a task.due_soon delivery of a reminder for each of the
+// user's webhooks subscribed to it.
+func (d *Dispatcher) Notify(r *todo.DueReminder) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.WebhookService.EnqueueDelivery(r.UserID, todo.EventTaskDueSoon, string(data))
+}
+
+type payload struct {
+	ID        todo.DeliveryID `json:"id"`
+	Event     todo.EventType  `json:"event"`
+	Timestamp string          `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Deliver posts a single delivery. Any response outside of 2xx is an error.
+func (d *Dispatcher) Deliver(delivery *todo.WebhookDelivery) (int, error) {
+	data := json.RawMessage(delivery.Payload)
+	if !json.Valid(data) {
+		data = json.RawMessage("null")
+	}
+	body, err := json.Marshal(&payload{
+		ID:        delivery.ID,
+		Event:     delivery.Event,
+		Timestamp: delivery.Timestamp,
+		Data:      data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-api-webhooks")
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, string(delivery.ID))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(delivery.Secret, timestamp, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp, a dot and the
+// body using the webhook secret. Receivers recompute it from the
+// X-Todo-Timestamp header and the body to check the X-Todo-Signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid "sha256=" signature of the
+// timestamp and body, and the timestamp is within MaxSignatureAge of now.
+func Verify(secret, timestamp string, body []byte, signature string, now time.Time) bool {
+	const prefix = "sha256="
+	if len(signature) <= len(prefix) || signature[:len(prefix)] != prefix {
+		return false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return false
+	}
+	return hmac.Equal([]byte(signature[len(prefix):]), []byte(Sign(secret, timestamp, body)))
+}
@@ -0,0 +1,377 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kennedymj97/todo-api"
+)
+
+// memoryService is a WebhookService holding a single webhook, it records
+// what the dispatcher reports about each delivery.
+type memoryService struct {
+	mu         sync.Mutex
+	hook       todo.Webhook
+	secret     string
+	deliveries []*memoryDelivery
+}
+
+type memoryDelivery struct {
+	todo.WebhookDelivery
+	retryAt time.Time
+}
+
+func newMemoryService(url, secret string) *memoryService {
+	return &memoryService{
+		hook:   todo.Webhook{ID: "hook", URL: url, Events: []todo.EventType{todo.EventTaskCreated}, Active: true},
+		secret: secret,
+	}
+}
+
+func (s *memoryService) Webhooks(userID todo.UserID) (*todo.Webhooks, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &todo.Webhooks{s.hook}, nil
+}
+
+func (s *memoryService) CreateWebhook(userID todo.UserID, url string, events []todo.EventType, secret string) (todo.WebhookID, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *memoryService) EnableWebhook(id todo.WebhookID, userID todo.UserID) error {
+	return errors.New("not implemented")
+}
+
+func (s *memoryService) DeleteWebhook(id todo.WebhookID, userID todo.UserID) error {
+	return errors.New("not implemented")
+}
+
+func (s *memoryService) Deliveries(id todo.WebhookID, userID todo.UserID) (*todo.WebhookDeliveries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := todo.WebhookDeliveries{}
+	for _, d := range s.deliveries {
+		deliveries = append(deliveries, d.WebhookDelivery)
+	}
+	return &deliveries, nil
+}
+
+func (s *memoryService) EnqueueDelivery(userID todo.UserID, event todo.EventType, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hook.Active {
+		return nil
+	}
+	s.deliveries = append(s.deliveries, &memoryDelivery{WebhookDelivery: todo.WebhookDelivery{
+		ID:        todo.DeliveryID(strconv.Itoa(len(s.deliveries) + 1)),
+		WebhookID: s.hook.ID,
+		Event:     event,
+		Payload:   payload,
+		Status:    todo.DeliveryPending,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}})
+	return nil
+}
+
+func (s *memoryService) ClaimDeliveries(limit int, lease time.Duration) (*todo.WebhookDeliveries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := todo.WebhookDeliveries{}
+	now := time.Now()
+	for _, d := range s.deliveries {
+		if len(deliveries) == limit || !s.hook.Active {
+			break
+		}
+		if d.Status != todo.DeliveryPending || d.retryAt.After(now) {
+			continue
+		}
+		d.retryAt = now.Add(lease)
+		claimed := d.WebhookDelivery
+		claimed.URL = s.hook.URL
+		claimed.Secret = s.secret
+		deliveries = append(deliveries, claimed)
+	}
+	return &deliveries, nil
+}
+
+func (s *memoryService) delivery(id todo.DeliveryID) *memoryDelivery {
+	for _, d := range s.deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *memoryService) DeliverySucceeded(id todo.DeliveryID, responseCode int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.delivery(id)
+	d.Status = todo.DeliveryDelivered
+	d.Attempts++
+	d.ResponseCode = responseCode
+	d.LastError = ""
+	s.hook.Failures = 0
+	return nil
+}
+
+func (s *memoryService) DeliveryFailed(id todo.DeliveryID, responseCode int, deliveryErr string, retryAt time.Time, giveUp bool, disableAfter int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.delivery(id)
+	if giveUp {
+		d.Status = todo.DeliveryFailed
+	}
+	d.Attempts++
+	d.ResponseCode = responseCode
+	d.LastError = deliveryErr
+	d.retryAt = retryAt
+	s.hook.Failures++
+	s.hook.Active = s.hook.Failures < disableAfter
+	return nil
+}
+
+// due makes every pending delivery due now instead of after its backoff.
+func (s *memoryService) due() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		d.retryAt = time.Time{}
+	}
+}
+
+// testDispatcher returns a dispatcher for the service that can reach the
+// loopback test server, NewClient refuses to.
+func testDispatcher(s todo.WebhookService, srv *httptest.Server) *Dispatcher {
+	d := NewDispatcher()
+	d.WebhookService = s
+	d.Client = srv.Client()
+	d.Logger = log.New(ioutil.Discard, "", 0)
+	return d
+}
+
+func TestDeliverSigned(t *testing.T) {
+	const secret = "shh"
+	var got payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify(secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader), time.Now()) {
+			t.Errorf("signature %q doesn't verify", r.Header.Get(SignatureHeader))
+		}
+		if r.Header.Get(EventHeader) != string(todo.EventTaskCreated) || r.Header.Get(DeliveryHeader) != "1" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("body %s: %s", body, err)
+		}
+	}))
+	defer srv.Close()
+	s := newMemoryService(srv.URL, secret)
+	d := testDispatcher(s, srv)
+
+	s.EnqueueDelivery("user", todo.EventTaskCreated, `{"id":"task"}`)
+	if n, err := d.Flush(); err != nil || n != 1 {
+		t.Fatalf("Flush() = %d, %v", n, err)
+	}
+	if got.ID != "1" || got.Event != todo.EventTaskCreated || string(got.Data) != `{"id":"task"}` {
+		t.Errorf("payload = %+v", got)
+	}
+	deliveries, _ := s.Deliveries("hook", "user")
+	if d := (*deliveries)[0]; d.Status != todo.DeliveryDelivered || d.Attempts != 1 || d.ResponseCode != http.StatusOK {
+		t.Errorf("delivery = %+v", d)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"id":"1"}`)
+	signature := "sha256=" + Sign("shh", timestamp, body)
+	old := strconv.FormatInt(now.Add(-MaxSignatureAge-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", "shh", timestamp, body, signature, true},
+		{"wrong secret", "other", timestamp, body, signature, false},
+		{"changed body", "shh", timestamp, []byte(`{"id":"2"}`), signature, false},
+		{"changed timestamp", "shh", strconv.FormatInt(now.Unix()-1, 10), body, signature, false},
+		{"replayed", "shh", old, body, "sha256=" + Sign("shh", old, body), false},
+		{"no prefix", "shh", timestamp, body, Sign("shh", timestamp, body), false},
+		{"no timestamp", "shh", "", body, "sha256=" + Sign("shh", "", body), false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.secret, tt.timestamp, tt.body, tt.signature, now); got != tt.want {
+			t.Errorf("%s: Verify() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlushRetriesWithBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	s := newMemoryService(srv.URL, "shh")
+	d := testDispatcher(s, srv)
+	d.BaseBackoff = time.Minute
+	d.MaxAttempts = 3
+
+	s.EnqueueDelivery("user", todo.EventTaskCreated, `{}`)
+	for attempt := 1; attempt <= 3; attempt++ {
+		start := time.Now()
+		if n, err := d.Flush(); err != nil || n != 1 {
+			t.Fatalf("attempt %d: Flush() = %d, %v", attempt, n, err)
+		}
+		delivery := s.deliveries[0]
+		wait := todo.Backoff(d.BaseBackoff, d.MaxBackoff, attempt)
+		if delivery.retryAt.Before(start.Add(wait)) || delivery.retryAt.After(time.Now().Add(wait)) {
+			t.Errorf("attempt %d: retry at %s, want %s from now", attempt, delivery.retryAt, wait)
+		}
+		if delivery.Attempts != attempt || delivery.ResponseCode != http.StatusServiceUnavailable || delivery.LastError == "" {
+			t.Errorf("attempt %d: delivery = %+v", attempt, delivery.WebhookDelivery)
+		}
+		// Nothing is due until the backoff has passed
+		if n, _ := d.Flush(); n != 0 {
+			t.Errorf("attempt %d: %d deliveries retried before their backoff", attempt, n)
+		}
+		s.due()
+	}
+	if status := s.deliveries[0].Status; status != todo.DeliveryFailed {
+		t.Errorf("status after %d attempts = %s, want %s", d.MaxAttempts, status, todo.DeliveryFailed)
+	}
+}
+
+func TestDisableAfterFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	s := newMemoryService(srv.URL, "shh")
+	d := testDispatcher(s, srv)
+	d.DisableAfter = 3
+
+	for i := 0; i < 5; i++ {
+		s.EnqueueDelivery("user", todo.EventTaskCreated, `{}`)
+	}
+	if n, err := d.Flush(); err != nil || n != 5 {
+		t.Fatalf("Flush() = %d, %v", n, err)
+	}
+	hooks, _ := s.Webhooks("user")
+	if hook := (*hooks)[0]; hook.Active || hook.Failures != 5 {
+		t.Errorf("webhook = %+v, want it disabled", hook)
+	}
+	// A disabled webhook gets no more deliveries
+	s.due()
+	s.EnqueueDelivery("user", todo.EventTaskCreated, `{}`)
+	if n, _ := d.Flush(); n != 0 {
+		t.Errorf("%d deliveries sent to a disabled webhook", n)
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	s := newMemoryService(srv.URL, "shh")
+	d := testDispatcher(s, srv)
+
+	s.EnqueueDelivery("user", todo.EventTaskCreated, `{}`)
+	d.Flush()
+	deliveries, _ := s.Deliveries("hook", "user")
+	if got := (*deliveries)[0]; got.Status != todo.DeliveryPending || got.ResponseCode != http.StatusBadGateway || got.LastError != "unexpected response status 502" {
+		t.Errorf("after failure, delivery = %+v", got)
+	}
+
+	fail = false
+	s.due()
+	d.Flush()
+	deliveries, _ = s.Deliveries("hook", "user")
+	if got := (*deliveries)[0]; got.Status != todo.DeliveryDelivered || got.Attempts != 2 || got.ResponseCode != http.StatusOK || got.LastError != "" {
+		t.Errorf("after retry, delivery = %+v", got)
+	}
+	hooks, _ := s.Webhooks("user")
+	if hook := (*hooks)[0]; hook.Failures != 0 {
+		t.Errorf("failures after a delivery = %d, want 0", hook.Failures)
+	}
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer srv.Close()
+	for _, url := range []string{
+		srv.URL,
+		"http://localhost:" + srv.URL[len("http://127.0.0.1:"):],
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+		"http://[::1]/",
+	} {
+		resp, err := NewClient().Post(url, "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, todo.ErrWebhookAddressBlocked) {
+			t.Errorf("POST %s: err = %v, want %v", url, err, todo.ErrWebhookAddressBlocked)
+		}
+	}
+}
+
+func TestClientRefusesRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+	}))
+	defer srv.Close()
+	client := NewClient()
+	// Let the client reach the loopback server to see what it does with
+	// the redirect
+	client.Transport = srv.Client().Transport
+	resp, err := client.Post(srv.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, todo.ErrWebhookRedirect) {
+		t.Errorf("err = %v, want %v", err, todo.ErrWebhookRedirect)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := todo.PublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("PublicIP(%s) = %t, want %t", ip, got, want)
+		}
+	}
+}